@@ -0,0 +1,90 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// converters maps a reflect.Type to the function registered with RegisterConverter.
+var converters sync.Map
+
+// decoders maps a reflect.Type to the function registered with RegisterDecoder.
+var decoders sync.Map
+
+// RegisterConverter registers the function rendering the values of type t, for the types of
+// other packages that cannot implement OsqueryMarshaler, e.g.
+//
+//	RegisterConverter(reflect.TypeFor[decimal.Decimal](), func(v reflect.Value) (string, error) {
+//		return v.Interface().(decimal.Decimal).StringFixed(2), nil
+//	})
+//
+// The function is used instead of the built-in conversions, including the TextMarshaler,
+// driver.Valuer and fmt.Stringer methods of the type, but not its MarshalOsquery method. It
+// receives the values after dereferencing pointers, and nil pointers are still rendered as
+// empty strings. Struct types with a converter are rendered as a single column instead of
+// being flattened, and their columns are TEXT.
+//
+// RegisterConverter is meant to be called from init functions, before the types are first
+// marshaled, and is safe for concurrent use. Registering a type again replaces its function,
+// and the function must be safe for concurrent use. It panics if t is nil or a pointer type,
+// or if fn is nil.
+func RegisterConverter(t reflect.Type, fn func(reflect.Value) (string, error)) {
+	checkRegisteredType("RegisterConverter", t, fn == nil)
+	converters.Store(t, fn)
+}
+
+// RegisterDecoder registers the function storing in the value of type t the column value
+// it was rendered as, used by UnmarshalMap. It is the counterpart of RegisterConverter, and
+// follows the same rules: the function is used instead of the built-in conversions, except
+// for the UnmarshalOsquery method of the type, and it receives the settable value after
+// allocating pointers. Like custom unmarshalers, it receives the raw value, including empty
+// strings for non-pointer fields.
+func RegisterDecoder(t reflect.Type, fn func(s string, v reflect.Value) error) {
+	checkRegisteredType("RegisterDecoder", t, fn == nil)
+	decoders.Store(t, fn)
+}
+
+// checkRegisteredType panics if the type t or the function registered for it by the function
+// named name are invalid.
+func checkRegisteredType(name string, t reflect.Type, nilFunc bool) {
+	switch {
+	case t == nil:
+		panic(fmt.Sprintf("encoding: %s requires a type", name))
+	case t.Kind() == reflect.Ptr:
+		panic(fmt.Sprintf("encoding: %s requires a non-pointer type, got %s", name, t))
+	case nilFunc:
+		panic(fmt.Sprintf("encoding: %s for %s requires a function", name, t))
+	}
+}
+
+// lookupConverter returns the function registered for the type t with RegisterConverter,
+// if any.
+func lookupConverter(t reflect.Type) (func(reflect.Value) (string, error), bool) {
+	fn, ok := converters.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return fn.(func(reflect.Value) (string, error)), true
+}
+
+// lookupDecoder returns the function registered for the type t with RegisterDecoder, if any.
+func lookupDecoder(t reflect.Type) (func(string, reflect.Value) error, bool) {
+	fn, ok := decoders.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return fn.(func(string, reflect.Value) error), true
+}
+
+// hasConverter reports whether the type t has a function registered with RegisterConverter or
+// RegisterDecoder, and is then converted as a single value.
+func hasConverter(t reflect.Type) bool {
+	_, encodes := converters.Load(t)
+	_, decodes := decoders.Load(t)
+	return encodes || decodes
+}
@@ -0,0 +1,142 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// testMoney stands for a type of another package, like a decimal type, whose String method
+// doesn't render it as wanted.
+type testMoney struct {
+	cents int64
+}
+
+func (m testMoney) String() string {
+	return fmt.Sprintf("%d cents", m.cents)
+}
+
+func init() {
+	RegisterConverter(reflect.TypeFor[testMoney](), func(v reflect.Value) (string, error) {
+		m := v.Interface().(testMoney)
+		if m.cents < 0 {
+			return "", fmt.Errorf("negative amount")
+		}
+		return fmt.Sprintf("%d.%02d", m.cents/100, m.cents%100), nil
+	})
+	RegisterDecoder(reflect.TypeFor[testMoney](), func(s string, v reflect.Value) error {
+		if s == "" {
+			v.SetZero()
+			return nil
+		}
+		units, cents, _ := strings.Cut(s, ".")
+		n, err := strconv.ParseInt(units+cents, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %q", s)
+		}
+		v.Set(reflect.ValueOf(testMoney{cents: n}))
+		return nil
+	})
+	// MarshalOsquery methods take precedence over the converters
+	RegisterConverter(reflect.TypeFor[testVersion](), func(reflect.Value) (string, error) {
+		return "converted", nil
+	})
+}
+
+type testInvoice struct {
+	Total   testMoney   `osquery:"total"`
+	Refund  *testMoney  `osquery:"refund"`
+	Items   []testMoney `osquery:"items"`
+	Version testVersion `osquery:"version"`
+}
+
+func TestRegisterConverter(t *testing.T) {
+	in := testInvoice{
+		Total:  testMoney{cents: 1250},
+		Refund: &testMoney{cents: 5},
+		Items:  []testMoney{{cents: 1000}, {cents: 250}},
+	}
+	expected := map[string]string{
+		"total":   "12.50",
+		"refund":  "0.05",
+		"items":   "10.00,2.50",
+		"version": "0.0",
+	}
+
+	got, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMap() = %v; expected %v", got, expected)
+	}
+
+	if _, err := MarshalToMap(testInvoice{Total: testMoney{cents: -1}}); err == nil {
+		t.Errorf("MarshalToMap() with a failing converter succeeded; expected an error")
+	}
+
+	// Struct types with a converter are single columns
+	columns, err := GenerateColumnDefinitions(testInvoice{})
+	if err != nil {
+		t.Fatalf("GenerateColumnDefinitions() failed: %v", err)
+	}
+	expectedColumns := []table.ColumnDefinition{
+		table.TextColumn("total"),
+		table.TextColumn("refund"),
+		table.TextColumn("items"),
+		table.TextColumn("version"),
+	}
+	if !reflect.DeepEqual(columns, expectedColumns) {
+		t.Errorf("GenerateColumnDefinitions() = %v; expected %v", columns, expectedColumns)
+	}
+	if err := Validate(testInvoice{}); err != nil {
+		t.Errorf("Validate() = %v; expected no error", err)
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	var out testInvoice
+	in := map[string]string{"total": "12.50", "refund": "0.05"}
+	if err := UnmarshalMap(in, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	if out.Total.cents != 1250 || out.Refund == nil || out.Refund.cents != 5 {
+		t.Errorf("UnmarshalMap() = %+v", out)
+	}
+
+	if err := UnmarshalMap(map[string]string{"total": "twelve"}, &out); err == nil {
+		t.Errorf("UnmarshalMap() with an invalid amount succeeded; expected an error")
+	}
+}
+
+func TestRegisterConverter_panics(t *testing.T) {
+	convert := func(reflect.Value) (string, error) { return "", nil }
+	tests := []struct {
+		name     string
+		register func()
+	}{
+		{name: "nil type", register: func() { RegisterConverter(nil, convert) }},
+		{name: "pointer type", register: func() { RegisterConverter(reflect.TypeFor[*testMoney](), convert) }},
+		{name: "nil function", register: func() { RegisterConverter(reflect.TypeFor[testMoney](), nil) }},
+		{name: "nil decoder", register: func() { RegisterDecoder(reflect.TypeFor[testMoney](), nil) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering did not panic")
+				}
+			}()
+			tt.register()
+		})
+	}
+}
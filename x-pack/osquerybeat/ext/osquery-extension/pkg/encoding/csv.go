@@ -0,0 +1,56 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WriteCSV writes rows, as returned by MarshalRows, to w in CSV format. The first record is
+// the header, which sets the columns and their order. Row keys missing from the header are
+// not written, and header columns missing from a row are written as empty fields. If header
+// is nil, the columns are the sorted union of the keys of all the rows.
+func WriteCSV(w io.Writer, rows []map[string]string, header []string) error {
+	if header == nil {
+		header = rowKeys(rows)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	record := make([]string, len(header))
+	for i, row := range rows {
+		for j, column := range header {
+			record[j] = row[column]
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row %d: %w", i, err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// rowKeys returns the sorted union of the keys of rows.
+func rowKeys(rows []map[string]string) []string {
+	seen := make(map[string]struct{})
+	keys := []string{}
+	for _, row := range rows {
+		for key := range row {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
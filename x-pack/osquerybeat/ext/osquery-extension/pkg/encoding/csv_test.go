@@ -0,0 +1,91 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	rows := []map[string]string{
+		{"pid": "1", "name": "init"},
+		{"pid": "2", "name": "bash, login", "cmdline": `bash -c "ls"`},
+	}
+
+	tests := []struct {
+		name     string
+		rows     []map[string]string
+		header   []string
+		expected string
+	}{
+		{
+			name:   "header sets the columns",
+			rows:   rows,
+			header: []string{"name", "pid", "user"},
+			expected: "name,pid,user\n" +
+				"init,1,\n" +
+				"\"bash, login\",2,\n",
+		},
+		{
+			name: "header derived from the rows",
+			rows: rows,
+			expected: "cmdline,name,pid\n" +
+				",init,1\n" +
+				"\"bash -c \"\"ls\"\"\",\"bash, login\",2\n",
+		},
+		{
+			name:     "no rows",
+			rows:     nil,
+			header:   []string{"pid"},
+			expected: "pid\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := WriteCSV(&b, tt.rows, tt.header); err != nil {
+				t.Fatalf("WriteCSV() failed: %v", err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("WriteCSV() wrote %q; expected %q", b.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteCSV_marshalRows(t *testing.T) {
+	type process struct {
+		PID  int    `osquery:"pid"`
+		Name string `osquery:"name"`
+	}
+
+	rows, err := MarshalRows([]process{{PID: 1, Name: "init"}, {PID: 2, Name: "bash"}}, 0)
+	if err != nil {
+		t.Fatalf("MarshalRows() failed: %v", err)
+	}
+	var b strings.Builder
+	if err := WriteCSV(&b, rows, []string{"pid", "name"}); err != nil {
+		t.Fatalf("WriteCSV() failed: %v", err)
+	}
+	if expected := "pid,name\n1,init\n2,bash\n"; b.String() != expected {
+		t.Errorf("WriteCSV() wrote %q; expected %q", b.String(), expected)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteCSV_writeError(t *testing.T) {
+	err := WriteCSV(failingWriter{}, []map[string]string{{"pid": "1"}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("WriteCSV() error = %v; expected the write error", err)
+	}
+}
@@ -0,0 +1,849 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"database/sql"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// OsqueryUnmarshaler is the interface implemented by types that can parse themselves
+// from an osquery column value. It takes precedence over the built-in conversions.
+type OsqueryUnmarshaler interface {
+	UnmarshalOsquery(string) error
+}
+
+var osqueryUnmarshalerType = reflect.TypeFor[OsqueryUnmarshaler]()
+
+// UnmarshalMap populates the struct pointed to by out from a map[string]string,
+// such as an osquery row. Keys are matched to struct fields using the same
+// "osquery" tag resolution as MarshalToMap.
+// Fields implementing OsqueryUnmarshaler are parsed with their UnmarshalOsquery method,
+// and fields implementing encoding.TextUnmarshaler with their UnmarshalText method, except
+// for time.Time fields, which are parsed according to the tag and flags. Empty values decode
+// to invalid database/sql nullable types, like sql.NullString, and fields implementing
+// sql.Scanner are parsed with their Scan method otherwise. Fields of the types registered with
+// RegisterEnum accept the registered names, and the types registered with RegisterDecoder are
+// parsed by their function. Interface fields, like any, are set to the value as a string, as
+// its dynamic type is not rendered, or to nil when it is empty, and an error is returned when
+// they cannot hold a string. Empty values decode to the zero value of the
+// field, as the encoder renders zero numbers as empty strings by default, unless
+// EncodingFlagStrictNumericParse or EncodingFlagEmptyStringAsError is set.
+//
+// Slice and array fields are split on the separator of their "sep" option, or on commas, and
+// each element is decoded like a field, e.g. "22,443" into a []int. With EncodingFlagJSONSlices
+// or EncodingFlagJSONComplex, their values are unmarshaled as JSON instead. Slices and arrays
+// of structs are always decoded from the JSON rows of their elements. Byte slices and arrays
+// are decoded from base64, or from the encoding selected by EncodingFlagBytesHex or
+// EncodingFlagBytesRaw. Empty values decode to nil slices.
+//
+// With EncodingFlagCaseInsensitiveKeys, a field whose key is missing is set from a key
+// differing only by case, e.g. "PID" for `osquery:"pid"`. Exact matches always win, so fields
+// whose keys differ only by case are each set from their own key when present, and both set
+// from a key matching them ignoring case otherwise. An error is returned when several keys
+// match a missing key ignoring case, e.g. "Pid" and "PID".
+//
+// Map fields are populated from the keys under the field key followed by a dot, as flattened
+// by the encoder, e.g. "labels.env" into the "env" entry of a map[string]string field tagged
+// `osquery:"labels"`. The map is allocated when at least one such key is present, and its keys
+// and values are decoded like fields. Their entries are added to the map, while maps of
+// structs or maps are left untouched.
+//
+// Keys without a matching field are added to the map[string]string field of the struct
+// pointed to by out tagged with the "remaining" option, e.g. `osquery:",remaining"`, if any.
+// The map is allocated when needed, and an error is returned if several fields have the
+// option. MarshalToMap renders such a field as an inline map, so that these keys survive a
+// round trip. Otherwise, these keys are ignored, or reported as an error with
+// EncodingFlagDisallowUnknownKeys.
+func UnmarshalMap(in map[string]string, out any) error {
+	return UnmarshalMapWithFlags(in, out, 0)
+}
+
+// UnmarshalMapWithFlags is like UnmarshalMap but accepts encoding flags. Using the same flags
+// that were passed to MarshalToMapWithFlags makes a marshal/unmarshal round trip lossless. With
+// EncodingFlagLowercaseKeys, the keys of the fields are lowercased before being matched, but
+// the entries of map fields keep the lowercased keys. Rows whose keys were transformed by the
+// KeyFunc option of an Encoder cannot be decoded, as the function cannot be reversed: their
+// keys only match the fields they were left unchanged for.
+func UnmarshalMapWithFlags(in map[string]string, out any, flags EncodingFlag) error {
+	if out == nil {
+		return fmt.Errorf("output cannot be nil")
+	}
+
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("output must be a pointer to a struct, got %s", v.Kind())
+	}
+	if v.IsNil() {
+		return fmt.Errorf("output pointer is nil")
+	}
+
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported type: %s, must be a pointer to a struct", v.Kind())
+	}
+
+	remaining, err := remainingField(v.Type())
+	if err != nil {
+		return err
+	}
+
+	d := newDecodeState(in, flags)
+	if remaining >= 0 || flags.has(EncodingFlagDisallowUnknownKeys) {
+		d.used = make(map[string]bool, len(in))
+	}
+	if err := d.unmarshalStruct(v, "", nil); err != nil {
+		return err
+	}
+	switch {
+	case remaining >= 0:
+		d.setRemaining(v.Field(remaining))
+	case flags.has(EncodingFlagDisallowUnknownKeys):
+		if unknown := d.unknownKeys(); len(unknown) > 0 {
+			return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+		}
+	}
+	return nil
+}
+
+// remainingField returns the index of the field of the struct type t with the "remaining"
+// option, or -1 if there is none.
+func remainingField(t reflect.Type) (int, error) {
+	index := -1
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !hasTagOption(&field.Tag, "remaining") {
+			continue
+		}
+		if index >= 0 {
+			return -1, fmt.Errorf("fields %s and %s both have the remaining option", t.Field(index).Name, field.Name)
+		}
+		if field.Type != stringMapType {
+			return -1, fmt.Errorf("field %s with the remaining option must be a map[string]string, got %s", field.Name, field.Type)
+		}
+		index = i
+	}
+	return index, nil
+}
+
+var (
+	stringMapType = reflect.TypeFor[map[string]string]()
+	stringType    = reflect.TypeFor[string]()
+)
+
+// decodeState holds the state of a single call to UnmarshalMapWithFlags.
+type decodeState struct {
+	in    map[string]string
+	flags EncodingFlag
+
+	// used, when non-nil, records the keys of in decoded into a field.
+	used map[string]bool
+
+	// folded maps the lowercased keys of in to the keys, with EncodingFlagCaseInsensitiveKeys.
+	folded map[string][]string
+}
+
+// newDecodeState returns the state of the decoding of in with the given flags.
+func newDecodeState(in map[string]string, flags EncodingFlag) *decodeState {
+	d := &decodeState{in: in, flags: flags}
+	if flags.has(EncodingFlagCaseInsensitiveKeys) {
+		d.folded = make(map[string][]string, len(in))
+		for key := range in {
+			folded := strings.ToLower(key)
+			d.folded[folded] = append(d.folded[folded], key)
+		}
+	}
+	return d
+}
+
+// column returns the key of the input rendered for key, lowercased with
+// EncodingFlagLowercaseKeys like the encoder does.
+func (d *decodeState) column(key string) string {
+	if d.flags.has(EncodingFlagLowercaseKeys) {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// find returns the key of the input matching key. With EncodingFlagCaseInsensitiveKeys, a key
+// differing only by case is used when key is missing, and an error is returned when there
+// are several of them.
+func (d *decodeState) find(key string) (string, bool, error) {
+	key = d.column(key)
+	if _, ok := d.in[key]; ok {
+		return key, true, nil
+	}
+	matches := d.folded[strings.ToLower(key)]
+	switch len(matches) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return matches[0], true, nil
+	default:
+		sort.Strings(matches)
+		return "", false, fmt.Errorf("keys %s all match column %s ignoring case", strings.Join(matches, ", "), key)
+	}
+}
+
+// lookup returns the value of the key of the input matching key, as found by find, and
+// records that it is used.
+func (d *decodeState) lookup(key string) (string, bool, error) {
+	match, ok, err := d.find(key)
+	if !ok {
+		return "", false, err
+	}
+	if d.used != nil {
+		d.used[match] = true
+	}
+	return d.in[match], true, nil
+}
+
+// unknownKeys returns the sorted keys of the input not decoded into a field.
+func (d *decodeState) unknownKeys() []string {
+	var keys []string
+	for key := range d.in {
+		if !d.used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setRemaining adds the keys of the input not decoded into a field to the map v, allocating
+// it when there is at least one.
+func (d *decodeState) setRemaining(v reflect.Value) {
+	for key, value := range d.in {
+		if d.used[key] {
+			continue
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+	}
+}
+
+// unmarshalStruct sets the exported fields of the struct v from the matching keys of the input.
+// Nested struct fields are populated from keys under the parent key followed by a dot,
+// allocating nil pointers only when at least one such key is present. The fields of
+// embedded structs are populated from the parent keys, as promoted by the encoder, unless
+// they are shadowed by a field of an outer struct.
+func (d *decodeState) unmarshalStruct(v reflect.Value, prefix string, shadowed map[string]bool) error {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		fieldType := t.Field(i)
+		// The remaining field is set from the keys left once all the other fields are set
+		if hasTagOption(&fieldType.Tag, "remaining") {
+			continue
+		}
+
+		if isPromoted(fieldType, d.flags, defaultKeyTags) {
+			inner := d.shadowedKeys(t, prefix, shadowed)
+			// Embedded pointers are allocated only when one of the promoted fields is present
+			if fieldType.Type.Kind() == reflect.Ptr && !d.hasFieldKeys(fieldType.Type, prefix, inner) {
+				continue
+			}
+			if !canAlloc(v.Field(i)) {
+				return fmt.Errorf("cannot allocate embedded pointer to unexported type %s", fieldType.Type)
+			}
+			if err := d.unmarshalStruct(allocValue(v.Field(i)), prefix, inner); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key, ok := fieldKey(fieldType, d.flags, defaultKeyTags)
+		if !ok {
+			continue
+		}
+		key = prefix + key
+		if shadowed[key] {
+			continue
+		}
+
+		if isNestedStruct(fieldType.Type) {
+			nestedPrefix := key + "."
+			if !d.hasKeyWithPrefix(nestedPrefix) {
+				continue
+			}
+			if !canAlloc(v.Field(i)) {
+				return fmt.Errorf("cannot allocate embedded pointer to unexported type %s", fieldType.Type)
+			}
+			if err := d.unmarshalStruct(allocValue(v.Field(i)), nestedPrefix, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isFlattenedMap(fieldType.Type, d.flags) && !hasTagOption(&fieldType.Tag, "inline") {
+			if err := d.unmarshalMap(v.Field(i), key+".", &fieldType.Tag); err != nil {
+				return fmt.Errorf("failed to decode field %s: %w", key, err)
+			}
+			continue
+		}
+
+		value, ok, err := d.lookup(key)
+		if err != nil {
+			return fmt.Errorf("failed to decode field %s: %w", key, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setValueFromString(v.Field(i), value, d.flags, &fieldType.Tag); err != nil {
+			return fmt.Errorf("failed to decode field %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// unmarshalMap adds to the map field v the entries flattened by the encoder under prefix, e.g.
+// "labels.env" as the "env" entry of `osquery:"labels"`, allocating the map, or the pointer
+// to it, only when at least one such key is present. The keys and values are decoded like
+// fields, with the tag of the map field for the values. Maps of nested structs or maps are
+// not decoded, as their entries are flattened further.
+func (d *decodeState) unmarshalMap(v reflect.Value, prefix string, tag *reflect.StructTag) error {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isNestedStruct(t.Elem()) || isFlattenedMap(t.Elem(), d.flags) {
+		return nil
+	}
+
+	prefix = d.column(prefix)
+	foldCase := d.flags.has(EncodingFlagCaseInsensitiveKeys)
+	var m reflect.Value
+	for key, value := range d.in {
+		if len(key) <= len(prefix) || !strings.HasPrefix(key, prefix) && !(foldCase && strings.EqualFold(key[:len(prefix)], prefix)) {
+			continue
+		}
+		if !m.IsValid() {
+			if !canAlloc(v) {
+				return fmt.Errorf("cannot allocate pointer to unexported type %s", v.Type())
+			}
+			m = allocValue(v)
+			if m.IsNil() {
+				m.Set(reflect.MakeMap(t))
+			}
+		}
+
+		// Map keys are formatted without omitting zero numbers
+		k := reflect.New(t.Key()).Elem()
+		if err := setValueFromString(k, key[len(prefix):], d.flags|EncodingFlagUseNumbersZeroValues, nil); err != nil {
+			return fmt.Errorf("failed to decode key %s: %w", key, err)
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := setValueFromString(elem, value, d.flags, tag); err != nil {
+			return fmt.Errorf("failed to decode key %s: %w", key, err)
+		}
+		m.SetMapIndex(k, elem)
+		if d.used != nil {
+			d.used[key] = true
+		}
+	}
+	return nil
+}
+
+// shadowedKeys returns the keys that the fields of the structs embedded in the struct type t
+// cannot use: those of the fields of t itself, in addition to the ones already shadowed.
+func (d *decodeState) shadowedKeys(t reflect.Type, prefix string, shadowed map[string]bool) map[string]bool {
+	keys := make(map[string]bool, len(shadowed)+t.NumField())
+	for key := range shadowed {
+		keys[key] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if isPromoted(t.Field(i), d.flags, defaultKeyTags) {
+			continue
+		}
+		if key, ok := fieldKey(t.Field(i), d.flags, defaultKeyTags); ok {
+			keys[prefix+key] = true
+		}
+	}
+	return keys
+}
+
+// hasKeyWithPrefix reports whether any key of the input starts with prefix, ignoring case
+// with EncodingFlagCaseInsensitiveKeys.
+func (d *decodeState) hasKeyWithPrefix(prefix string) bool {
+	prefix = d.column(prefix)
+	foldCase := d.flags.has(EncodingFlagCaseInsensitiveKeys)
+	for key := range d.in {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+		if foldCase && len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFieldKeys reports whether the input holds the key of any field of the struct type t, or
+// of the structs nested in it, when stored under prefix. Shadowed keys are not considered.
+func (d *decodeState) hasFieldKeys(t reflect.Type, prefix string, shadowed map[string]bool) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isPromoted(field, d.flags, defaultKeyTags) {
+			if d.hasFieldKeys(field.Type, prefix, d.shadowedKeys(t, prefix, shadowed)) {
+				return true
+			}
+			continue
+		}
+
+		key, ok := fieldKey(field, d.flags, defaultKeyTags)
+		if !ok || shadowed[prefix+key] {
+			continue
+		}
+		// Ambiguous keys are reported when decoding the field
+		if _, ok, err := d.find(prefix + key); ok || err != nil {
+			return true
+		}
+		if (isNestedStruct(field.Type) || isFlattenedMap(field.Type, d.flags)) && d.hasKeyWithPrefix(prefix+key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// canAlloc reports whether allocValue can be called on v, which is not the case for nil
+// pointers stored in unexported embedded fields.
+func canAlloc(v reflect.Value) bool {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v.CanSet()
+		}
+		v = v.Elem()
+	}
+	return true
+}
+
+// allocValue follows pointers until it reaches a non-pointer value, allocating any nil
+// pointer found along the way.
+func allocValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// setValueFromString parses s according to the kind of fieldValue and stores the result.
+// It is the reverse of convertValueToString: empty strings decode to the zero value,
+// and pointers are allocated as needed (or set to nil for empty strings).
+func setValueFromString(fieldValue reflect.Value, s string, flags EncodingFlag, tag *reflect.StructTag) error {
+	flags = fieldFlags(flags, tag)
+
+	if fieldValue.Kind() == reflect.Ptr {
+		// The encoder renders nil pointers as empty strings regardless of the flags
+		if s == "" {
+			fieldValue.SetZero()
+			return nil
+		}
+		if fieldValue.IsNil() {
+			fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+		}
+		return setValueFromString(fieldValue.Elem(), s, flags, tag)
+	}
+
+	// Custom unmarshalers take precedence and receive the raw value, including empty strings
+	if u, ok := addrAsInterface[OsqueryUnmarshaler](fieldValue); ok {
+		return u.UnmarshalOsquery(s)
+	}
+	if decode, ok := lookupDecoder(fieldValue.Type()); ok {
+		return decode(s, fieldValue)
+	}
+
+	// Nullable database/sql types are invalid when empty, and hold the decoded value otherwise
+	if isSQLNull(fieldValue.Type()) {
+		if s == "" {
+			fieldValue.SetZero()
+			return nil
+		}
+		if err := setValueFromString(fieldValue.Field(0), s, flags, tag); err != nil {
+			return err
+		}
+		fieldValue.Field(1).SetBool(true)
+		return nil
+	}
+
+	// Empty strings are how the encoder represents zero values
+	if s == "" {
+		if flags.has(EncodingFlagEmptyStringAsError) && !emptyStringExpected(fieldValue.Kind(), flags) {
+			return fmt.Errorf("unexpected empty value for %s", fieldValue.Type())
+		}
+		if flags.has(EncodingFlagStrictNumericParse) && isNumberKind(fieldValue.Kind()) {
+			return fmt.Errorf("empty value for %s", fieldValue.Type())
+		}
+		fieldValue.SetZero()
+		return nil
+	}
+
+	// Registered enums accept their names as well as numbers
+	if enum, ok := lookupEnum(fieldValue.Type()); ok && enum.set(fieldValue, s) {
+		return nil
+	}
+
+	switch fieldValue.Type() {
+	case timeType:
+		// time.Time implements encoding.TextUnmarshaler, but its format is set by the tag and flags
+		t, err := parseTimeWithTagFormat(s, flags, tag)
+		if err != nil {
+			return err
+		}
+		fieldValue.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		unit, err := durationUnit(tag)
+		if err != nil {
+			return err
+		}
+		val, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid duration value %q: %w", s, err)
+		}
+		fieldValue.SetInt(int64(time.Duration(val) * unit))
+		return nil
+	case rawMessageType:
+		// json.RawMessage is a []byte, but holds serialized JSON rendered as is
+		fieldValue.SetBytes([]byte(s))
+		return nil
+	case jsonNumberType:
+		if !isJSONNumber(s) {
+			return fmt.Errorf("invalid number value %q", s)
+		}
+		fieldValue.SetString(s)
+		return nil
+	case hardwareAddrType:
+		mac, err := net.ParseMAC(s)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q: %w", s, err)
+		}
+		fieldValue.SetBytes(mac)
+		return nil
+	}
+
+	if u, ok := addrAsInterface[encoding.TextUnmarshaler](fieldValue); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	// sql.Scanner is the counterpart of driver.Valuer, used by the encoder
+	if u, ok := addrAsInterface[sql.Scanner](fieldValue); ok {
+		return u.Scan(s)
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.String:
+		fieldValue.SetString(s)
+
+	case reflect.Bool:
+		trueValue, falseValue, custom, err := boolStrings(tag)
+		if err != nil {
+			return err
+		}
+		switch {
+		case custom && strings.EqualFold(s, trueValue):
+			fieldValue.SetBool(true)
+			return nil
+		case custom && strings.EqualFold(s, falseValue):
+			fieldValue.SetBool(false)
+			return nil
+		}
+		val, ok := parseBool(s, flags)
+		if !ok {
+			return fmt.Errorf("invalid bool value %q", s)
+		}
+		fieldValue.SetBool(val)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Characters rendered by the "char" option are single runes, while the code points
+		// rendered as numbers have several digits
+		if r, size := utf8.DecodeRuneInString(s); fieldValue.Kind() == reflect.Int32 && hasTagOption(tag, "char") &&
+			size == len(s) && r != utf8.RuneError {
+			fieldValue.SetInt(int64(r))
+			return nil
+		}
+		base, prefix, err := integerBase(tag)
+		if err != nil {
+			return err
+		}
+		val, err := strconv.ParseInt(trimBasePrefix(s, prefix), base, fieldValue.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer value %q: %w", s, err)
+		}
+		fieldValue.SetInt(val)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		base, prefix, err := integerBase(tag)
+		if err != nil {
+			return err
+		}
+		val, err := strconv.ParseUint(trimBasePrefix(s, prefix), base, fieldValue.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer value %q: %w", s, err)
+		}
+		fieldValue.SetUint(val)
+
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(s, fieldValue.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid float value %q: %w", s, err)
+		}
+		fieldValue.SetFloat(val)
+
+	case reflect.Slice, reflect.Array:
+		return setSequenceFromString(fieldValue, s, flags, tag)
+
+	case reflect.Interface:
+		// The dynamic type is lost once rendered, interfaces hold the text of the value
+		if !stringType.AssignableTo(fieldValue.Type()) {
+			return fmt.Errorf("cannot store a string in %s", fieldValue.Type())
+		}
+		fieldValue.Set(reflect.ValueOf(s))
+
+	default:
+		return fmt.Errorf("unsupported type (%s)", fieldValue.Type())
+	}
+
+	return nil
+}
+
+// setSequenceFromString sets the slice or array fieldValue from the non-empty value s, as
+// rendered by the encoder with the same flags and tag: byte sequences are decoded from base64
+// or from the encoding selected by the flags, sequences of structs from the JSON rows of
+// their elements, JSON values with EncodingFlagJSONComplex or EncodingFlagJSONSlices are
+// unmarshaled, and the other values are split on the separator of
+// the "sep" option, or DefaultSliceSeparator. The elements are decoded like fields, using
+// the tag of the sequence. Arrays must receive as many elements as their length.
+func setSequenceFromString(fieldValue reflect.Value, s string, flags EncodingFlag, tag *reflect.StructTag) error {
+	t := fieldValue.Type()
+	if isByteSequence(t) && !hasTagOption(tag, "bytesasnums") {
+		b, err := decodeBytes(s, flags)
+		if err != nil {
+			return err
+		}
+		if t.Kind() == reflect.Array {
+			if len(b) != t.Len() {
+				return fmt.Errorf("invalid length %d for %s", len(b), t)
+			}
+			reflect.Copy(fieldValue, reflect.ValueOf(b))
+			return nil
+		}
+		fieldValue.SetBytes(b)
+		return nil
+	}
+
+	var elems []string
+	switch {
+	case isStructSequence(t):
+		// The elements are rendered as the JSON rows of their fields
+		var rows []map[string]string
+		if err := json.Unmarshal([]byte(s), &rows); err != nil {
+			return fmt.Errorf("invalid JSON value for %s: %w", t, err)
+		}
+		if err := resizeSequence(fieldValue, len(rows)); err != nil {
+			return err
+		}
+		for i, row := range rows {
+			if row == nil {
+				continue
+			}
+			// The keys of the rows of the elements are not lowercased by the encoder
+			if err := newDecodeState(row, flags&^EncodingFlagLowercaseKeys).unmarshalStruct(allocValue(fieldValue.Index(i)), "", nil); err != nil {
+				return fmt.Errorf("failed to decode element %d: %w", i, err)
+			}
+		}
+		return nil
+	case flags.has(EncodingFlagJSONComplex):
+		if err := json.Unmarshal([]byte(s), fieldValue.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid JSON value for %s: %w", t, err)
+		}
+		return nil
+	case flags.has(EncodingFlagJSONSlices):
+		var values []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &values); err != nil {
+			return fmt.Errorf("invalid JSON value for %s: %w", t, err)
+		}
+		elems = make([]string, len(values))
+		for i, value := range values {
+			elem, err := jsonSliceText(value)
+			if err != nil {
+				return fmt.Errorf("invalid JSON element %d for %s: %w", i, t, err)
+			}
+			elems[i] = elem
+		}
+	default:
+		sep := DefaultSliceSeparator
+		if tagSep, ok := lookupTagOption(tag, "sep"); ok {
+			sep = tagSep
+		}
+		if sep == "" {
+			return fmt.Errorf("cannot split values joined without a separator into %s", t)
+		}
+		elems = strings.Split(s, sep)
+	}
+
+	if err := resizeSequence(fieldValue, len(elems)); err != nil {
+		return err
+	}
+	// The encoder renders the zero elements as numbers, as they cannot be left out
+	flags |= EncodingFlagUseNumbersZeroValues
+	for i, elem := range elems {
+		if err := setValueFromString(fieldValue.Index(i), elem, flags, tag); err != nil {
+			return fmt.Errorf("failed to decode element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// jsonSliceText returns the text of the element of a JSON array rendered by the encoder with
+// EncodingFlagJSONSlices: the value of strings, and the JSON text of numbers and bools.
+func jsonSliceText(value json.RawMessage) (string, error) {
+	if len(value) > 0 && value[0] == '"' {
+		var s string
+		err := json.Unmarshal(value, &s)
+		return s, err
+	}
+	if string(value) == "null" {
+		return "", nil
+	}
+	return string(value), nil
+}
+
+// resizeSequence sets the slice fieldValue to a new slice of n elements, or returns an error
+// if the array fieldValue doesn't have n elements.
+func resizeSequence(fieldValue reflect.Value, n int) error {
+	if fieldValue.Kind() == reflect.Array {
+		if fieldValue.Len() != n {
+			return fmt.Errorf("expected %d elements for %s, got %d", fieldValue.Len(), fieldValue.Type(), n)
+		}
+		fieldValue.SetZero()
+		return nil
+	}
+	fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), n, n))
+	return nil
+}
+
+// decodeBytes decodes the bytes rendered by formatBytes with the given flags.
+func decodeBytes(s string, flags EncodingFlag) ([]byte, error) {
+	switch {
+	case flags.has(EncodingFlagBytesRaw):
+		return []byte(s), nil
+	case flags.has(EncodingFlagBytesHex):
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex value %q: %w", s, err)
+		}
+		return b, nil
+	default:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 value %q: %w", s, err)
+		}
+		return b, nil
+	}
+}
+
+// parseBool parses "1"/"0" as written by the encoder, as well as "true"/"false" and "t"/"f"
+// ignoring case, and "yes"/"no" ignoring case with EncodingFlagLenientBools.
+func parseBool(s string, flags EncodingFlag) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "1", "t", "true":
+		return true, true
+	case "0", "f", "false":
+		return false, true
+	case "yes":
+		return true, flags.has(EncodingFlagLenientBools)
+	case "no":
+		return false, flags.has(EncodingFlagLenientBools)
+	default:
+		return false, false
+	}
+}
+
+// trimBasePrefix removes the base prefix added by the "prefix" option from s, after the sign
+// if any. The prefix is optional and matched case-insensitively, so "0X1A4" is accepted.
+func trimBasePrefix(s, prefix string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		s = s[len(prefix):]
+	}
+	return sign + s
+}
+
+// parseTimeWithTagFormat parses a time.Time value with the format and timezone specified
+// in the tag, as formatted by formatTimeWithTagFormat.
+func parseTimeWithTagFormat(s string, flags EncodingFlag, tag *reflect.StructTag) (time.Time, error) {
+	loc, err := timeLocation(tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	format, err := resolveTimeFormat(flags, tag, DefaultTimeFormat)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, err := format.parse(s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time value %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// addrAsInterface returns a pointer to the addressable value v as an implementation of the
+// interface T, so that decoding methods with pointer receivers can modify v.
+func addrAsInterface[T any](v reflect.Value) (T, bool) {
+	var zero T
+	if !v.CanAddr() || !v.Addr().CanInterface() {
+		return zero, false
+	}
+	i, ok := v.Addr().Interface().(T)
+	return i, ok
+}
+
+// isNumberKind reports whether kind is one of the integer or float kinds.
+func isNumberKind(kind reflect.Kind) bool {
+	return isIntegerKind(kind) || kind == reflect.Float32 || kind == reflect.Float64
+}
+
+// emptyStringExpected reports whether the encoder may render a non-nil value of the given kind
+// as an empty string when using the given flags.
+func emptyStringExpected(kind reflect.Kind, flags EncodingFlag) bool {
+	switch kind {
+	case reflect.Bool:
+		return false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return !flags.has(EncodingFlagUseNumbersZeroValues)
+	case reflect.Struct:
+		// Zero time.Time values follow the same rule as numbers
+		return !flags.has(EncodingFlagUseNumbersZeroValues)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,1556 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+type decodeTestStruct struct {
+	Name    string  `osquery:"name"`
+	Count   int     `osquery:"count"`
+	Small   int8    `osquery:"small"`
+	Size    uint64  `osquery:"size"`
+	Score   float64 `osquery:"score"`
+	Active  bool    `osquery:"active"`
+	Hidden  string  `osquery:"-"`
+	NoTag   string
+	private string //nolint:unused -- meaningful for test coverage
+}
+
+type decodePointerStruct struct {
+	StrPtr *string `osquery:"str_ptr"`
+	IntPtr *int    `osquery:"int_ptr"`
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    map[string]string
+		out      any
+		expected any
+		err      bool
+	}{
+		{
+			name: "all supported kinds",
+			input: map[string]string{
+				"name":   "test",
+				"count":  "-42",
+				"small":  "7",
+				"size":   "18446744073709551615",
+				"score":  "99.5",
+				"active": "1",
+				"NoTag":  "untagged",
+			},
+			out: &decodeTestStruct{},
+			expected: &decodeTestStruct{
+				Name:   "test",
+				Count:  -42,
+				Small:  7,
+				Size:   18446744073709551615,
+				Score:  99.5,
+				Active: true,
+				NoTag:  "untagged",
+			},
+		},
+		{
+			name:     "bool from true/false",
+			input:    map[string]string{"active": "true"},
+			out:      &decodeTestStruct{},
+			expected: &decodeTestStruct{Active: true},
+		},
+		{
+			name:     "bool from 0",
+			input:    map[string]string{"active": "0"},
+			out:      &decodeTestStruct{Active: true},
+			expected: &decodeTestStruct{Active: false},
+		},
+		{
+			name:     "empty strings decode to zero values",
+			input:    map[string]string{"name": "", "count": "", "size": "", "score": "", "active": ""},
+			out:      &decodeTestStruct{Name: "x", Count: 1, Size: 2, Score: 3, Active: true},
+			expected: &decodeTestStruct{},
+		},
+		{
+			name:     "unknown and skipped keys are ignored",
+			input:    map[string]string{"unknown": "value", "Hidden": "value", "-": "value", "private": "value"},
+			out:      &decodeTestStruct{},
+			expected: &decodeTestStruct{},
+		},
+		{
+			name:     "pointer fields",
+			input:    map[string]string{"str_ptr": "hello", "int_ptr": "123"},
+			out:      &decodePointerStruct{},
+			expected: &decodePointerStruct{StrPtr: stringPtr("hello"), IntPtr: intPtr(123)},
+		},
+		{
+			name:     "empty string resets pointer fields",
+			input:    map[string]string{"str_ptr": "", "int_ptr": ""},
+			out:      &decodePointerStruct{StrPtr: stringPtr("hello"), IntPtr: intPtr(123)},
+			expected: &decodePointerStruct{},
+		},
+		{
+			name:  "invalid integer",
+			input: map[string]string{"count": "abc"},
+			out:   &decodeTestStruct{},
+			err:   true,
+		},
+		{
+			name:  "integer overflow",
+			input: map[string]string{"small": "1000"},
+			out:   &decodeTestStruct{},
+			err:   true,
+		},
+		{
+			name:  "negative unsigned integer",
+			input: map[string]string{"size": "-1"},
+			out:   &decodeTestStruct{},
+			err:   true,
+		},
+		{
+			name:  "invalid bool",
+			input: map[string]string{"active": "maybe"},
+			out:   &decodeTestStruct{},
+			err:   true,
+		},
+		{
+			name:  "nil output",
+			input: map[string]string{},
+			out:   nil,
+			err:   true,
+		},
+		{
+			name:  "non-pointer output",
+			input: map[string]string{},
+			out:   decodeTestStruct{},
+			err:   true,
+		},
+		{
+			name:  "nil pointer output",
+			input: map[string]string{},
+			out:   (*decodeTestStruct)(nil),
+			err:   true,
+		},
+		{
+			name:  "pointer to non-struct output",
+			input: map[string]string{},
+			out:   stringPtr(""),
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		err := UnmarshalMap(test.input, test.out)
+		if (err != nil) != test.err {
+			t.Errorf("%s: UnmarshalMap(%v) error = %v; expected error = %v", test.name, test.input, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if !reflect.DeepEqual(test.out, test.expected) {
+			t.Errorf("%s: UnmarshalMap(%v) = %+v; expected %+v", test.name, test.input, test.out, test.expected)
+		}
+	}
+}
+
+func TestUnmarshalMapWithFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    map[string]string
+		flags    EncodingFlag
+		expected decodeTestStruct
+		err      bool
+	}{
+		{
+			name:     "empty number is zero by default",
+			input:    map[string]string{"count": ""},
+			flags:    0,
+			expected: decodeTestStruct{},
+		},
+		{
+			name:     "empty number is expected without zero values flag",
+			input:    map[string]string{"count": "", "score": ""},
+			flags:    EncodingFlagEmptyStringAsError,
+			expected: decodeTestStruct{},
+		},
+		{
+			name:  "empty number is unexpected with zero values flag",
+			input: map[string]string{"count": ""},
+			flags: EncodingFlagEmptyStringAsError | EncodingFlagUseNumbersZeroValues,
+			err:   true,
+		},
+		{
+			name:  "empty bool is always unexpected",
+			input: map[string]string{"active": ""},
+			flags: EncodingFlagEmptyStringAsError,
+			err:   true,
+		},
+		{
+			name:     "empty string field is always expected",
+			input:    map[string]string{"name": ""},
+			flags:    EncodingFlagEmptyStringAsError | EncodingFlagUseNumbersZeroValues,
+			expected: decodeTestStruct{},
+		},
+		{
+			name:     "zero values with zero values flag",
+			input:    map[string]string{"count": "0", "size": "0", "score": "0"},
+			flags:    EncodingFlagEmptyStringAsError | EncodingFlagUseNumbersZeroValues,
+			expected: decodeTestStruct{},
+		},
+	}
+
+	for _, test := range tests {
+		var out decodeTestStruct
+		err := UnmarshalMapWithFlags(test.input, &out, test.flags)
+		if (err != nil) != test.err {
+			t.Errorf("%s: UnmarshalMapWithFlags(%v, %v) error = %v; expected error = %v", test.name, test.input, test.flags, err, test.err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(out, test.expected) {
+			t.Errorf("%s: UnmarshalMapWithFlags(%v, %v) = %+v; expected %+v", test.name, test.input, test.flags, out, test.expected)
+		}
+	}
+}
+
+func TestUnmarshalMapWithFlags_stringOption(t *testing.T) {
+	// The string option makes an empty value unexpected for its field only
+	type decodeStringOption struct {
+		Count int `osquery:"count,string"`
+		Other int `osquery:"other"`
+	}
+
+	var out decodeStringOption
+	if err := UnmarshalMapWithFlags(map[string]string{"other": ""}, &out, EncodingFlagEmptyStringAsError); err != nil {
+		t.Errorf("UnmarshalMapWithFlags() failed for field without string option: %v", err)
+	}
+	if err := UnmarshalMapWithFlags(map[string]string{"count": ""}, &out, EncodingFlagEmptyStringAsError); err == nil {
+		t.Error("expected error for empty value of field with string option, got nil")
+	}
+}
+
+func TestUnmarshalMapWithFlags_snakeCaseKeys(t *testing.T) {
+	type snakeCaseStruct struct {
+		ProcessID int
+		UserName  string `osquery:"UserName"`
+	}
+
+	var out snakeCaseStruct
+	in := map[string]string{"process_id": "1", "ProcessID": "2", "UserName": "root"}
+	if err := UnmarshalMapWithFlags(in, &out, EncodingFlagSnakeCaseKeys); err != nil {
+		t.Fatalf("UnmarshalMapWithFlags() failed: %v", err)
+	}
+	expected := snakeCaseStruct{ProcessID: 1, UserName: "root"}
+	if out != expected {
+		t.Errorf("UnmarshalMapWithFlags(%v) = %+v; expected %+v", in, out, expected)
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_lowercaseKeys(t *testing.T) {
+	type mount struct {
+		Path string
+	}
+	type row struct {
+		ProcessID int
+		UserName  string `osquery:"UserName"`
+		Parent    *testProcess
+		Mounts    []mount
+		Labels    map[string]string
+	}
+
+	in := row{
+		ProcessID: 1,
+		UserName:  "root",
+		Parent:    &testProcess{PID: 2, Name: "init"},
+		Mounts:    []mount{{Path: "/"}},
+		Labels:    map[string]string{"env": "prod"},
+	}
+	m, err := MarshalToMapWithFlags(in, EncodingFlagLowercaseKeys)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+
+	var out row
+	if err := UnmarshalMapWithFlags(m, &out, EncodingFlagLowercaseKeys|EncodingFlagDisallowUnknownKeys); err != nil {
+		t.Fatalf("UnmarshalMapWithFlags(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip of %v = %+v; expected %+v", m, out, in)
+	}
+}
+
+func TestUnmarshalMapWithFlags_keyFunc(t *testing.T) {
+	type row struct {
+		PID  int    `osquery:"pid"`
+		Name string `osquery:"name"`
+	}
+
+	// The keys transformed by KeyFunc cannot be matched back to the fields
+	m, err := MarshalToMapWithKeyFunc(row{PID: 1, Name: "osqueryd"}, 0, func(key string) string {
+		if key == "pid" {
+			return "process_id"
+		}
+		return key
+	})
+	if err != nil {
+		t.Fatalf("MarshalToMapWithKeyFunc() failed: %v", err)
+	}
+
+	var out row
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if expected := (row{Name: "osqueryd"}); out != expected {
+		t.Errorf("UnmarshalMap(%v) = %+v; expected %+v", m, out, expected)
+	}
+	if err := UnmarshalMapWithFlags(m, &out, EncodingFlagDisallowUnknownKeys); err == nil || err.Error() != "unknown keys: process_id" {
+		t.Errorf("UnmarshalMapWithFlags(%v) error = %v; expected the transformed key to be unknown", m, err)
+	}
+}
+
+func TestUnmarshalMapWithFlags_fallbackJSONTag(t *testing.T) {
+	type jsonTagged struct {
+		PID    int    `json:"pid"`
+		Name   string `osquery:"process_name" json:"name"`
+		Secret string `json:"-"`
+	}
+
+	var out jsonTagged
+	in := map[string]string{"pid": "42", "process_name": "osqueryd", "name": "ignored", "Secret": "s"}
+	if err := UnmarshalMapWithFlags(in, &out, EncodingFlagFallbackJSONTag); err != nil {
+		t.Fatalf("UnmarshalMapWithFlags() failed: %v", err)
+	}
+	expected := jsonTagged{PID: 42, Name: "osqueryd"}
+	if out != expected {
+		t.Errorf("UnmarshalMapWithFlags(%v) = %+v; expected %+v", in, out, expected)
+	}
+}
+
+func TestUnmarshalMapWithFlags_caseInsensitiveKeys(t *testing.T) {
+	type process struct {
+		PID     int         `osquery:"pid"`
+		Name    string      `osquery:"name"`
+		Upper   string      `osquery:"Name"`
+		Process testProcess `osquery:"parent"`
+	}
+
+	tests := []struct {
+		name     string
+		in       map[string]string
+		flags    EncodingFlag
+		expected process
+		err      string
+	}{
+		{
+			name:     "case is significant by default",
+			in:       map[string]string{"PID": "1", "NAME": "osqueryd", "Parent.PID": "2"},
+			expected: process{},
+		},
+		{
+			name:     "mixed-case keys",
+			in:       map[string]string{"PID": "1", "Parent.Pid": "2", "PARENT.name": "launchd"},
+			flags:    EncodingFlagCaseInsensitiveKeys,
+			expected: process{PID: 1, Process: testProcess{PID: 2, Name: "launchd"}},
+		},
+		{
+			name:     "exact matches win",
+			in:       map[string]string{"name": "lower", "Name": "upper", "pid": "1", "Pid": "2"},
+			flags:    EncodingFlagCaseInsensitiveKeys,
+			expected: process{PID: 1, Name: "lower", Upper: "upper"},
+		},
+		{
+			name:     "fields differing only by case share a key matching both",
+			in:       map[string]string{"NAME": "osqueryd"},
+			flags:    EncodingFlagCaseInsensitiveKeys,
+			expected: process{Name: "osqueryd", Upper: "osqueryd"},
+		},
+		{
+			name:  "ambiguous keys",
+			in:    map[string]string{"Pid": "1", "PID": "2"},
+			flags: EncodingFlagCaseInsensitiveKeys,
+			err:   "failed to decode field pid: keys PID, Pid all match column pid ignoring case",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out process
+			err := UnmarshalMapWithFlags(tt.in, &out, tt.flags)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("UnmarshalMapWithFlags() error = %v; expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalMapWithFlags() failed: %v", err)
+			}
+			if !reflect.DeepEqual(out, tt.expected) {
+				t.Errorf("UnmarshalMapWithFlags(%v) = %+v; expected %+v", tt.in, out, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMap_remaining(t *testing.T) {
+	type row struct {
+		PID     int               `osquery:"pid"`
+		Process testProcess       `osquery:"process"`
+		Extra   map[string]string `osquery:",remaining"`
+	}
+
+	in := map[string]string{"pid": "1", "process.pid": "2", "process.cwd": "/", "uid": "0"}
+	var out row
+	if err := UnmarshalMap(in, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	expected := row{PID: 1, Process: testProcess{PID: 2}, Extra: map[string]string{"process.cwd": "/", "uid": "0"}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("UnmarshalMap(%v) = %+v; expected %+v", in, out, expected)
+	}
+
+	// The remaining keys are rendered back as columns
+	got, err := MarshalToMap(out)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	if expected := map[string]string{"pid": "1", "process.pid": "2", "process.name": "", "process.started": "", "process.cwd": "/", "uid": "0"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMap() = %v; expected %v", got, expected)
+	}
+
+	// Keys matched ignoring case are not remaining
+	out = row{}
+	if err := UnmarshalMapWithFlags(map[string]string{"PID": "1"}, &out, EncodingFlagCaseInsensitiveKeys); err != nil {
+		t.Fatalf("UnmarshalMapWithFlags() failed: %v", err)
+	}
+	if expected := (row{PID: 1}); !reflect.DeepEqual(out, expected) {
+		t.Errorf("UnmarshalMapWithFlags() = %+v; expected %+v", out, expected)
+	}
+}
+
+func TestUnmarshalMap_remainingErrors(t *testing.T) {
+	var twice struct {
+		Extra map[string]string `osquery:",remaining"`
+		Other map[string]string `osquery:",remaining"`
+	}
+	if err := UnmarshalMap(map[string]string{}, &twice); err == nil || err.Error() != "fields Extra and Other both have the remaining option" {
+		t.Errorf("UnmarshalMap() error = %v; expected an error for the second remaining field", err)
+	}
+
+	var wrongType struct {
+		Extra map[string]any `osquery:",remaining"`
+	}
+	if err := UnmarshalMap(map[string]string{}, &wrongType); err == nil {
+		t.Errorf("UnmarshalMap() succeeded; expected an error for a remaining field of type map[string]any")
+	}
+}
+
+func TestUnmarshalMapWithFlags_disallowUnknownKeys(t *testing.T) {
+	type row struct {
+		testHost
+		PID     int          `osquery:"pid"`
+		Process *testProcess `osquery:"process"`
+	}
+
+	tests := []struct {
+		name  string
+		in    map[string]string
+		flags EncodingFlag
+		err   string
+	}{
+		{
+			name:  "all keys known",
+			in:    map[string]string{"pid": "1", "hostname": "h", "process.pid": "2"},
+			flags: EncodingFlagDisallowUnknownKeys,
+		},
+		{
+			name: "unknown keys are ignored by default",
+			in:   map[string]string{"pid": "1", "uid": "0"},
+		},
+		{
+			name:  "unknown keys are listed",
+			in:    map[string]string{"pid": "1", "uid": "0", "process.cwd": "/", "gid": "0"},
+			flags: EncodingFlagDisallowUnknownKeys,
+			err:   "unknown keys: gid, process.cwd, uid",
+		},
+		{
+			name:  "keys matched ignoring case are known",
+			in:    map[string]string{"PID": "1", "Process.Name": "osqueryd", "OS": "linux"},
+			flags: EncodingFlagDisallowUnknownKeys | EncodingFlagCaseInsensitiveKeys,
+		},
+		{
+			name:  "case-only mismatches are unknown without case-insensitive matching",
+			in:    map[string]string{"PID": "1"},
+			flags: EncodingFlagDisallowUnknownKeys,
+			err:   "unknown keys: PID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out row
+			err := UnmarshalMapWithFlags(tt.in, &out, tt.flags)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("UnmarshalMapWithFlags() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("UnmarshalMapWithFlags() error = %v; expected %q", err, tt.err)
+			}
+		})
+	}
+
+	// The remaining field takes the unknown keys
+	var out struct {
+		PID   int               `osquery:"pid"`
+		Extra map[string]string `osquery:",remaining"`
+	}
+	if err := UnmarshalMapWithFlags(map[string]string{"pid": "1", "uid": "0"}, &out, EncodingFlagDisallowUnknownKeys); err != nil {
+		t.Errorf("UnmarshalMapWithFlags() with a remaining field failed: %v", err)
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_mapFields(t *testing.T) {
+	type row struct {
+		PID    int               `osquery:"pid"`
+		Labels map[string]string `osquery:"labels"`
+		Ports  *map[int]uint16   `osquery:"ports"`
+	}
+
+	ports := map[int]uint16{0: 22, 1: 443}
+	in := row{PID: 1, Labels: map[string]string{"env": "prod", "team": "infra"}, Ports: &ports}
+	m, err := MarshalToMapWithFlags(in, EncodingFlagUseNumbersZeroValues)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+
+	// The flattened entries are known keys
+	var out row
+	if err := UnmarshalMapWithFlags(m, &out, EncodingFlagUseNumbersZeroValues|EncodingFlagDisallowUnknownKeys); err != nil {
+		t.Fatalf("UnmarshalMapWithFlags(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+
+	// Maps are only allocated for the keys under their prefix
+	out = row{}
+	if err := UnmarshalMapWithFlags(map[string]string{"pid": "1", "labels": "x"}, &out, 0); err != nil {
+		t.Fatalf("UnmarshalMapWithFlags() failed: %v", err)
+	}
+	if out.Labels != nil || out.Ports != nil {
+		t.Errorf("UnmarshalMapWithFlags() = %+v; expected nil maps", out)
+	}
+
+	if err := UnmarshalMap(map[string]string{"ports.http": "80"}, &out); err == nil {
+		t.Errorf("UnmarshalMap() succeeded; expected an error for an invalid map key")
+	}
+}
+
+func TestUnmarshalMapWithFlags_strictNumericParse(t *testing.T) {
+	type numbers struct {
+		Int     int           `osquery:"int"`
+		Uint    uint16        `osquery:"uint"`
+		Float   float64       `osquery:"float"`
+		Elapsed time.Duration `osquery:"elapsed"`
+		Name    string        `osquery:"name"`
+		Ptr     *int          `osquery:"ptr"`
+	}
+
+	// Zero numbers are rendered as empty strings, and decoded back to zero by default
+	in := numbers{Name: "zeros"}
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	for _, key := range []string{"int", "uint", "float", "elapsed", "ptr"} {
+		if m[key] != "" {
+			t.Fatalf("MarshalToMap() rendered %s as %q; expected an empty string", key, m[key])
+		}
+	}
+	out := numbers{Int: 1, Uint: 2, Float: 3, Elapsed: 4, Ptr: intPtr(5)}
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+
+	for _, key := range []string{"int", "uint", "float", "elapsed"} {
+		err := UnmarshalMapWithFlags(map[string]string{key: ""}, &out, EncodingFlagStrictNumericParse)
+		if err == nil || !strings.Contains(err.Error(), "empty value") {
+			t.Errorf("UnmarshalMapWithFlags() error for empty %s = %v; expected an empty value error", key, err)
+		}
+	}
+	if err := UnmarshalMapWithFlags(map[string]string{"name": "", "ptr": ""}, &out, EncodingFlagStrictNumericParse); err != nil {
+		t.Errorf("UnmarshalMapWithFlags() failed for empty string and pointer fields: %v", err)
+	}
+}
+
+func TestUnmarshalMapWithFlags_bools(t *testing.T) {
+	tests := []struct {
+		value    string
+		flags    EncodingFlag
+		expected bool
+		err      bool
+	}{
+		{value: "1", expected: true},
+		{value: "0", expected: false},
+		{value: "true", expected: true},
+		{value: "TRUE", expected: true},
+		{value: "True", expected: true},
+		{value: "tRuE", expected: true},
+		{value: "false", expected: false},
+		{value: "FaLsE", expected: false},
+		{value: "t", expected: true},
+		{value: "F", expected: false},
+		{value: "yes", err: true},
+		{value: "no", err: true},
+		{value: "yes", flags: EncodingFlagLenientBools, expected: true},
+		{value: "YES", flags: EncodingFlagLenientBools, expected: true},
+		{value: "No", flags: EncodingFlagLenientBools, expected: false},
+		{value: "2", err: true},
+		{value: "y", flags: EncodingFlagLenientBools, err: true},
+		{value: " true", err: true},
+	}
+
+	for _, tt := range tests {
+		var out struct {
+			Active bool `osquery:"active"`
+		}
+		err := UnmarshalMapWithFlags(map[string]string{"active": tt.value}, &out, tt.flags)
+		if tt.err {
+			expected := fmt.Sprintf("failed to decode field active: invalid bool value %q", tt.value)
+			if err == nil || err.Error() != expected {
+				t.Errorf("UnmarshalMapWithFlags(%q) error = %v; expected %q", tt.value, err, expected)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("UnmarshalMapWithFlags(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if out.Active != tt.expected {
+			t.Errorf("UnmarshalMapWithFlags(%q) = %v; expected %v", tt.value, out.Active, tt.expected)
+		}
+	}
+}
+
+func TestUnmarshalMap_boolText(t *testing.T) {
+	type settings struct {
+		Enabled bool `osquery:"enabled,booltext"`
+		Hidden  bool `osquery:"hidden,booltext"`
+		Active  bool `osquery:"active"`
+	}
+
+	// Both forms are accepted, with or without the flag and the option
+	for _, flags := range []EncodingFlag{0, EncodingFlagBoolAsTrueFalse} {
+		in := settings{Enabled: true, Active: true}
+		m, err := MarshalToMapWithFlags(in, flags)
+		if err != nil {
+			t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+		}
+		var out settings
+		if err := UnmarshalMapWithFlags(m, &out, flags); err != nil {
+			t.Fatalf("UnmarshalMapWithFlags(%v) failed: %v", m, err)
+		}
+		if out != in {
+			t.Errorf("round trip with flags %d = %+v; expected %+v", flags, out, in)
+		}
+
+		if err := UnmarshalMapWithFlags(map[string]string{"enabled": "1", "active": "true"}, &out, flags); err != nil || !out.Enabled || !out.Active {
+			t.Errorf("UnmarshalMapWithFlags() = %+v, %v; expected enabled and active", out, err)
+		}
+	}
+}
+
+func TestUnmarshalMap_boolStrings(t *testing.T) {
+	type switches struct {
+		State bool `osquery:"state,bool=on:off"`
+	}
+
+	tests := []struct {
+		value    string
+		expected bool
+		err      bool
+	}{
+		{value: "on", expected: true},
+		{value: "off", expected: false},
+		{value: "ON", expected: true},
+		{value: "1", expected: true},
+		{value: "false", expected: false},
+		{value: "enabled", err: true},
+	}
+
+	for _, tt := range tests {
+		out := switches{State: !tt.expected}
+		err := UnmarshalMap(map[string]string{"state": tt.value}, &out)
+		if tt.err {
+			if err == nil {
+				t.Errorf("UnmarshalMap(%q) succeeded; expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil || out.State != tt.expected {
+			t.Errorf("UnmarshalMap(%q) = %v, %v; expected %v", tt.value, out.State, err, tt.expected)
+		}
+	}
+
+	var out struct {
+		State bool `osquery:"state,bool=on"`
+	}
+	if err := UnmarshalMap(map[string]string{"state": "1"}, &out); err == nil {
+		t.Errorf("UnmarshalMap() with an invalid bool option succeeded; expected an error")
+	}
+}
+
+func TestUnmarshalMapWithFlags_emptyPointer(t *testing.T) {
+	// Nil pointers are always rendered as empty strings, so they never trigger EncodingFlagEmptyStringAsError
+	out := decodePointerStruct{IntPtr: intPtr(1)}
+	err := UnmarshalMapWithFlags(map[string]string{"int_ptr": ""}, &out, EncodingFlagEmptyStringAsError|EncodingFlagUseNumbersZeroValues)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.IntPtr != nil {
+		t.Errorf("expected nil pointer, got %v", *out.IntPtr)
+	}
+}
+
+type roundTripStruct struct {
+	Name    string  `osquery:"name"`
+	Int     int     `osquery:"int"`
+	Int64   int64   `osquery:"int64"`
+	Uint    uint    `osquery:"uint"`
+	Uint8   uint8   `osquery:"uint8"`
+	Float32 float32 `osquery:"float32"`
+	Float64 float64 `osquery:"float64"`
+	Bool    bool    `osquery:"bool"`
+	IntPtr  *int    `osquery:"int_ptr"`
+	Any     any     `osquery:"any"`
+}
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	values := []struct {
+		name  string
+		value roundTripStruct
+		// lossless reports whether the value survives the round trip with the given flags
+		lossless map[EncodingFlag]bool
+	}{
+		{
+			name: "non-zero values",
+			value: roundTripStruct{
+				Name: "test", Int: -1, Int64: 1 << 40, Uint: 7, Uint8: 255,
+				Float32: 1.5, Float64: -2.25, Bool: true, IntPtr: intPtr(5), Any: "text",
+			},
+			lossless: map[EncodingFlag]bool{
+				0:                                true,
+				EncodingFlagEmptyStringAsError:   true,
+				EncodingFlagUseNumbersZeroValues: true,
+				EncodingFlagUseNumbersZeroValues | EncodingFlagEmptyStringAsError: true,
+			},
+		},
+		{
+			name:  "zero values",
+			value: roundTripStruct{},
+			lossless: map[EncodingFlag]bool{
+				0:                                true,
+				EncodingFlagEmptyStringAsError:   true,
+				EncodingFlagUseNumbersZeroValues: true,
+				EncodingFlagUseNumbersZeroValues | EncodingFlagEmptyStringAsError: true,
+			},
+		},
+		{
+			// A pointer to zero is rendered as "" unless zero values are preserved
+			name:  "pointer to zero",
+			value: roundTripStruct{IntPtr: intPtr(0)},
+			lossless: map[EncodingFlag]bool{
+				0:                                false,
+				EncodingFlagEmptyStringAsError:   false,
+				EncodingFlagUseNumbersZeroValues: true,
+				EncodingFlagUseNumbersZeroValues | EncodingFlagEmptyStringAsError: true,
+			},
+		},
+	}
+
+	for _, test := range values {
+		for flags, lossless := range test.lossless {
+			m, err := MarshalToMapWithFlags(test.value, flags)
+			if err != nil {
+				t.Fatalf("%s: MarshalToMapWithFlags(%v) failed: %v", test.name, flags, err)
+			}
+			var out roundTripStruct
+			if err := UnmarshalMapWithFlags(m, &out, flags); err != nil {
+				t.Fatalf("%s: UnmarshalMapWithFlags(%v, %v) failed: %v", test.name, m, flags, err)
+			}
+			if reflect.DeepEqual(out, test.value) != lossless {
+				t.Errorf("%s: round trip with flags %v: got %+v, original %+v, expected lossless = %v", test.name, flags, out, test.value, lossless)
+			}
+		}
+	}
+}
+
+type decodeProcess struct {
+	PID  int    `osquery:"pid"`
+	Name string `osquery:"name"`
+}
+
+type decodeNestedStruct struct {
+	Event   string         `osquery:"event"`
+	Process decodeProcess  `osquery:"process"`
+	Parent  *decodeProcess `osquery:"parent"`
+	Nested  struct {
+		Inner **decodeProcess `osquery:"inner"`
+	} `osquery:"nested"`
+}
+
+func TestUnmarshalMap_nested(t *testing.T) {
+	inner := &decodeProcess{PID: 3}
+	tests := []struct {
+		name     string
+		input    map[string]string
+		expected decodeNestedStruct
+	}{
+		{
+			name:  "nested struct and pointer",
+			input: map[string]string{"event": "exec", "process.pid": "1", "process.name": "bash", "parent.pid": "2", "parent.name": "sshd"},
+			expected: decodeNestedStruct{
+				Event:   "exec",
+				Process: decodeProcess{PID: 1, Name: "bash"},
+				Parent:  &decodeProcess{PID: 2, Name: "sshd"},
+			},
+		},
+		{
+			name:  "partial nested data",
+			input: map[string]string{"parent.name": "sshd"},
+			expected: decodeNestedStruct{
+				Parent: &decodeProcess{Name: "sshd"},
+			},
+		},
+		{
+			name:     "nested pointer is not allocated without keys",
+			input:    map[string]string{"event": "exec", "parentpid": "2"},
+			expected: decodeNestedStruct{Event: "exec"},
+		},
+		{
+			name:     "unknown nested keys are ignored",
+			input:    map[string]string{"parent.unknown": "value", "process.pid.extra": "1"},
+			expected: decodeNestedStruct{Parent: &decodeProcess{}},
+		},
+		{
+			name:  "multi-level nesting",
+			input: map[string]string{"nested.inner.pid": "3"},
+			expected: func() decodeNestedStruct {
+				var s decodeNestedStruct
+				s.Nested.Inner = &inner
+				return s
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		var out decodeNestedStruct
+		if err := UnmarshalMap(test.input, &out); err != nil {
+			t.Errorf("%s: UnmarshalMap(%v) failed: %v", test.name, test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, test.expected) {
+			t.Errorf("%s: UnmarshalMap(%v) = %+v; expected %+v", test.name, test.input, out, test.expected)
+		}
+	}
+}
+
+func TestUnmarshalMap_embedded(t *testing.T) {
+	type embeddedStruct struct {
+		testHost
+		*decodeProcess
+		testAgent `osquery:"agent"`
+		Name      string `osquery:"name"`
+	}
+
+	tests := []struct {
+		name     string
+		input    map[string]string
+		alloc    bool
+		expected embeddedStruct
+	}{
+		{
+			name:  "promoted and prefixed fields",
+			input: map[string]string{"hostname": "localhost", "pid": "1", "agent.agent_id": "abc", "name": "outer"},
+			alloc: true,
+			expected: embeddedStruct{
+				testHost:      testHost{Hostname: "localhost"},
+				decodeProcess: &decodeProcess{PID: 1},
+				testAgent:     testAgent{AgentID: "abc"},
+				Name:          "outer",
+			},
+		},
+		{
+			name:     "embedded pointer is not allocated without keys",
+			input:    map[string]string{"os": "linux", "name": "outer"},
+			expected: embeddedStruct{testHost: testHost{OS: "linux"}, Name: "outer"},
+		},
+	}
+
+	for _, test := range tests {
+		var out embeddedStruct
+		if test.alloc {
+			// Embedded pointers to unexported types cannot be allocated by the decoder
+			out.decodeProcess = &decodeProcess{}
+		}
+		if err := UnmarshalMap(test.input, &out); err != nil {
+			t.Errorf("%s: UnmarshalMap(%v) failed: %v", test.name, test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, test.expected) {
+			t.Errorf("%s: UnmarshalMap(%v) = %+v; expected %+v", test.name, test.input, out, test.expected)
+		}
+	}
+
+	var out embeddedStruct
+	if err := UnmarshalMap(map[string]string{"pid": "1"}, &out); err == nil {
+		t.Error("expected error when allocating an embedded pointer to an unexported type, got nil")
+	}
+}
+
+func TestUnmarshalMap_nestedError(t *testing.T) {
+	var out decodeNestedStruct
+	err := UnmarshalMap(map[string]string{"parent.pid": "abc"}, &out)
+	if err == nil {
+		t.Fatal("expected error for invalid nested value, got nil")
+	}
+	if !strings.Contains(err.Error(), "parent.pid") {
+		t.Errorf("expected error to reference the nested key, got %v", err)
+	}
+}
+
+func TestUnmarshalMap_duration(t *testing.T) {
+	type durationStruct struct {
+		Uptime  time.Duration  `osquery:"uptime"`
+		Millis  time.Duration  `osquery:"millis,duration=ms"`
+		Nanos   *time.Duration `osquery:"nanos,duration=ns"`
+		Invalid time.Duration  `osquery:"invalid,duration=weeks"`
+	}
+
+	var out durationStruct
+	err := UnmarshalMap(map[string]string{"uptime": "5400", "millis": "1500", "nanos": "42"}, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := durationStruct{Uptime: 90 * time.Minute, Millis: 1500 * time.Millisecond, Nanos: durationPtr(42)}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("UnmarshalMap() = %+v; expected %+v", out, expected)
+	}
+
+	for _, input := range []map[string]string{{"uptime": "1.5"}, {"invalid": "1"}} {
+		if err := UnmarshalMap(input, &out); err == nil {
+			t.Errorf("UnmarshalMap(%v): expected error, got nil", input)
+		}
+	}
+
+}
+
+func TestMarshalUnmarshalRoundTrip_duration(t *testing.T) {
+	type durationStruct struct {
+		Uptime time.Duration  `osquery:"uptime"`
+		Millis time.Duration  `osquery:"millis,duration=ms"`
+		Nanos  *time.Duration `osquery:"nanos,duration=ns"`
+	}
+
+	in := durationStruct{Uptime: time.Hour, Millis: 2500 * time.Millisecond, Nanos: durationPtr(42)}
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	var out durationStruct
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+}
+
+func (r *testIPRange) UnmarshalOsquery(s string) error {
+	if s == "" {
+		*r = testIPRange{}
+		return nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return fmt.Errorf("invalid range %q", s)
+	}
+	r.From, r.To = from, to
+	return nil
+}
+
+func TestUnmarshalMap_unmarshaler(t *testing.T) {
+	type rangeStruct struct {
+		Range    testIPRange  `osquery:"range"`
+		RangePtr *testIPRange `osquery:"range_ptr"`
+		Network  struct {
+			Range *testIPRange `osquery:"range"`
+		} `osquery:"network"`
+	}
+
+	var out rangeStruct
+	err := UnmarshalMap(map[string]string{
+		"range":         "10.0.0.1-10.0.0.9",
+		"range_ptr":     "10.0.1.1-10.0.1.9",
+		"network.range": "10.0.2.1-10.0.2.9",
+	}, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var expected rangeStruct
+	expected.Range = testIPRange{From: "10.0.0.1", To: "10.0.0.9"}
+	expected.RangePtr = &testIPRange{From: "10.0.1.1", To: "10.0.1.9"}
+	expected.Network.Range = &testIPRange{From: "10.0.2.1", To: "10.0.2.9"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("UnmarshalMap() = %+v; expected %+v", out, expected)
+	}
+
+	// Round trip through MarshalOsquery
+	m, err := MarshalToMap(out)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	var roundTrip rangeStruct
+	if err := UnmarshalMap(m, &roundTrip); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(roundTrip, expected) {
+		t.Errorf("round trip = %+v; expected %+v", roundTrip, expected)
+	}
+}
+
+func TestUnmarshalMap_unmarshalerError(t *testing.T) {
+	var out struct {
+		Network struct {
+			Range *testIPRange `osquery:"range"`
+		} `osquery:"network"`
+	}
+	err := UnmarshalMap(map[string]string{"network.range": "invalid"}, &out)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "network.range") || !strings.Contains(err.Error(), `invalid range "invalid"`) {
+		t.Errorf("expected error to reference the key and the unmarshaler error, got %v", err)
+	}
+}
+
+// testMode implements encoding.TextUnmarshaler with a pointer receiver.
+type testMode struct {
+	Value string
+}
+
+func (m testMode) MarshalText() ([]byte, error) {
+	return []byte("mode:" + m.Value), nil
+}
+
+func (m *testMode) UnmarshalText(text []byte) error {
+	value, ok := strings.CutPrefix(string(text), "mode:")
+	if !ok {
+		return fmt.Errorf("invalid mode %q", text)
+	}
+	m.Value = value
+	return nil
+}
+
+// testBothDecoder implements both OsqueryUnmarshaler and encoding.TextUnmarshaler.
+type testBothDecoder struct {
+	Source string
+}
+
+func (d *testBothDecoder) UnmarshalOsquery(string) error { d.Source = "osquery"; return nil }
+func (d *testBothDecoder) UnmarshalText([]byte) error    { d.Source = "text"; return nil }
+
+func TestUnmarshalMap_textUnmarshaler(t *testing.T) {
+	type textStruct struct {
+		IP      net.IP          `osquery:"ip"`
+		IPPtr   *net.IP         `osquery:"ip_ptr"`
+		Mode    testMode        `osquery:"mode"`
+		ModePtr *testMode       `osquery:"mode_ptr"`
+		Both    testBothDecoder `osquery:"both"`
+	}
+
+	var out textStruct
+	err := UnmarshalMap(map[string]string{
+		"ip":       "10.0.0.1",
+		"ip_ptr":   "2001:db8::1",
+		"mode":     "mode:strict",
+		"mode_ptr": "mode:lenient",
+		"both":     "value",
+	}, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ipPtr := net.ParseIP("2001:db8::1")
+	expected := textStruct{
+		IP:      net.ParseIP("10.0.0.1"),
+		IPPtr:   &ipPtr,
+		Mode:    testMode{Value: "strict"},
+		ModePtr: &testMode{Value: "lenient"},
+		Both:    testBothDecoder{Source: "osquery"},
+	}
+	if !out.IP.Equal(expected.IP) || !out.IPPtr.Equal(*expected.IPPtr) ||
+		out.Mode != expected.Mode || *out.ModePtr != *expected.ModePtr || out.Both != expected.Both {
+		t.Errorf("UnmarshalMap() = %+v; expected %+v", out, expected)
+	}
+
+	if err := UnmarshalMap(map[string]string{"mode": "invalid"}, &out); err == nil {
+		t.Error("expected error for invalid text value, got nil")
+	}
+	if err := UnmarshalMap(map[string]string{"ip": "not-an-ip"}, &out); err == nil {
+		t.Error("expected error for invalid IP, got nil")
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_textMarshaler(t *testing.T) {
+	type ipStruct struct {
+		IP     net.IP   `osquery:"ip"`
+		IPv6   net.IP   `osquery:"ipv6"`
+		NoIP   net.IP   `osquery:"no_ip"`
+		Mode   testMode `osquery:"mode"`
+		Nested struct {
+			IP net.IP `osquery:"ip"`
+		} `osquery:"nested"`
+	}
+
+	in := ipStruct{
+		IP:   net.ParseIP("192.168.1.1").To4(),
+		IPv6: net.ParseIP("2001:db8::1"),
+		Mode: testMode{Value: "strict"},
+	}
+	in.Nested.IP = net.ParseIP("10.0.0.1").To4()
+
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	expectedMap := map[string]string{"ip": "192.168.1.1", "ipv6": "2001:db8::1", "no_ip": "", "mode": "mode:strict", "nested.ip": "10.0.0.1"}
+	if !reflect.DeepEqual(m, expectedMap) {
+		t.Fatalf("MarshalToMap() = %v; expected %v", m, expectedMap)
+	}
+
+	var out ipStruct
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !out.IP.Equal(in.IP) || !out.IPv6.Equal(in.IPv6) || out.NoIP != nil || out.Mode != in.Mode || !out.Nested.IP.Equal(in.Nested.IP) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_hardwareAddr(t *testing.T) {
+	type macStruct struct {
+		MAC    net.HardwareAddr  `osquery:"mac"`
+		MACPtr *net.HardwareAddr `osquery:"mac_ptr"`
+		NoMAC  net.HardwareAddr  `osquery:"no_mac"`
+	}
+
+	in := macStruct{
+		MAC:    net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		MACPtr: &net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02},
+	}
+
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	expectedMap := map[string]string{"mac": "00:00:5e:00:53:01", "mac_ptr": "02:42:ac:11:00:02", "no_mac": ""}
+	if !reflect.DeepEqual(m, expectedMap) {
+		t.Fatalf("MarshalToMap() = %v; expected %v", m, expectedMap)
+	}
+
+	var out macStruct
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+
+	// Other notations accepted by net.ParseMAC are decoded too
+	if err := UnmarshalMap(map[string]string{"mac": "0000.5e00.5301"}, &out); err != nil || out.MAC.String() != "00:00:5e:00:53:01" {
+		t.Errorf("UnmarshalMap() = %v, %v; expected 00:00:5e:00:53:01", out.MAC, err)
+	}
+	if err := UnmarshalMap(map[string]string{"mac": "not-a-mac"}, &out); err == nil {
+		t.Error("expected error for invalid MAC address, got nil")
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_time(t *testing.T) {
+	type timeStruct struct {
+		Default  time.Time  `osquery:"default"`
+		Unix     time.Time  `osquery:"unix" format:"unix"`
+		Milli    time.Time  `osquery:"milli" format:"unixmilli"`
+		Nano     time.Time  `osquery:"nano" format:"unixnano"`
+		RFC1123Z time.Time  `osquery:"rfc1123z" format:"rfc1123z"`
+		Layout   time.Time  `osquery:"layout,layout=2006-01-02"`
+		Tokyo    time.Time  `osquery:"tokyo" tz:"Asia/Tokyo"`
+		Ptr      *time.Time `osquery:"ptr"`
+		NilPtr   *time.Time `osquery:"nil_ptr"`
+		Zero     time.Time  `osquery:"zero"`
+	}
+
+	ts := time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+	in := timeStruct{
+		Default:  ts,
+		Unix:     ts,
+		Milli:    ts.Add(123 * time.Millisecond),
+		Nano:     ts.Add(123456789),
+		RFC1123Z: ts,
+		Layout:   time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC),
+		Tokyo:    ts.In(tokyo),
+		Ptr:      timePtr(ts),
+	}
+
+	for _, flags := range []EncodingFlag{0, EncodingFlagUseNumbersZeroValues, EncodingFlagTimeAsUnix, EncodingFlagTimeAsUnixMilli} {
+		m, err := MarshalToMapWithFlags(in, flags)
+		if err != nil {
+			t.Fatalf("MarshalToMapWithFlags(%v) failed: %v", flags, err)
+		}
+		var out timeStruct
+		if err := UnmarshalMapWithFlags(m, &out, flags); err != nil {
+			t.Fatalf("UnmarshalMapWithFlags(%v, %v) failed: %v", m, flags, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("round trip with flags %v = %+v; expected %+v", flags, out, in)
+		}
+	}
+}
+
+func TestUnmarshalMap_invalidTime(t *testing.T) {
+	var out struct {
+		Time    time.Time `osquery:"time"`
+		Unix    time.Time `osquery:"unix" format:"unix"`
+		Invalid time.Time `osquery:"invalid" format:"invalid"`
+	}
+	for _, input := range []map[string]string{{"time": "yesterday"}, {"unix": "2023-06-15"}, {"invalid": "1"}} {
+		if err := UnmarshalMap(input, &out); err == nil {
+			t.Errorf("UnmarshalMap(%v): expected error, got nil", input)
+		}
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_bigNumbers(t *testing.T) {
+	type bigStruct struct {
+		Int   *big.Int   `osquery:"int"`
+		Float *big.Float `osquery:"float"`
+		Nil   *big.Int   `osquery:"nil"`
+	}
+
+	n, _ := new(big.Int).SetString("-98765432109876543210", 10)
+	in := bigStruct{Int: n, Float: big.NewFloat(0.5)}
+
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	expectedMap := map[string]string{"int": "-98765432109876543210", "float": "0.5", "nil": ""}
+	if !reflect.DeepEqual(m, expectedMap) {
+		t.Fatalf("MarshalToMap() = %v; expected %v", m, expectedMap)
+	}
+
+	var out bigStruct
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if out.Int.Cmp(in.Int) != 0 || out.Float.Cmp(in.Float) != 0 || out.Nil != nil {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+}
+
+func TestUnmarshalMap_char(t *testing.T) {
+	type flagStruct struct {
+		Flag    rune  `osquery:"flag,char"`
+		Symbol  int32 `osquery:"symbol,char"`
+		Invalid rune  `osquery:"invalid,char"`
+		Zero    rune  `osquery:"zero,char"`
+	}
+
+	in := flagStruct{Flag: '5', Symbol: '€', Invalid: 0xd800}
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+
+	var out flagStruct
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if out != in {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+
+	// Values of several characters are parsed as numbers
+	if err := UnmarshalMap(map[string]string{"flag": "89"}, &out); err != nil || out.Flag != 'Y' {
+		t.Errorf("UnmarshalMap() = %+v, %v; expected flag 'Y'", out, err)
+	}
+	if err := UnmarshalMap(map[string]string{"flag": "yes"}, &out); err == nil {
+		t.Errorf("UnmarshalMap() with several characters succeeded; expected an error")
+	}
+}
+
+func TestUnmarshalMap_jsonNumber(t *testing.T) {
+	type measurement struct {
+		Value json.Number  `osquery:"value"`
+		Big   json.Number  `osquery:"big"`
+		Exp   json.Number  `osquery:"exp"`
+		Empty json.Number  `osquery:"empty"`
+		Ptr   *json.Number `osquery:"ptr"`
+	}
+
+	neg := json.Number("-1.25")
+	in := measurement{Value: "3.14159265358979323846", Big: "123456789012345678901234567890", Exp: "1e-7", Ptr: &neg}
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	expected := map[string]string{
+		"value": "3.14159265358979323846",
+		"big":   "123456789012345678901234567890",
+		"exp":   "1e-7",
+		"empty": "",
+		"ptr":   "-1.25",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("MarshalToMap() = %v; expected %v", m, expected)
+	}
+
+	var out measurement
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+
+	for _, value := range []string{"abc", "1.", "01", "+1", "NaN", " 1"} {
+		if err := UnmarshalMap(map[string]string{"value": value}, &out); err == nil {
+			t.Errorf("UnmarshalMap(%q) succeeded; expected an error", value)
+		}
+	}
+
+	type numbers struct {
+		Value json.Number  `osquery:"value"`
+		Float json.Number  `osquery:"float,type=DOUBLE"`
+		Count json.Number  `osquery:"count,type=BIGINT"`
+		Ptr   *json.Number `osquery:"ptr"`
+	}
+	huge := json.Number("12345678901234567890")
+	columnTests := []struct {
+		name     string
+		in       any
+		expected []table.ColumnDefinition
+	}{
+		{
+			name:     "zero value",
+			in:       numbers{},
+			expected: []table.ColumnDefinition{table.TextColumn("value"), table.DoubleColumn("float"), table.BigIntColumn("count"), table.TextColumn("ptr")},
+		},
+		{
+			name:     "nil pointer",
+			in:       (*numbers)(nil),
+			expected: []table.ColumnDefinition{table.TextColumn("value"), table.DoubleColumn("float"), table.BigIntColumn("count"), table.TextColumn("ptr")},
+		},
+		{
+			name:     "integers",
+			in:       &numbers{Value: "-42", Float: "1", Count: "1.5", Ptr: &huge},
+			expected: []table.ColumnDefinition{table.BigIntColumn("value"), table.DoubleColumn("float"), table.BigIntColumn("count"), table.TextColumn("ptr")},
+		},
+		{
+			name:     "floats",
+			in:       numbers{Value: "1.5", Ptr: &neg},
+			expected: []table.ColumnDefinition{table.DoubleColumn("value"), table.DoubleColumn("float"), table.BigIntColumn("count"), table.DoubleColumn("ptr")},
+		},
+		{
+			name:     "exponent",
+			in:       numbers{Value: "1e3"},
+			expected: []table.ColumnDefinition{table.DoubleColumn("value"), table.DoubleColumn("float"), table.BigIntColumn("count"), table.TextColumn("ptr")},
+		},
+		{
+			name:     "invalid number",
+			in:       numbers{Value: "abc"},
+			expected: []table.ColumnDefinition{table.TextColumn("value"), table.DoubleColumn("float"), table.BigIntColumn("count"), table.TextColumn("ptr")},
+		},
+	}
+	for _, tt := range columnTests {
+		columns, err := GenerateColumnDefinitions(tt.in)
+		if err != nil {
+			t.Fatalf("%s: GenerateColumnDefinitions() failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(columns, tt.expected) {
+			t.Errorf("%s: GenerateColumnDefinitions() = %v; expected %v", tt.name, columns, tt.expected)
+		}
+	}
+}
+
+func TestUnmarshalMap_interface(t *testing.T) {
+	var out struct {
+		Value any          `osquery:"value"`
+		Empty any          `osquery:"empty"`
+		Name  fmt.Stringer `osquery:"name"`
+	}
+	out.Empty = 1
+	if err := UnmarshalMap(map[string]string{"value": "5", "empty": ""}, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	if out.Value != "5" || out.Empty != nil {
+		t.Errorf("UnmarshalMap() = %#v, %#v; expected the string \"5\" and nil", out.Value, out.Empty)
+	}
+
+	err := UnmarshalMap(map[string]string{"name": "osqueryd"}, &out)
+	if expected := "failed to decode field name: cannot store a string in fmt.Stringer"; err == nil || err.Error() != expected {
+		t.Errorf("UnmarshalMap() error = %v; expected %q", err, expected)
+	}
+}
+
+func TestUnmarshalMap_integerBase(t *testing.T) {
+	type modeStruct struct {
+		Mode   uint32 `osquery:"mode,base=8"`
+		Flags  int    `osquery:"flags,base=16,prefix"`
+		Offset int64  `osquery:"offset,base=16,prefix"`
+		Bits   uint8  `osquery:"bits,base=2,prefix"`
+	}
+
+	in := modeStruct{Mode: 0o755, Flags: 0x1a4, Offset: -31, Bits: 5}
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+
+	var out modeStruct
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if out != in {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+
+	// The prefix is optional and case-insensitive when decoding
+	if err := UnmarshalMap(map[string]string{"flags": "0X1A4", "offset": "1f"}, &out); err != nil || out.Flags != 0x1a4 || out.Offset != 31 {
+		t.Errorf("UnmarshalMap() = %+v, %v; expected flags 0x1a4 and offset 31", out, err)
+	}
+
+	if err := UnmarshalMap(map[string]string{"mode": "9"}, &out); err == nil {
+		t.Errorf("UnmarshalMap() with an invalid octal value succeeded; expected an error")
+	}
+}
+
+func TestMarshalUnmarshalRoundTrip_slices(t *testing.T) {
+	type sliceStruct struct {
+		Names []string `osquery:"names"`
+		Ports []int    `osquery:"ports"`
+		Codes [2]int16 `osquery:"codes"`
+		Masks []uint   `osquery:"masks,base=16"`
+		Paths []string `osquery:"paths,sep=|"`
+		Ptrs  []*int   `osquery:"ptrs"`
+		Data  []byte   `osquery:"data"`
+		Hash  [4]byte  `osquery:"hash"`
+		None  []string `osquery:"none"`
+	}
+
+	one := 1
+	in := sliceStruct{
+		Names: []string{"init", "", "sshd"},
+		Ports: []int{0, 22, 443},
+		Codes: [2]int16{-1, 7},
+		Masks: []uint{0xff, 0},
+		Paths: []string{"/usr/bin", "C:,D:"},
+		Ptrs:  []*int{&one, nil},
+		Data:  []byte{0x01, 0x00, 0xff},
+		Hash:  [4]byte{0xde, 0xad, 0xbe, 0xef},
+	}
+
+	for _, flags := range []EncodingFlag{0, EncodingFlagJSONSlices, EncodingFlagJSONComplex, EncodingFlagBytesHex, EncodingFlagBytesRaw} {
+		m, err := MarshalToMapWithFlags(in, flags)
+		if err != nil {
+			t.Fatalf("MarshalToMapWithFlags(%d) failed: %v", flags, err)
+		}
+		var out sliceStruct
+		if err := UnmarshalMapWithFlags(m, &out, flags); err != nil {
+			t.Fatalf("UnmarshalMapWithFlags(%v, %d) failed: %v", m, flags, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("round trip with flags %d = %+v; expected %+v", flags, out, in)
+		}
+	}
+
+	// Slices of structs are decoded from their JSON rows, with or without the JSON flag
+	type processList struct {
+		Processes []*testProcess `osquery:"processes"`
+	}
+	procs := processList{Processes: []*testProcess{{PID: 1, Name: "init"}, nil}}
+	for _, flags := range []EncodingFlag{0, EncodingFlagJSONComplex} {
+		m, err := MarshalToMapWithFlags(procs, flags)
+		if err != nil {
+			t.Fatalf("MarshalToMapWithFlags(%d) failed: %v", flags, err)
+		}
+		var outProcs processList
+		if err := UnmarshalMapWithFlags(m, &outProcs, flags); err != nil {
+			t.Fatalf("UnmarshalMapWithFlags(%v, %d) failed: %v", m, flags, err)
+		}
+		if !reflect.DeepEqual(outProcs, procs) {
+			t.Errorf("round trip with flags %d = %+v; expected %+v", flags, outProcs, procs)
+		}
+	}
+
+	// Empty values decode to nil slices and zero arrays
+	out := sliceStruct{Names: []string{"x"}, Codes: [2]int16{1, 2}}
+	if err := UnmarshalMap(map[string]string{"names": "", "codes": ""}, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	if out.Names != nil || out.Codes != [2]int16{} {
+		t.Errorf("UnmarshalMap() = %+v; expected a nil slice and a zero array", out)
+	}
+
+	for _, test := range []struct {
+		in  map[string]string
+		err string
+	}{
+		{
+			in:  map[string]string{"ports": "1,x"},
+			err: `failed to decode field ports: failed to decode element 1: invalid integer value "x": strconv.ParseInt: parsing "x": invalid syntax`,
+		},
+		{
+			in:  map[string]string{"codes": "1,2,3"},
+			err: "failed to decode field codes: expected 2 elements for [2]int16, got 3",
+		},
+		{
+			in:  map[string]string{"hash": "AQI="},
+			err: "failed to decode field hash: invalid length 2 for [4]uint8",
+		},
+		{
+			in:  map[string]string{"data": "not base64"},
+			err: `failed to decode field data: invalid base64 value "not base64": illegal base64 data at input byte 3`,
+		},
+	} {
+		err := UnmarshalMap(test.in, &out)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("UnmarshalMap(%v) error = %v; expected %s", test.in, err, test.err)
+		}
+	}
+
+	if err := UnmarshalMapWithFlags(map[string]string{"names": "a,b"}, &out, EncodingFlagJSONSlices); err == nil {
+		t.Errorf("UnmarshalMapWithFlags() with a joined value and EncodingFlagJSONSlices succeeded; expected an error")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package encoding converts structs and maps into the map[string]string rows of osquery
+// tables with MarshalToMap or an Encoder, decodes the rows back with UnmarshalMap, and
+// generates the columns of the rows with GenerateColumnDefinitions.
+//
+// # Values
+//
+// Values implementing OsqueryMarshaler are rendered with their MarshalOsquery method,
+// and values implementing encoding.TextMarshaler with their MarshalText method, except
+// for time.Time values, which are formatted according to the tag and flags. Values that are
+// not strings, bools or numbers (e.g. structs and slices) are rendered with their String
+// method when implementing fmt.Stringer, so the precedence is: OsqueryMarshaler, the types
+// with dedicated handling (time.Time, time.Duration, json.RawMessage, json.Number, net.IP,
+// net.IPNet, net.HardwareAddr, big.Int and big.Float), encoding.TextMarshaler, driver.Valuer,
+// string, bool and number kinds, fmt.Stringer, and finally the other kinds, rendered with
+// fmt.Sprintf unless EncodingFlagErrorOnUnsupported is set. IP addresses,
+// networks and MAC addresses are rendered in their usual notation, e.g. "10.0.0.0/8" or
+// "00:00:5e:00:53:01". big.Int and big.Float values are rendered in full decimal notation,
+// and json.Number values as their text, without losing precision.
+// The nullable types of database/sql, like sql.NullString or sql.NullInt64, are rendered as
+// their value when valid, following the rules of its type, and as "" otherwise. Other
+// driver.Valuer values are rendered as the value returned by their Value method, or as "" when
+// it is nil. Integer types registered with RegisterEnum are rendered as the names of
+// their values, and the types registered with RegisterConverter by their function.
+//
+// # Slices, arrays and maps
+//
+// Slices and arrays are rendered by joining their converted elements with commas, or with the
+// separator set by the "sep" option. The separator is not escaped when found in an element,
+// use EncodingFlagJSONSlices when elements may contain it. Slices and arrays of structs are
+// always rendered as a JSON array of the rows of their elements instead, e.g.
+// [{"name":"init","pid":"1"}], as joining them would lose their fields. With
+// EncodingFlagJSONComplex, the other slices, arrays and maps are rendered with encoding/json,
+// except that maps of structs are rendered as a JSON object of the rows of their values,
+// e.g. {"eth0":{"mac":"00:00:5e:00:53:01"}}. The keys and values of the rows follow the tags
+// of the struct, and the KeyFunc, IncludeKeys, ExcludeKeys, FieldFilter, OnField and
+// OnOverflow options, and EncodingFlagLowercaseKeys, don't apply to them. Nil slices and
+// zero-length arrays are rendered as "" in all cases.
+// Byte slices and arrays are rendered as base64, or with the encoding selected by
+// EncodingFlagBytesHex or EncodingFlagBytesRaw.
+//
+// # Nested structs and maps
+//
+// Struct fields, or pointers to them, are flattened using dotted keys like "process.pid",
+// unless their type implements one of the interfaces above. The fields of embedded structs,
+// or of non-nil embedded pointers to them, are promoted to the parent keys, unless the
+// embedded field has a name in its tag, which is then used as the prefix. Embedded types
+// implementing one of the interfaces above are converted as a single column, named after the
+// type unless tagged. Fields of the outer struct shadow the promoted fields. Map fields are
+// flattened the same way, e.g. "labels.env", unless EncodingFlagJSONComplex is set, and so
+// are their struct values, combining the map key with the names of the fields, e.g.
+// "interfaces.eth0.mac" for a map[string]Interface field. The struct and map values of
+// top-level maps are flattened too, e.g. "eth0.mac" for a map[string]Interface. Keys
+// from flattened maps that collide with other keys are reported as errors, as well as values
+// referencing themselves through pointers or maps, which would be flattened forever, and
+// values nested deeper than DefaultMaxDepth structs and maps.
+//
+// # Map keys
+//
+// Map keys that are not strings are formatted with their MarshalOsquery, MarshalText or
+// String method, or like values otherwise, e.g. "404" for map[int]string or the
+// registered name of an enum, except that zero numbers are rendered as "0". Maps whose keys
+// are none of these, like structs or arrays, are rejected, or rendered as values for fields.
+// Keys formatting to the same string are reported as errors.
+//
+// # Other fields
+//
+// Interface fields, like any, are marshaled as their dynamic value, as if declared with its
+// type, so that structs and maps they hold are flattened too. Nil interfaces are rendered as
+// "".
+//
+// Channel and function fields are skipped, as they have no meaningful representation.
+// Complex numbers are reported as errors, unless EncodingFlagSkipComplex is set to skip them.
+// Panics raised while converting the values, by reflection on malformed values or by their
+// methods, are recovered and returned as a MarshalError for the field being converted.
+//
+// # Tags
+//
+// The "osquery" tag holds the column name optionally followed by comma-separated
+// options, e.g. `osquery:"created,layout=2006-01-02"`. A "-" name skips the field, even with
+// options, and the exact tag `osquery:"-,"` names the column "-". Supported options are:
+//   - layout: the time.Format layout used for time.Time fields. It takes precedence
+//     over the "format" tag, and cannot contain commas.
+//   - duration: the unit used for time.Duration fields, one of "s" (default), "ms",
+//     "us" or "ns". Durations are rendered as integers, truncated to the unit.
+//   - prec: the number of decimals of float and big.Float fields, e.g. "prec=2" renders 1.5
+//     as "1.50". By default, the smallest number of digits needed to represent the value
+//     is used.
+//   - base: the base of integer fields, one of 2, 8, 10 (default) or 16, e.g. "base=16"
+//     renders 420 as "1a4". With the "prefix" option, the base prefix is added, e.g. "0x1a4".
+//   - char: renders int32 fields, like runes, as the character of their code point, e.g.
+//     'Y' as "Y" instead of "89". Zero and invalid code points are rendered as numbers.
+//   - sep: the separator used to join slice and array elements, a comma by default.
+//     It cannot contain commas.
+//   - bytesasnums: joins the elements of byte slices and arrays as numbers, like other
+//     slices, instead of encoding them as base64, hex or raw bytes, e.g. "1,2,3" for
+//     []uint8{1, 2, 3}. Byte slices are still joined with EncodingFlagJSONComplex.
+//   - inline: flattens the entries of a map field without prefixing them with the
+//     column name, e.g. `osquery:",inline"`, to add dynamic columns to the fixed ones.
+//     Entries colliding with the other columns are reported as errors, and Validate
+//     reports structs with several inline maps.
+//   - remaining: marks the map[string]string field collecting the keys without a field in
+//     UnmarshalMap, which is rendered as an inline map.
+//   - booltext: renders a bool field as "true" or "false", as with
+//     EncodingFlagBoolAsTrueFalse but for this field only. Its column is TEXT.
+//   - bool: the strings rendering the true and false values of a bool field, separated by a
+//     colon, e.g. "bool=on:off". It takes precedence over the "booltext" option and
+//     EncodingFlagBoolAsTrueFalse, and its column is TEXT. UnmarshalMap accepts these strings,
+//     ignoring case, as well as the usual forms.
+//   - omitempty: leaves the column out of the row when the value is the zero value or is
+//     rendered as an empty string, instead of setting it to "". For map fields, it applies
+//     to each entry.
+//   - string: renders the zero value of a number or duration field as "0", as with
+//     EncodingFlagUseNumbersZeroValues but for this field only. Options are applied after
+//     the conversion, so a field with both string and omitempty is still omitted when zero.
+//   - default: the value used instead of an empty string, e.g. for nil pointers or zero
+//     numbers. It cannot contain commas, and is ignored when the field is omitted by the
+//     omitempty option.
+//   - redact: replaces the value with DefaultRedactMask, or Options.RedactMask, when it is
+//     not rendered as an empty string, so that secrets never reach the rows. For map
+//     fields, it applies to each entry. Redacted columns cannot be decoded back.
+//   - max: the maximum length in bytes of the value, e.g. "max=4096". Longer text values are
+//     truncated without splitting UTF-8 characters, and Options.DefaultMaxLen sets the
+//     maximum length of the fields without this option. Numbers, bools and times are never
+//     truncated. Invalid values are ignored, and reported by Validate.
+//   - ellipsis: ends the truncated values with "…", which counts in the maximum length.
+//
+// The options are applied in this order: max, redact, then omitempty, then default. A
+// redacted field is still omitted when zero, and still gets its default when empty.
+package encoding
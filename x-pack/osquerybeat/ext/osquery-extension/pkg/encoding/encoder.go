@@ -0,0 +1,342 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// Options configures an Encoder. The zero value is equivalent to calling MarshalToMap. The
+// functions set in the options, like KeyFunc or OnField, are called by every conversion of
+// the Encoder, and must be safe for concurrent use if it is shared across goroutines.
+type Options struct {
+	// Flags are the encoding flags, as passed to MarshalToMapWithFlags.
+	Flags EncodingFlag
+
+	// TimeLayout is the time.Format layout used for time.Time fields when neither their
+	// tag nor the flags set a format. It defaults to DefaultTimeFormat.
+	TimeLayout string
+
+	// SliceSep is the separator used to join slice and array elements when their tag has
+	// no "sep" option. It defaults to DefaultSliceSeparator.
+	SliceSep string
+
+	// KeyFunc, when set, transforms every key of the result, as with MarshalToMapWithKeyFunc.
+	KeyFunc func(string) string
+
+	// UseECSKeys takes the names of the columns from the "ecs" tag of the fields instead of
+	// the "osquery" tag, so that one struct can produce ECS-shaped documents too. Fields
+	// without an "ecs" tag use their field name, even when tagged `osquery:"-"`, and fields
+	// tagged `ecs:"-"` are skipped. The options of the fields are still read from the
+	// "osquery" tag.
+	UseECSKeys bool
+
+	// TagPriority lists the tags holding the names of the columns, e.g. ["osquery", "json"].
+	// Each tag is tried in order, and the first one setting a name is used, so that structs
+	// tagged for other packages can be reused. A "-" name in a tag tried first skips the
+	// field. It defaults to ["osquery"], or ["ecs"] with UseECSKeys, and the options of the
+	// fields are always read from the "osquery" tag.
+	TagPriority []string
+
+	// MaxDepth is the maximum number of nested structs and maps that are descended into,
+	// including embedded structs. Going deeper returns an error, which protects against
+	// producing a huge number of columns. It defaults to DefaultMaxDepth, and a negative
+	// value disables the limit.
+	MaxDepth int
+
+	// RedactMask replaces the values of the fields with the "redact" option. It defaults to
+	// DefaultRedactMask.
+	RedactMask string
+
+	// DefaultMaxLen is the maximum length in bytes of the values of the fields without a
+	// "max" tag option, and of the entries of top-level maps. Longer text values are
+	// truncated as documented for the "max" tag option in the package documentation. It
+	// defaults to 0, which disables truncation.
+	DefaultMaxLen int
+
+	// NilString is the value of the nil pointers, including the pointers to nil pointers and
+	// the nil pointer elements of slices and maps, so that they can be told apart from empty
+	// values. It defaults to "", and isn't used for nil pointers to nested structs and maps,
+	// which produce no columns. The "default" tag option takes precedence over it, and it is
+	// never redacted. UnmarshalMap doesn't decode it back into nil pointers.
+	NilString string
+
+	// IncludeKeys, when not empty, lists the only keys kept in the rows, and ExcludeKeys the
+	// keys left out of them, so that a struct can be reused for tables with fewer columns.
+	// They are matched against the final keys, e.g. "process.pid", after the KeyFunc option.
+	// A key listed in both is left out, as the exclusion is applied after the inclusion.
+	// The filtered out fields are still converted, and their errors are still reported.
+	IncludeKeys []string
+	ExcludeKeys []string
+
+	// FieldFilter, when set, is called with the final key and value of every column kept by
+	// IncludeKeys and ExcludeKeys, after the tag options like "redact" or "max" are applied,
+	// and the column is left out of the row when it returns false.
+	FieldFilter func(key, value string) bool
+
+	// OnField, when set, is called with the final key of every column of the row, and whether
+	// it was stored in the row. Columns left out by the omitempty option, by IncludeKeys,
+	// ExcludeKeys or FieldFilter, or by a conversion error collected by MarshalAll, are
+	// reported as not emitted. It is meant for instrumentation and doesn't change the rows.
+	OnField func(key string, emitted bool)
+
+	// OnOverflow, when set with EncodingFlagWarnIntOverflow, is called with the final key and
+	// the value of every integer column out of range of its column type, as documented in
+	// EncodingFlagWarnIntOverflow.
+	OnOverflow func(key, value string)
+}
+
+// Clone returns a copy of the options that shares no slices with o, so that it can be
+// modified without affecting o or the encoders created from it. Functions like KeyFunc are
+// shared.
+func (o Options) Clone() Options {
+	o.TagPriority = slices.Clone(o.TagPriority)
+	o.IncludeKeys = slices.Clone(o.IncludeKeys)
+	o.ExcludeKeys = slices.Clone(o.ExcludeKeys)
+	return o
+}
+
+// Option sets a field of Options, for the calls to MarshalToMap that only tweak a few of them.
+type Option func(*Options)
+
+// WithFlags adds flags to Options.Flags.
+func WithFlags(flags EncodingFlag) Option {
+	return func(o *Options) {
+		o.Flags |= flags
+	}
+}
+
+// WithTimeLayout sets Options.TimeLayout.
+func WithTimeLayout(layout string) Option {
+	return func(o *Options) {
+		o.TimeLayout = layout
+	}
+}
+
+// WithKeyFunc sets Options.KeyFunc.
+func WithKeyFunc(keyFunc func(string) string) Option {
+	return func(o *Options) {
+		o.KeyFunc = keyFunc
+	}
+}
+
+// timeLayout returns the layout used for time.Time fields without a format.
+func (o *Options) timeLayout() string {
+	if o.TimeLayout != "" {
+		return o.TimeLayout
+	}
+	return DefaultTimeFormat
+}
+
+// redactMask returns the mask replacing the values of redacted fields.
+func (o *Options) redactMask() string {
+	if o.RedactMask != "" {
+		return o.RedactMask
+	}
+	return DefaultRedactMask
+}
+
+// defaultKeyTags is the tag holding the names of the columns by default.
+const defaultKeyTags = "osquery"
+
+// keyTags returns the tags holding the names of the columns, in priority order and joined
+// with commas, so that they can be part of cache keys.
+func (o *Options) keyTags() string {
+	var tags []string
+	for _, tag := range o.TagPriority {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	switch {
+	case len(tags) > 0:
+		return strings.Join(tags, ",")
+	case o.UseECSKeys:
+		return "ecs"
+	default:
+		return defaultKeyTags
+	}
+}
+
+// maxDepth returns the maximum nesting of structs and maps, or 0 when unlimited.
+func (o *Options) maxDepth() int {
+	switch {
+	case o.MaxDepth == 0:
+		return DefaultMaxDepth
+	case o.MaxDepth < 0:
+		return 0
+	default:
+		return o.MaxDepth
+	}
+}
+
+// keyFilter holds the keys of the IncludeKeys and ExcludeKeys options.
+type keyFilter struct {
+	include map[string]struct{}
+	exclude map[string]struct{}
+}
+
+// newKeyFilter returns the filter of the IncludeKeys and ExcludeKeys options, or nil when
+// both are empty.
+func newKeyFilter(include, exclude []string) *keyFilter {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	f := &keyFilter{exclude: keySet(exclude)}
+	if len(include) > 0 {
+		f.include = keySet(include)
+	}
+	return f
+}
+
+// keySet returns the set of keys.
+func keySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+// keep reports whether key is kept in the rows. A nil filter keeps all the keys.
+func (f *keyFilter) keep(key string) bool {
+	if f == nil {
+		return true
+	}
+	if _, ok := f.include[key]; f.include != nil && !ok {
+		return false
+	}
+	_, excluded := f.exclude[key]
+	return !excluded
+}
+
+// Encoder converts structs and maps into map[string]string rows, like MarshalToMap, using
+// the options it was created with. An Encoder is safe for concurrent use when the functions
+// set in its options are.
+type Encoder struct {
+	opts    Options
+	keyTags string
+	filter  *keyFilter
+}
+
+// NewEncoder returns an Encoder using a copy of opts, as returned by Options.Clone.
+func NewEncoder(opts Options) *Encoder {
+	opts = opts.Clone()
+	return &Encoder{
+		opts:    opts,
+		keyTags: opts.keyTags(),
+		filter:  newKeyFilter(opts.IncludeKeys, opts.ExcludeKeys),
+	}
+}
+
+// Options returns a copy of the options of the encoder, as returned by Options.Clone.
+func (e *Encoder) Options() Options {
+	return e.opts.Clone()
+}
+
+// WithOptions returns a new Encoder using a copy of the options of e modified by update,
+// e.g. to derive the encoder of a table from a shared one. e is not modified, and can still
+// be used concurrently.
+func (e *Encoder) WithOptions(update func(*Options)) *Encoder {
+	opts := e.Options()
+	update(&opts)
+	return NewEncoder(opts)
+}
+
+// defaultEncoder is the Encoder of the package functions taking only flags, which are
+// added to its options by each call rather than creating a new Encoder.
+var defaultEncoder = NewEncoder(Options{})
+
+// newState returns the state of a single conversion with the options of the encoder, and
+// flags in addition to Options.Flags.
+func (e *Encoder) newState(flags EncodingFlag) *encodeState {
+	return &encodeState{opts: &e.opts, flags: e.opts.Flags | flags, keyTags: e.keyTags, filter: e.filter}
+}
+
+// Marshal converts in into a map[string]string, as documented in MarshalToMap.
+func (e *Encoder) Marshal(in any) (map[string]string, error) {
+	return e.marshal(in, 0)
+}
+
+func (e *Encoder) marshal(in any, flags EncodingFlag) (map[string]string, error) {
+	return marshalToMap(in, e.newState(flags))
+}
+
+// MarshalAll is like Marshal, but continues past the fields that cannot be converted, as
+// documented in MarshalToMapAll.
+func (e *Encoder) MarshalAll(in any) (map[string]string, error) {
+	return e.marshalAll(in, 0)
+}
+
+func (e *Encoder) marshalAll(in any, flags EncodingFlag) (map[string]string, error) {
+	state := e.newState(flags)
+	state.collectErrors = true
+	return marshalToMap(in, state)
+}
+
+// MarshalInto is like Marshal, but writes into dst after clearing it, as documented in
+// MarshalToMapInto.
+func (e *Encoder) MarshalInto(in any, dst map[string]string) error {
+	return e.marshalInto(in, dst, 0)
+}
+
+func (e *Encoder) marshalInto(in any, dst map[string]string, flags EncodingFlag) error {
+	if dst == nil {
+		return fmt.Errorf("destination map cannot be nil")
+	}
+	clear(dst)
+	state := e.newState(flags)
+	state.result = dst
+	_, err := marshalToMap(in, state)
+	return err
+}
+
+// AppendToMap is like Marshal, but adds the keys of the result to dst, as documented in the
+// AppendToMap function.
+func (e *Encoder) AppendToMap(in any, dst map[string]string, prefix string) error {
+	return e.appendToMap(in, dst, prefix, 0)
+}
+
+func (e *Encoder) appendToMap(in any, dst map[string]string, prefix string, flags EncodingFlag) error {
+	if dst == nil {
+		return fmt.Errorf("destination map cannot be nil")
+	}
+	row, err := e.marshal(in, flags)
+	if err != nil {
+		return err
+	}
+
+	// Check every key first, so that dst is left untouched on error
+	var collisions []string
+	for key := range row {
+		if _, ok := dst[prefix+key]; ok {
+			collisions = append(collisions, prefix+key)
+		}
+	}
+	if len(collisions) > 0 {
+		slices.Sort(collisions)
+		return fmt.Errorf("duplicate keys in destination map: %s", strings.Join(collisions, ", "))
+	}
+
+	for key, value := range row {
+		dst[prefix+key] = value
+	}
+	return nil
+}
+
+// GenerateColumnDefinitions returns the osquery columns of the rows produced by Marshal, as
+// documented in the GenerateColumnDefinitions function, with the options changing the keys
+// of the rows: the flags selecting and naming the fields, like EncodingFlagSnakeCaseKeys,
+// EncodingFlagFallbackJSONTag and EncodingFlagSkipComplex, TagPriority and UseECSKeys,
+// KeyFunc and EncodingFlagLowercaseKeys, and IncludeKeys and ExcludeKeys. With
+// EncodingFlagJSONComplex, map fields are a single TEXT column. FieldFilter is not applied,
+// as it depends on the values.
+func (e *Encoder) GenerateColumnDefinitions(in any) ([]table.ColumnDefinition, error) {
+	return generateColumns(in, e.newState(0))
+}
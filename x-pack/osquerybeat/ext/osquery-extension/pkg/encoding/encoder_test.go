@@ -0,0 +1,1025 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type encoderTestStruct struct {
+	Name     string    `osquery:"name"`
+	Count    int       `osquery:"count"`
+	Tags     []string  `osquery:"tags"`
+	Ports    []int     `osquery:"ports,sep=;"`
+	Created  time.Time `osquery:"created"`
+	Modified time.Time `osquery:"modified" format:"unix"`
+}
+
+func TestEncoder_Marshal(t *testing.T) {
+	input := &encoderTestStruct{
+		Name:     "test",
+		Tags:     []string{"a", "b"},
+		Ports:    []int{80, 443},
+		Created:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Modified: time.Unix(1700000000, 0),
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected map[string]string
+	}{
+		{
+			name: "zero options",
+			opts: Options{},
+			expected: map[string]string{
+				"name":     "test",
+				"count":    "",
+				"tags":     "a,b",
+				"ports":    "80;443",
+				"created":  "2024-01-02T03:04:05Z",
+				"modified": "1700000000",
+			},
+		},
+		{
+			name: "all options",
+			opts: Options{
+				Flags:      EncodingFlagUseNumbersZeroValues,
+				TimeLayout: time.DateOnly,
+				SliceSep:   "|",
+				KeyFunc:    strings.ToUpper,
+			},
+			expected: map[string]string{
+				"NAME":     "test",
+				"COUNT":    "0",
+				"TAGS":     "a|b",
+				"PORTS":    "80;443",
+				"CREATED":  "2024-01-02",
+				"MODIFIED": "1700000000",
+			},
+		},
+		{
+			name: "time flags take precedence over the time layout",
+			opts: Options{Flags: EncodingFlagTimeAsUnix, TimeLayout: time.DateOnly},
+			expected: map[string]string{
+				"name":     "test",
+				"count":    "",
+				"tags":     "a,b",
+				"ports":    "80;443",
+				"created":  "1704164645",
+				"modified": "1700000000",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := NewEncoder(test.opts).Marshal(input)
+		if err != nil {
+			t.Errorf("%s: Marshal() failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: Marshal() = %v; expected %v", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestEncoder_UseECSKeys(t *testing.T) {
+	type host struct {
+		Name string `osquery:"hostname" ecs:"name"`
+	}
+	type event struct {
+		PID     int       `osquery:"pid" ecs:"process.pid"`
+		Started time.Time `osquery:"started,layout=2006-01-02" ecs:"process.start"`
+		Host    host      `osquery:"host" ecs:"host"`
+		Secret  string    `osquery:"secret" ecs:"-"`
+		Hidden  string    `osquery:"-"`
+		Comment string
+	}
+
+	in := event{
+		PID:     42,
+		Started: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Host:    host{Name: "box"},
+		Secret:  "s",
+		Hidden:  "h",
+		Comment: "c",
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected map[string]string
+	}{
+		{
+			name: "osquery keys by default",
+			expected: map[string]string{
+				"pid":           "42",
+				"started":       "2024-01-02",
+				"host.hostname": "box",
+				"secret":        "s",
+				"Comment":       "c",
+			},
+		},
+		{
+			name: "ecs keys",
+			opts: Options{UseECSKeys: true},
+			expected: map[string]string{
+				"process.pid":   "42",
+				"process.start": "2024-01-02",
+				"host.name":     "box",
+				"Hidden":        "h",
+				"Comment":       "c",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncoder(tt.opts).Marshal(in)
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Marshal() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncoder_TagPriority(t *testing.T) {
+	type row struct {
+		PID      int    `osquery:"pid" json:"process_id" db:"proc_pid"`
+		Name     string `json:"name,omitempty" db:"proc_name"`
+		Path     string `db:"path"`
+		Password string `json:"-" db:"password"`
+		Token    string `osquery:"token" json:"-"`
+		Comment  string
+	}
+	in := row{PID: 1, Name: "init", Path: "/sbin/init", Password: "p", Token: "t", Comment: "c"}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected map[string]string
+	}{
+		{
+			name: "default",
+			expected: map[string]string{
+				"pid": "1", "Name": "init", "Path": "/sbin/init", "Password": "p", "token": "t", "Comment": "c",
+			},
+		},
+		{
+			name: "osquery then json",
+			opts: Options{TagPriority: []string{"osquery", "json"}},
+			expected: map[string]string{
+				"pid": "1", "name": "init", "Path": "/sbin/init", "token": "t", "Comment": "c",
+			},
+		},
+		{
+			name: "json then db",
+			opts: Options{TagPriority: []string{"json", "db"}},
+			expected: map[string]string{
+				"process_id": "1", "name": "init", "path": "/sbin/init", "Comment": "c",
+			},
+		},
+		{
+			name: "db only, taking precedence over UseECSKeys",
+			opts: Options{TagPriority: []string{"db"}, UseECSKeys: true},
+			expected: map[string]string{
+				"proc_pid": "1", "proc_name": "init", "path": "/sbin/init", "password": "p", "Token": "t", "Comment": "c",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncoder(tt.opts).Marshal(in)
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Marshal() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	// The encoder keeps its own copy of the priority list
+	tags := []string{"json"}
+	enc := NewEncoder(Options{TagPriority: tags})
+	tags[0] = "db"
+	if got, err := enc.Marshal(in); err != nil || got["process_id"] != "1" {
+		t.Errorf("Marshal() = %v, %v; expected the json names", got, err)
+	}
+}
+
+// deepNode returns a chain of depth nodes, so depth-1 levels of nesting.
+func deepNode(depth int) *testNode {
+	var node *testNode
+	for i := depth; i > 0; i-- {
+		node = &testNode{Value: i, Next: node}
+	}
+	return node
+}
+
+func TestEncoder_MaxDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		input    any
+		message  string
+	}{
+		{
+			name:     "within the limit",
+			maxDepth: 2,
+			input:    deepNode(3),
+		},
+		{
+			name:     "custom limit exceeded",
+			maxDepth: 2,
+			input:    deepNode(4),
+			message:  "maximum depth of 2 exceeded at next.next.next",
+		},
+		{
+			name:    "default limit",
+			input:   deepNode(DefaultMaxDepth + 2),
+			message: "maximum depth of 32 exceeded at next" + strings.Repeat(".next", DefaultMaxDepth),
+		},
+		{
+			name:     "negative limit disables it",
+			maxDepth: -1,
+			input:    deepNode(DefaultMaxDepth + 10),
+		},
+		{
+			name:     "maps count as a level",
+			maxDepth: 1,
+			input: &struct {
+				Labels map[string]any `osquery:"labels"`
+			}{Labels: map[string]any{"owner": map[string]string{"team": "a"}}},
+			message: "maximum depth of 1 exceeded at labels.owner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEncoder(Options{MaxDepth: tt.maxDepth}).Marshal(tt.input)
+			if tt.message == "" {
+				if err != nil {
+					t.Errorf("Marshal() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.message {
+				t.Errorf("Marshal() error = %v; expected %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestEncoder_redact(t *testing.T) {
+	type account struct {
+		User     string            `osquery:"user"`
+		Token    string            `osquery:"token,redact"`
+		Hash     *string           `osquery:"hash,redact"`
+		Secrets  map[string]string `osquery:"secrets,redact"`
+		Optional string            `osquery:"optional,redact,omitempty"`
+		Fallback string            `osquery:"fallback,redact,default=none"`
+		PIN      int               `osquery:"pin,redact,string,omitempty"`
+	}
+	hash := "$6$salt$hash"
+
+	tests := []struct {
+		name     string
+		mask     string
+		input    account
+		expected map[string]string
+	}{
+		{
+			name: "values are masked",
+			input: account{
+				User: "root", Token: "t0k3n", Hash: &hash, Secrets: map[string]string{"api": "key", "empty": ""},
+				Optional: "o", Fallback: "f", PIN: 1234,
+			},
+			expected: map[string]string{
+				"user": "root", "token": "***", "hash": "***", "secrets.api": "***", "secrets.empty": "",
+				"optional": "***", "fallback": "***", "pin": "***",
+			},
+		},
+		{
+			name:  "empty values stay empty before omitempty and default",
+			input: account{User: "root"},
+			expected: map[string]string{
+				"user": "root", "token": "", "hash": "", "fallback": "none",
+			},
+		},
+		{
+			name:  "custom mask",
+			mask:  "[redacted]",
+			input: account{Token: "t0k3n"},
+			expected: map[string]string{
+				"user": "", "token": "[redacted]", "hash": "", "fallback": "none",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncoder(Options{RedactMask: tt.mask}).Marshal(tt.input)
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Marshal() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncoder_DefaultMaxLen(t *testing.T) {
+	type process struct {
+		Name    string            `osquery:"name"`
+		Cmdline string            `osquery:"cmdline,max=10,ellipsis"`
+		Path    string            `osquery:"path,max=4"`
+		Env     map[string]string `osquery:"env,max=3"`
+		Token   string            `osquery:"token,max=2,redact"`
+	}
+	input := process{
+		Name:    "osqueryd",
+		Cmdline: "osqueryd --flagfile=/etc/osquery/osquery.flags",
+		Path:    "/usr/bin/osqueryd",
+		Env:     map[string]string{"LANG": "en_US.UTF-8"},
+		Token:   "t0k3n",
+	}
+
+	tests := []struct {
+		name          string
+		defaultMaxLen int
+		input         any
+		expected      map[string]string
+	}{
+		{
+			name:  "tag options",
+			input: input,
+			expected: map[string]string{
+				"name": "osqueryd", "cmdline": "osquery…", "path": "/usr", "env.LANG": "en_", "token": "***",
+			},
+		},
+		{
+			name:          "default for the fields without a max option",
+			defaultMaxLen: 5,
+			input:         input,
+			expected: map[string]string{
+				"name": "osque", "cmdline": "osquery…", "path": "/usr", "env.LANG": "en_", "token": "***",
+			},
+		},
+		{
+			name:          "top-level map entries",
+			defaultMaxLen: 4,
+			input:         map[string]any{"user": "Ünïcödé", "pid": 123456},
+			expected:      map[string]string{"user": "Ün", "pid": "123456"},
+		},
+		{
+			name:          "numbers, bools and times are not truncated",
+			defaultMaxLen: 3,
+			input: &struct {
+				Name    string    `osquery:"name"`
+				PID     int       `osquery:"pid"`
+				Mode    uint32    `osquery:"mode,base=8,prefix"`
+				CPU     float64   `osquery:"cpu,prec=2"`
+				Active  bool      `osquery:"active,bool=yes:no"`
+				Started time.Time `osquery:"started" format:"unix"`
+				Args    []string  `osquery:"args"`
+				Count   any       `osquery:"count"`
+				Label   any       `osquery:"label"`
+			}{
+				Name:    "osqueryd",
+				PID:     123456,
+				Mode:    0o755,
+				CPU:     12.345,
+				Active:  true,
+				Started: time.Unix(1700000000, 0),
+				Args:    []string{"--verbose"},
+				Count:   123456,
+				Label:   "primary",
+			},
+			expected: map[string]string{
+				"name": "osq", "pid": "123456", "mode": "0o755", "cpu": "12.35", "active": "yes",
+				"started": "1700000000", "args": "--v", "count": "123456", "label": "pri",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncoder(Options{DefaultMaxLen: tt.defaultMaxLen}).Marshal(tt.input)
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Marshal() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncoder_NilString(t *testing.T) {
+	empty := ""
+	emptyPtr := &empty
+	var nilPtr *string
+	type input struct {
+		Nil      *string            `osquery:"nil"`
+		Empty    *string            `osquery:"empty"`
+		Value    string             `osquery:"value"`
+		Nested   **string           `osquery:"nested"`
+		NestedOK **string           `osquery:"nested_ok"`
+		Default  *int               `osquery:"default,default=-1"`
+		Secret   *string            `osquery:"secret,redact"`
+		Omitted  *string            `osquery:"omitted,omitempty"`
+		Ports    []*int             `osquery:"ports"`
+		Labels   map[string]*string `osquery:"labels"`
+		Host     *testHost          `osquery:"host"`
+	}
+	in := input{
+		Empty:    &empty,
+		Nested:   &nilPtr,
+		NestedOK: &emptyPtr,
+		Ports:    []*int{intPtr(80), nil},
+		Labels:   map[string]*string{"env": nil, "team": &empty},
+	}
+
+	got, err := NewEncoder(Options{NilString: "null"}).Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	expected := map[string]string{
+		"nil":         "null",
+		"empty":       "",
+		"value":       "",
+		"nested":      "null",
+		"nested_ok":   "",
+		"default":     "-1",
+		"secret":      "null",
+		"ports":       "80,null",
+		"labels.env":  "null",
+		"labels.team": "",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Marshal() = %v; expected %v", got, expected)
+	}
+
+	// Nil and empty values are both rendered as "" by default
+	got, err = NewEncoder(Options{}).Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if got["nil"] != "" || got["empty"] != "" || got["ports"] != "80," {
+		t.Errorf("Marshal() = %v; expected empty nil values", got)
+	}
+}
+
+func TestEncoder_IncludeKeys(t *testing.T) {
+	input := &struct {
+		Name    string      `osquery:"name"`
+		Path    string      `osquery:"path"`
+		Process testProcess `osquery:"process"`
+	}{Name: "osqueryd", Path: "/usr/bin/osqueryd", Process: testProcess{PID: 42, Name: "osqueryd"}}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected map[string]string
+	}{
+		{
+			name:     "include",
+			opts:     Options{IncludeKeys: []string{"name", "process.pid", "missing"}},
+			expected: map[string]string{"name": "osqueryd", "process.pid": "42"},
+		},
+		{
+			name:     "exclude",
+			opts:     Options{ExcludeKeys: []string{"path", "process.name", "process.started"}},
+			expected: map[string]string{"name": "osqueryd", "process.pid": "42"},
+		},
+		{
+			name: "exclude is applied after include",
+			opts: Options{
+				IncludeKeys: []string{"name", "path", "process.pid"},
+				ExcludeKeys: []string{"path", "process.pid"},
+			},
+			expected: map[string]string{"name": "osqueryd"},
+		},
+		{
+			name: "keys are matched after the key function",
+			opts: Options{
+				KeyFunc:     strings.ToUpper,
+				IncludeKeys: []string{"NAME", "path"},
+			},
+			expected: map[string]string{"NAME": "osqueryd"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncoder(tt.opts).Marshal(input)
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Marshal() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncoder_FieldFilter(t *testing.T) {
+	input := &struct {
+		Name    string `osquery:"name"`
+		Cmdline string `osquery:"cmdline"`
+		Token   string `osquery:"token,redact"`
+		Path    string `osquery:"path,max=8"`
+	}{Name: "osqueryd", Cmdline: "osqueryd --flagfile=/etc/osquery/osquery.flags", Token: "a-long-token", Path: "/usr/bin/osqueryd"}
+
+	var seen []string
+	opts := Options{
+		ExcludeKeys: []string{"name"},
+		FieldFilter: func(key, value string) bool {
+			seen = append(seen, key)
+			return len(value) <= 10
+		},
+	}
+	got, err := NewEncoder(opts).Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	expected := map[string]string{"token": "***", "path": "/usr/bin"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Marshal() = %v; expected %v", got, expected)
+	}
+	if expectedSeen := []string{"cmdline", "token", "path"}; !reflect.DeepEqual(seen, expectedSeen) {
+		t.Errorf("FieldFilter called with %v; expected %v", seen, expectedSeen)
+	}
+}
+
+func TestEncoder_OnField(t *testing.T) {
+	input := &struct {
+		Name    string            `osquery:"name"`
+		Comment string            `osquery:"comment,omitempty"`
+		Token   string            `osquery:"token"`
+		Phase   complex128        `osquery:"phase"`
+		Events  chan int          `osquery:"events"`
+		Labels  map[string]string `osquery:"labels,omitempty"`
+	}{Name: "osqueryd", Token: "secret", Phase: complex(1, 2), Labels: map[string]string{"env": "prod", "team": ""}}
+
+	emitted := make(map[string]bool)
+	opts := Options{
+		KeyFunc:     strings.ToUpper,
+		ExcludeKeys: []string{"TOKEN"},
+		OnField: func(key string, ok bool) {
+			if _, seen := emitted[key]; seen {
+				t.Errorf("OnField called twice for %s", key)
+			}
+			emitted[key] = ok
+		},
+	}
+	got, err := NewEncoder(opts).MarshalAll(input)
+	if err == nil {
+		t.Fatalf("MarshalAll() succeeded; expected an error for the complex field")
+	}
+	expected := map[string]bool{
+		"NAME":        true,
+		"COMMENT":     false,
+		"TOKEN":       false,
+		"PHASE":       false,
+		"LABELS.ENV":  true,
+		"LABELS.TEAM": false,
+	}
+	if !reflect.DeepEqual(emitted, expected) {
+		t.Errorf("OnField called with %v; expected %v", emitted, expected)
+	}
+
+	// The callback doesn't change the rows
+	opts.OnField = nil
+	without, _ := NewEncoder(opts).MarshalAll(input)
+	if !reflect.DeepEqual(got, without) {
+		t.Errorf("MarshalAll() with OnField = %v; expected %v", got, without)
+	}
+}
+
+func TestMarshalToMap_options(t *testing.T) {
+	type event struct {
+		ProcessID int
+		Created   time.Time `osquery:"created"`
+	}
+	input := event{Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected map[string]string
+	}{
+		{
+			name:     "no options",
+			expected: map[string]string{"ProcessID": "", "created": "2024-01-02T03:04:05Z"},
+		},
+		{
+			name:     "flags are combined",
+			opts:     []Option{WithFlags(EncodingFlagSnakeCaseKeys), WithFlags(EncodingFlagUseNumbersZeroValues)},
+			expected: map[string]string{"process_id": "0", "created": "2024-01-02T03:04:05Z"},
+		},
+		{
+			name:     "time layout",
+			opts:     []Option{WithTimeLayout(time.DateOnly)},
+			expected: map[string]string{"ProcessID": "", "created": "2024-01-02"},
+		},
+		{
+			name:     "key function",
+			opts:     []Option{WithKeyFunc(strings.ToUpper)},
+			expected: map[string]string{"PROCESSID": "", "CREATED": "2024-01-02T03:04:05Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMap(input, tt.opts...)
+			if err != nil {
+				t.Fatalf("MarshalToMap() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMap() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncoder_OnOverflow(t *testing.T) {
+	big := uint64(math.MaxUint64)
+	input := &struct {
+		Size     uint64  `osquery:"size"`
+		Inode    uint64  `osquery:"inode"`
+		Count    uint32  `osquery:"count"`
+		Small    uint32  `osquery:"small"`
+		Offset   int     `osquery:"offset"`
+		Negative int     `osquery:"negative"`
+		Text     uint64  `osquery:"text,type=TEXT"`
+		Hex      uint64  `osquery:"hex,base=16"`
+		Ptr      *uint64 `osquery:"ptr"`
+		Signed   int64   `osquery:"signed"`
+	}{
+		Size:     math.MaxUint64,
+		Inode:    math.MaxInt64,
+		Count:    math.MaxInt32 + 1,
+		Small:    math.MaxInt32,
+		Offset:   math.MaxInt32 + 1,
+		Negative: math.MinInt32,
+		Text:     math.MaxUint64,
+		Hex:      math.MaxUint64,
+		Ptr:      &big,
+		Signed:   math.MinInt64,
+	}
+
+	overflows := make(map[string]string)
+	opts := Options{
+		Flags:      EncodingFlagWarnIntOverflow,
+		OnOverflow: func(key, value string) { overflows[key] = value },
+	}
+	got, err := NewEncoder(opts).Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	expected := map[string]string{
+		"size":   "18446744073709551615",
+		"count":  "2147483648",
+		"offset": "2147483648",
+		"ptr":    "18446744073709551615",
+	}
+	if !reflect.DeepEqual(overflows, expected) {
+		t.Errorf("OnOverflow called with %v; expected %v", overflows, expected)
+	}
+
+	// Large values are rendered in full, with or without the flag
+	if got["size"] != "18446744073709551615" || got["hex"] != "ffffffffffffffff" {
+		t.Errorf("Marshal() = %v; expected the full values", got)
+	}
+	opts.Flags = 0
+	clear(overflows)
+	without, err := NewEncoder(opts).Marshal(input)
+	if err != nil || !reflect.DeepEqual(got, without) || len(overflows) != 0 {
+		t.Errorf("Marshal() without the flag = %v, %v, overflows %v; expected %v and no overflow", without, err, overflows, got)
+	}
+}
+
+func TestEncoder_WithOptions(t *testing.T) {
+	input := &struct {
+		Name string `osquery:"name" json:"process_name"`
+		PID  int    `osquery:"pid"`
+		UID  int    `osquery:"uid"`
+	}{Name: "osqueryd", PID: 42, UID: 0}
+
+	base := NewEncoder(Options{
+		Flags:       EncodingFlagUseNumbersZeroValues,
+		TagPriority: []string{"osquery"},
+		ExcludeKeys: []string{"uid"},
+	})
+	expected := map[string]string{"name": "osqueryd", "pid": "42"}
+
+	derived := base.WithOptions(func(o *Options) {
+		o.TagPriority[0] = "json"
+		o.ExcludeKeys[0] = "PID"
+		o.IncludeKeys = append(o.IncludeKeys, "process_name", "PID", "UID")
+		o.Flags = 0
+	})
+
+	got, err := derived.Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if expected := map[string]string{"process_name": "osqueryd", "UID": ""}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("derived Marshal() = %v; expected %v", got, expected)
+	}
+
+	got, err = base.Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("base Marshal() = %v; expected %v", got, expected)
+	}
+	opts := base.Options()
+	if opts.TagPriority[0] != "osquery" || opts.ExcludeKeys[0] != "uid" || opts.IncludeKeys != nil {
+		t.Errorf("base options modified by the derived encoder: %+v", opts)
+	}
+
+	// Modifying the options passed to NewEncoder, or returned by Options, doesn't affect it
+	opts.ExcludeKeys[0] = "name"
+	if got, _ := base.Marshal(input); !reflect.DeepEqual(got, expected) {
+		t.Errorf("base Marshal() after modifying its options = %v; expected %v", got, expected)
+	}
+}
+
+func TestEncoder_concurrentUse(t *testing.T) {
+	enc := NewEncoder(Options{SliceSep: "|", KeyFunc: func(key string) string { return "x_" + key }})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := enc.Marshal(&encoderTestStruct{Count: i, Tags: []string{"a", "b"}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if result["x_tags"] != "a|b" || len(result) != 6 {
+				t.Errorf("Marshal() = %v; expected 6 keys with joined tags", result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Marshal() failed: %v", err)
+	}
+}
+
+func TestMarshalToMapInto(t *testing.T) {
+	dst := map[string]string{"stale": "value"}
+	if err := MarshalToMapInto(&encoderTestStruct{Name: "first", Count: 1}, dst, 0); err != nil {
+		t.Fatalf("MarshalToMapInto() failed: %v", err)
+	}
+	expected := map[string]string{
+		"name":     "first",
+		"count":    "1",
+		"tags":     "",
+		"ports":    "",
+		"created":  "",
+		"modified": "",
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("MarshalToMapInto() = %v; expected %v", dst, expected)
+	}
+
+	// Reusing dst replaces its content
+	if err := MarshalToMapInto(map[string]any{"name": "second"}, dst, 0); err != nil {
+		t.Fatalf("MarshalToMapInto() failed: %v", err)
+	}
+	if !reflect.DeepEqual(dst, map[string]string{"name": "second"}) {
+		t.Errorf("MarshalToMapInto() = %v; expected only the second value", dst)
+	}
+
+	if err := MarshalToMapInto(&encoderTestStruct{}, nil, 0); err == nil {
+		t.Error("expected error for nil destination map, got nil")
+	}
+	if err := MarshalToMapInto(nil, dst, 0); err == nil {
+		t.Error("expected error for nil input, got nil")
+	}
+}
+
+func TestAppendToMap(t *testing.T) {
+	dst := make(map[string]string)
+	if err := AppendToMap(testHost{Hostname: "db1", OS: "linux"}, dst, "host.", 0); err != nil {
+		t.Fatalf("AppendToMap() failed: %v", err)
+	}
+	if err := AppendToMap(&testProcess{PID: 42, Name: "osqueryd"}, dst, "process.", 0); err != nil {
+		t.Fatalf("AppendToMap() failed: %v", err)
+	}
+	if err := AppendToMap(map[string]string{"id": "7"}, dst, "", 0); err != nil {
+		t.Fatalf("AppendToMap() failed: %v", err)
+	}
+	expected := map[string]string{
+		"host.hostname":   "db1",
+		"host.os":         "linux",
+		"process.pid":     "42",
+		"process.name":    "osqueryd",
+		"process.started": "",
+		"id":              "7",
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("AppendToMap() = %v; expected %v", dst, expected)
+	}
+
+	// Collisions are detected on the prefixed keys, and leave dst unchanged
+	err := AppendToMap(testHost{Hostname: "db2"}, dst, "host.", 0)
+	if err == nil || err.Error() != "duplicate keys in destination map: host.hostname, host.os" {
+		t.Errorf("AppendToMap() error = %v; expected duplicate host keys", err)
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("AppendToMap() with collisions modified dst: %v", dst)
+	}
+	if err := AppendToMap(testHost{Hostname: "db2"}, dst, "peer.", 0); err != nil {
+		t.Errorf("AppendToMap() with another prefix failed: %v", err)
+	}
+
+	if err := AppendToMap(testHost{}, nil, "host.", 0); err == nil {
+		t.Error("expected error for nil destination map, got nil")
+	}
+	if err := AppendToMap(nil, dst, "host.", 0); err == nil {
+		t.Error("expected error for nil input, got nil")
+	}
+}
+
+func TestStructFields_cache(t *testing.T) {
+	type first struct {
+		ProcessID int
+	}
+	type second struct {
+		ProcessID int `osquery:"pid"`
+	}
+
+	// The same type must be resolved again for flags changing the keys, and types with the
+	// same layout must not share their fields
+	tests := []struct {
+		input    any
+		flags    EncodingFlag
+		expected map[string]string
+	}{
+		{input: first{ProcessID: 1}, flags: 0, expected: map[string]string{"ProcessID": "1"}},
+		{input: first{ProcessID: 1}, flags: EncodingFlagSnakeCaseKeys, expected: map[string]string{"process_id": "1"}},
+		{input: first{ProcessID: 1}, flags: EncodingFlagUseNumbersZeroValues, expected: map[string]string{"ProcessID": "1"}},
+		{input: second{ProcessID: 1}, flags: EncodingFlagSnakeCaseKeys, expected: map[string]string{"pid": "1"}},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			result, err := MarshalToMapWithFlags(test.input, test.flags)
+			if err != nil {
+				t.Fatalf("MarshalToMapWithFlags(%T, %v) failed: %v", test.input, test.flags, err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("MarshalToMapWithFlags(%T, %v) = %v; expected %v", test.input, test.flags, result, test.expected)
+			}
+		}
+	}
+}
+
+// benchmarkRow has 15 fields of the usual column types.
+type benchmarkRow struct {
+	PID       int               `osquery:"pid"`
+	PPID      int               `osquery:"ppid"`
+	UID       uint32            `osquery:"uid"`
+	GID       uint32            `osquery:"gid"`
+	Name      string            `osquery:"name"`
+	Path      string            `osquery:"path"`
+	Cmdline   string            `osquery:"cmdline,omitempty"`
+	State     string            `osquery:"state,default=unknown"`
+	Threads   int64             `osquery:"threads"`
+	Nice      int8              `osquery:"nice,string"`
+	CPU       float64           `osquery:"cpu,prec=2"`
+	Root      bool              `osquery:"root"`
+	Started   time.Time         `osquery:"started" format:"unix"`
+	Elapsed   time.Duration     `osquery:"elapsed,duration=ms"`
+	Container *encoderContainer `osquery:"container"`
+}
+
+type encoderContainer struct {
+	ID string `osquery:"id"`
+}
+
+func newBenchmarkRow() *benchmarkRow {
+	return &benchmarkRow{
+		PID:       1234,
+		PPID:      1,
+		UID:       1000,
+		GID:       1000,
+		Name:      "bash",
+		Path:      "/bin/bash",
+		Threads:   4,
+		CPU:       1.5,
+		Started:   time.Unix(1700000000, 0),
+		Elapsed:   time.Minute,
+		Container: &encoderContainer{ID: "abc"},
+	}
+}
+
+func BenchmarkEncoder_Marshal(b *testing.B) {
+	row := newBenchmarkRow()
+	enc := NewEncoder(Options{})
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := enc.Marshal(row); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// The baseline resolves the fields and their tag options on every call
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fieldCache.Clear()
+			if _, err := enc.Marshal(row); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncoder_MarshalInto(b *testing.B) {
+	row := newBenchmarkRow()
+	enc := NewEncoder(Options{})
+	dst := make(map[string]string)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.MarshalInto(row, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// plainBenchmarkRow has 15 fields of builtin types without tag options, the common case of
+// the package functions.
+type plainBenchmarkRow struct {
+	PID     int       `osquery:"pid"`
+	PPID    int       `osquery:"ppid"`
+	UID     uint32    `osquery:"uid"`
+	GID     uint32    `osquery:"gid"`
+	Name    string    `osquery:"name"`
+	Path    string    `osquery:"path"`
+	Cmdline string    `osquery:"cmdline"`
+	State   string    `osquery:"state"`
+	Threads int64     `osquery:"threads"`
+	Nice    int8      `osquery:"nice"`
+	CPU     float64   `osquery:"cpu"`
+	Mem     float64   `osquery:"mem"`
+	Root    bool      `osquery:"root"`
+	Started time.Time `osquery:"started" format:"unix"`
+	Cwd     string    `osquery:"cwd"`
+}
+
+func BenchmarkMarshalToMap(b *testing.B) {
+	row := &plainBenchmarkRow{
+		PID:     1234,
+		PPID:    1,
+		UID:     1000,
+		GID:     1000,
+		Name:    "bash",
+		Path:    "/bin/bash",
+		Cmdline: "bash -l",
+		State:   "R",
+		Threads: 4,
+		Nice:    -5,
+		CPU:     1.5,
+		Mem:     0.25,
+		Root:    true,
+		Started: time.Unix(1700000000, 0),
+		Cwd:     "/root",
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalToMap(row); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,11 +5,24 @@
 package encoding
 
 import (
+	"database/sql/driver"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"net"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/osquery/osquery-go/plugin/table"
 )
@@ -22,8 +35,152 @@ const (
 	// are converted to empty strings, but this flag preserves them as "0".
 	EncodingFlagUseNumbersZeroValues EncodingFlag = 1 << iota
 
+	// EncodingFlagEmptyStringAsError makes decoding fail when an empty string is found for a
+	// non-pointer field that the encoder would never have rendered as empty with the same flags:
+	// bools always, and numbers and times when EncodingFlagUseNumbersZeroValues is also set.
+	// By default, empty strings decode to the zero value.
+	EncodingFlagEmptyStringAsError
+
+	// EncodingFlagTimeAsUnix renders time.Time values as Unix epoch seconds instead of RFC3339.
+	// Fields with a "layout" option or a "format" tag keep their own format.
+	EncodingFlagTimeAsUnix
+
+	// EncodingFlagTimeAsUnixMilli renders time.Time values as Unix epoch milliseconds instead
+	// of RFC3339. It takes precedence over EncodingFlagTimeAsUnix when both are set.
+	EncodingFlagTimeAsUnixMilli
+
+	// EncodingFlagJSONSlices renders slices and arrays as a JSON array of their converted
+	// elements, e.g. ["a","b"] or [1,0], instead of joining them with a separator. This is
+	// safe for elements containing the separator, which isn't escaped otherwise. Numbers and
+	// bools are JSON numbers and bools, unless their tag renders them as text, e.g. in
+	// another base, and the other elements are JSON strings.
+	EncodingFlagJSONSlices
+
+	// EncodingFlagJSONComplex renders slices, arrays and maps with encoding/json, e.g. a
+	// map[string]any as a JSON object, and slices of structs as a JSON array of their rows.
+	// It takes precedence over EncodingFlagJSONSlices.
+	EncodingFlagJSONComplex
+
+	// EncodingFlagBytesHex renders []byte and [N]byte values as lowercase hex instead of base64.
+	EncodingFlagBytesHex
+
+	// EncodingFlagBytesRaw renders []byte and [N]byte values verbatim as a UTF-8 string instead
+	// of base64. It takes precedence over EncodingFlagBytesHex.
+	EncodingFlagBytesRaw
+
+	// EncodingFlagSnakeCaseKeys converts the names of untagged fields from CamelCase to
+	// snake_case when using them as keys, e.g. "ProcessID" becomes "process_id". The names
+	// set in "osquery" tags are never converted.
+	EncodingFlagSnakeCaseKeys
+
+	// EncodingFlagFiniteFloatsOnly renders NaN and infinite float values as empty strings, like
+	// missing values, instead of "NaN", "+Inf" or "-Inf", which numeric consumers can't parse.
+	// The "prec" option doesn't apply to these values, with or without this flag.
+	EncodingFlagFiniteFloatsOnly
+
+	// EncodingFlagSkipComplex leaves complex64 and complex128 values out of the row, like
+	// channels and functions. By default, they are reported as errors, as osquery has no
+	// column type for them.
+	EncodingFlagSkipComplex
+
+	// EncodingFlagErrorOnDuplicateKeys returns an error naming the fields when two fields of a
+	// struct resolve to the same key, instead of letting the last one overwrite the other.
+	// Fields shadowing the ones promoted from embedded structs are not duplicates. The check
+	// is the one done by Validate, and is cached for each struct type.
+	EncodingFlagErrorOnDuplicateKeys
+
+	// EncodingFlagFallbackJSONTag names the fields without a name in their "osquery" tag from
+	// their "json" tag, before falling back to the field name. Fields tagged `json:"-"` are
+	// skipped, and the "json" options are ignored.
+	EncodingFlagFallbackJSONTag
+
+	// EncodingFlagCaseInsensitiveKeys makes UnmarshalMap match the keys of the input to the
+	// fields ignoring case when there is no exact match, as documented in UnmarshalMap.
+	EncodingFlagCaseInsensitiveKeys
+
+	// EncodingFlagDisallowUnknownKeys makes UnmarshalMap return an error listing the keys of
+	// the input without a matching field, unless the struct has a field with the "remaining"
+	// option.
+	EncodingFlagDisallowUnknownKeys
+
+	// EncodingFlagStrictNumericParse makes UnmarshalMap return an error for empty values of
+	// integer and float fields, instead of decoding them to zero. Pointers and database/sql
+	// nullable types still decode empty values to nil and invalid values.
+	EncodingFlagStrictNumericParse
+
+	// EncodingFlagLenientBools makes UnmarshalMap accept "yes" and "no", ignoring case, for
+	// bool fields, in addition to "1"/"0", "true"/"false" and "t"/"f".
+	EncodingFlagLenientBools
+
+	// EncodingFlagBoolAsTrueFalse renders bool values as "true" and "false" instead of "1"
+	// and "0", e.g. for ECS documents or columns holding JSON. The "booltext" tag option does
+	// the same for a single field. UnmarshalMap accepts both forms, with or without the flag.
+	EncodingFlagBoolAsTrueFalse
+
+	// EncodingFlagTrimSpace removes the leading and trailing white space of string values,
+	// including the elements of slices and the values returned by MarshalText and String
+	// methods, so that padded values don't break exact matches in queries. The values
+	// returned by MarshalOsquery methods and registered converters are kept as is.
+	EncodingFlagTrimSpace
+
+	// EncodingFlagLowercaseKeys lowercases the final keys of the rows, after the names are
+	// resolved from the tags and fields and transformed by Options.KeyFunc. An error is
+	// returned when different keys are lowercased to the same one, e.g. the keys of fields
+	// tagged "ID" and "id". IncludeKeys and ExcludeKeys are matched against the lowercased
+	// keys.
+	EncodingFlagLowercaseKeys
+
+	// EncodingFlagWarnIntOverflow reports the integer fields whose value is out of range of
+	// their column type to Options.OnOverflow, as consumers may parse INTEGER columns as
+	// signed 32-bit integers and BIGINT columns as signed 64-bit integers, e.g. a uint64 above
+	// math.MaxInt64. The column types are the ones of GenerateColumnDefinitions. The values are
+	// still rendered in full, e.g. "18446744073709551615", and the rows are not changed.
+	EncodingFlagWarnIntOverflow
+
+	// EncodingFlagErrorOnUnsupported returns an error for the values of the kinds without a
+	// dedicated conversion, like uintptr, unsafe.Pointer or maps with struct keys, instead of
+	// rendering them with fmt.Sprintf, so that tests catch the types missing a mapping. Values
+	// implementing OsqueryMarshaler, encoding.TextMarshaler or fmt.Stringer, or with a
+	// registered converter, are still rendered.
+	EncodingFlagErrorOnUnsupported
+)
+
+const (
 	DefaultTimeFormat = time.RFC3339
 	DefaultTimezone   = "UTC"
+
+	DefaultSliceSeparator = ","
+
+	// DefaultMaxDepth is the maximum nesting of structs and maps, used when Options.MaxDepth
+	// is zero.
+	DefaultMaxDepth = 32
+
+	// DefaultRedactMask replaces the values of the fields with the "redact" option, used
+	// when Options.RedactMask is empty.
+	DefaultRedactMask = "***"
+)
+
+// OsqueryMarshaler is the interface implemented by types that can render themselves
+// as an osquery column value. It takes precedence over the built-in conversions.
+type OsqueryMarshaler interface {
+	MarshalOsquery() (string, error)
+}
+
+var (
+	timeType             = reflect.TypeOf(time.Time{})
+	durationType         = reflect.TypeOf(time.Duration(0))
+	osqueryMarshalerType = reflect.TypeFor[OsqueryMarshaler]()
+	textMarshalerType    = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType  = reflect.TypeFor[encoding.TextUnmarshaler]()
+	stringerType         = reflect.TypeFor[fmt.Stringer]()
+	rawMessageType       = reflect.TypeFor[json.RawMessage]()
+	jsonNumberType       = reflect.TypeFor[json.Number]()
+	ipType               = reflect.TypeFor[net.IP]()
+	ipNetType            = reflect.TypeFor[net.IPNet]()
+	hardwareAddrType     = reflect.TypeFor[net.HardwareAddr]()
+	bigIntType           = reflect.TypeFor[big.Int]()
+	bigFloatType         = reflect.TypeFor[big.Float]()
+	valuerType           = reflect.TypeFor[driver.Valuer]()
 )
 
 func (f EncodingFlag) has(option EncodingFlag) bool {
@@ -32,16 +189,72 @@ func (f EncodingFlag) has(option EncodingFlag) bool {
 
 // MarshalToMap converts a struct, a single-level map (like map[string]string
 // or map[string]any), or a pointer to these, into a map[string]string.
-// It prioritizes the "osquery" tag for struct fields.
-func MarshalToMap(in any) (map[string]string, error) {
-	return MarshalToMapWithFlags(in, 0)
+// It prioritizes the "osquery" tag for struct fields, and converts the values as documented
+// in the package documentation. A typed nil map produces an empty row, while a nil in or a
+// nil pointer is reported as an error, and slices and arrays, which hold several rows, are
+// converted with MarshalRows instead. The conversion can be tweaked with functional
+// options, e.g. MarshalToMap(in, WithFlags(EncodingFlagSnakeCaseKeys)).
+func MarshalToMap(in any, opts ...Option) (map[string]string, error) {
+	if len(opts) == 0 {
+		return MarshalToMapWithFlags(in, 0)
+	}
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewEncoder(o).Marshal(in)
 }
 
 func MarshalToMapWithFlags(in any, flags EncodingFlag) (map[string]string, error) {
+	return defaultEncoder.marshal(in, flags)
+}
+
+// MarshalToMapWithKeyFunc is like MarshalToMapWithFlags, but the keys of the result are
+// transformed by keyFunc. It receives the resolved keys, like "pid" or "process.pid" for
+// nested fields, and returns the final ones. An error is returned when keyFunc maps two
+// different keys to the same one.
+func MarshalToMapWithKeyFunc(in any, flags EncodingFlag, keyFunc func(string) string) (map[string]string, error) {
+	return NewEncoder(Options{Flags: flags, KeyFunc: keyFunc}).Marshal(in)
+}
+
+// MarshalToMapInto is like MarshalToMapWithFlags, but writes into dst instead of allocating
+// a new map, so that callers converting many values can reuse it. dst is cleared first, and
+// holds a partial result on error. The rows passed to osquery must not be reused, so callers
+// must copy dst, or be done with it, before the next call.
+func MarshalToMapInto(in any, dst map[string]string, flags EncodingFlag) error {
+	return defaultEncoder.marshalInto(in, dst, flags)
+}
+
+// AppendToMap is like MarshalToMapWithFlags, but adds the keys of the result to dst,
+// prefixed with prefix, to compose one row from several values, e.g. with the "host." and
+// "process." prefixes. The prefix is used as is, and an error is returned if a prefixed key
+// is already in dst, in which case dst is not modified.
+func AppendToMap(in any, dst map[string]string, prefix string, flags EncodingFlag) error {
+	return defaultEncoder.appendToMap(in, dst, prefix, flags)
+}
+
+// MarshalToMapAll is like MarshalToMapWithFlags, but continues past the fields that cannot
+// be converted. It returns the keys of the fields that were converted, along with the
+// errors of the other fields joined with errors.Join, each being a MarshalError.
+func MarshalToMapAll(in any, flags EncodingFlag) (map[string]string, error) {
+	return defaultEncoder.marshalAll(in, flags)
+}
+
+// marshalToMap converts in into the result map of state, or into a new map if it is nil,
+// using the options of state. Panics raised while converting the values, e.g. by reflection
+// on a malformed value, are returned as a MarshalError for the field being converted rather
+// than crashing the caller.
+func marshalToMap(in any, state *encodeState) (result map[string]string, err error) {
+	defer state.recoverPanic(&result, &err)
+
 	if in == nil {
 		return nil, fmt.Errorf("input cannot be nil")
 	}
-	result := make(map[string]string)
+	if state.result == nil {
+		state.result = make(map[string]string)
+	}
+	result = state.result
+	flags := state.flags
 
 	v := reflect.ValueOf(in)
 	t := reflect.TypeOf(in)
@@ -50,287 +263,1976 @@ func MarshalToMapWithFlags(in any, flags EncodingFlag) (map[string]string, error
 		if v.IsNil() {
 			return nil, fmt.Errorf("input pointer is nil")
 		}
+		// The input is being descended into too, so that the fields referencing it are
+		// reported at their own key. It doesn't count in the depth, which starts below it.
+		state.input = visitKey{ptr: v.Pointer(), t: v.Type()}
 		v = v.Elem()
 		t = t.Elem()
 	}
 
 	if v.Kind() == reflect.Map {
-		if t.Key().Kind() != reflect.String {
-			return nil, fmt.Errorf("map keys must be strings, got %s", t.Key().Kind())
+		if !isMapKeyType(t.Key()) {
+			return nil, fmt.Errorf("map keys must be strings, numbers, bools, or implement fmt.Stringer or encoding.TextMarshaler, got %s", t.Key())
 		}
+		// The entries are flattened like the ones of map fields, and cannot collide either
+		state.mapDepth++
+		text := typeTextMode(t.Elem())
 
-		for _, k := range v.MapKeys() {
-			key := k.String()
-			fieldValue := v.MapIndex(k)
+		// MapRange yields the entries of NaN keys, which MapIndex cannot look up
+		for iter := v.MapRange(); iter.Next(); {
+			key, err := state.opts.formatMapKey(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			state.current, state.currentName = key, key
+			// Formatted keys may collide, e.g. with a String method returning the same name
+			if _, ok := result[key]; ok && t.Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("duplicate key %s from map keys formatting to the same string", key)
+			}
+			fieldValue := iter.Value()
+			// Values of map[string]any are wrapped in an interface, unwrap them to
+			// convert the dynamic value, e.g. a time.Time
+			if fieldValue.Kind() == reflect.Interface && !fieldValue.IsNil() {
+				fieldValue = fieldValue.Elem()
+			}
+			if isSkippedType(fieldValue.Type(), flags) {
+				continue
+			}
+			if flattened, err := state.marshalDynamic(fieldValue, key, key, &untaggedField); flattened || err != nil {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
 
-			value, err := convertValueToStringWithTag(fieldValue, flags, nil)
+			value, err := state.convert(fieldValue, key, flags, &untaggedField.value)
 			if err != nil {
-				return nil, fmt.Errorf("failed to convert field %s: %w", key, err)
+				if err := state.fieldError(key, key, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			value, _ = untaggedField.options.apply(state.opts, fieldValue, value, text)
+			if err := state.set(key, value); err != nil {
+				return nil, err
 			}
-			result[key] = value
 		}
-		return result, nil
+		return result, errors.Join(state.errs...)
 	}
 
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		return nil, fmt.Errorf("unsupported type: %s, must be a struct, map, or pointer to one of them (use MarshalRows to convert each element into a row)", v.Kind())
+	}
 	if v.Kind() != reflect.Struct {
 		return nil, fmt.Errorf("unsupported type: %s, must be a struct, map, or pointer to one of them", v.Kind())
 	}
 
-	for i := 0; i < v.NumField(); i++ {
-		fieldValue := v.Field(i)
-		fieldType := t.Field(i)
-
-		if !fieldType.IsExported() {
-			continue
+	if flags.has(EncodingFlagErrorOnDuplicateKeys) {
+		if err := checkDuplicateKeys(v.Type(), flags, state.keyTags); err != nil {
+			return nil, err
 		}
+	}
+	if err := state.marshalStruct(v, ""); err != nil {
+		return nil, err
+	}
 
-		key := fieldType.Tag.Get("osquery")
-		switch key {
-		case "-":
-			continue
-		case "":
-			key = fieldType.Name
-		}
+	return result, errors.Join(state.errs...)
+}
 
-		value, err := convertValueToStringWithTag(fieldValue, flags, &fieldType.Tag)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert field %s: %w", key, err)
-		}
+// encodeState holds the row being built while marshaling a struct.
+type encodeState struct {
+	opts   *Options
+	result map[string]string
+	flags  EncodingFlag
 
-		result[key] = value
-	}
+	// keyTags are the tags holding the names of the columns, as returned by Options.keyTags.
+	keyTags string
 
-	return result, nil
+	// filter selects the keys kept in the row, as set by the IncludeKeys and ExcludeKeys
+	// options.
+	filter *keyFilter
+
+	// keySources holds the key each key transformed by the KeyFunc option was produced
+	// from, to detect collisions.
+	keySources map[string]string
+
+	// path holds the names of the struct fields and map entries being marshaled, not
+	// including promoted fields, e.g. ["process", "args"].
+	path []string
+
+	// collectErrors makes the conversion continue past the fields that cannot be converted,
+	// whose errors are recorded in errs.
+	collectErrors bool
+	errs          []error
+
+	// mapKeys holds the keys set while flattening map fields, which cannot collide with
+	// other keys. mapDepth is non-zero while flattening a map field.
+	mapKeys  map[string]struct{}
+	mapDepth int
+
+	// order, when non-nil, records the keys of the row in the order they were first set.
+	order *[]string
+
+	// visiting holds the pointers and maps being descended into, to detect cycles, except
+	// for the input pointer held by input, and depth the number of structs and maps being
+	// descended into.
+	visiting map[visitKey]struct{}
+	input    visitKey
+	depth    int
+
+	// current is the key of the value being converted, and currentName its name in the
+	// struct or map holding it, to report panics.
+	current     string
+	currentName string
 }
 
-func GenerateColumnDefinitions(in any) ([]table.ColumnDefinition, error) {
-	if in == nil {
-		return nil, fmt.Errorf("input cannot be nil")
+// recoverPanic stores in err a MarshalError for the value being converted when the
+// conversion panics, along with the errors collected so far. When collecting errors, the
+// keys converted so far are stored in result, and it is cleared otherwise. It must be
+// deferred.
+func (s *encodeState) recoverPanic(result *map[string]string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	path := make([]string, len(s.path), len(s.path)+1)
+	copy(path, s.path)
+	if s.currentName != "" {
+		path = append(path, s.currentName)
+	}
+	panicErr := &MarshalError{Field: s.current, Path: path, Err: fmt.Errorf("panic: %v", r)}
+	*err = errors.Join(append(s.errs, panicErr)...)
+	*result = nil
+	if s.collectErrors {
+		*result = s.result
 	}
+}
 
-	t := reflect.TypeOf(in)
+// visitKey identifies a pointer or map being descended into. The type is needed as a struct
+// and its first field share the same address.
+type visitKey struct {
+	ptr uintptr
+	t   reflect.Type
+}
 
-	var columns []table.ColumnDefinition
+// enter records that the struct or map v, stored in key, is being descended into. It
+// returns an error if v is a pointer or map already being descended into, as it then
+// references itself and would be marshaled forever, or if the descent would exceed the
+// maximum depth. Each successful call must be paired with leave.
+func (s *encodeState) enter(v reflect.Value, key string) error {
+	isRef := v.Kind() == reflect.Ptr || v.Kind() == reflect.Map
+	if isRef {
+		visit := visitKey{ptr: v.Pointer(), t: v.Type()}
+		if _, ok := s.visiting[visit]; ok || visit == s.input {
+			return fmt.Errorf("cycle detected at %s: value of type %s references itself", key, v.Type())
+		}
+	}
+	if maxDepth := s.opts.maxDepth(); maxDepth > 0 && s.depth >= maxDepth {
+		return fmt.Errorf("maximum depth of %d exceeded at %s", maxDepth, key)
+	}
 
-	// Handle pointer types by unwrapping to get the underlying type
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+	if isRef {
+		if s.visiting == nil {
+			s.visiting = make(map[visitKey]struct{})
+		}
+		s.visiting[visitKey{ptr: v.Pointer(), t: v.Type()}] = struct{}{}
 	}
+	s.depth++
+	return nil
+}
 
-	if t.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("unsupported type: %s, must be a struct or pointer to struct", t.Kind())
+// leave records that the descent into v, started by enter, is done.
+func (s *encodeState) leave(v reflect.Value) {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Map {
+		delete(s.visiting, visitKey{ptr: v.Pointer(), t: v.Type()})
 	}
+	s.depth--
+}
 
-	for i := 0; i < t.NumField(); i++ {
-		fieldType := t.Field(i)
+// set stores the value of key in the row. Struct fields sharing a key overwrite each other,
+// but keys set by flattening a map field must be unique. Keys filtered out by the options are
+// ignored.
+func (s *encodeState) set(key, value string) error {
+	if s.opts.KeyFunc != nil || s.flags.has(EncodingFlagLowercaseKeys) {
+		transformed := s.transformKey(key)
+		if source, ok := s.keySources[transformed]; ok && source != key {
+			return s.transformError(source, key, transformed)
+		}
+		if s.keySources == nil {
+			s.keySources = make(map[string]string)
+		}
+		s.keySources[transformed] = key
+		key = transformed
+	}
+	if !s.filter.keep(key) {
+		s.report(key, false)
+		return nil
+	}
+	if s.opts.FieldFilter != nil && !s.opts.FieldFilter(key, value) {
+		s.report(key, false)
+		return nil
+	}
 
-		if !fieldType.IsExported() {
-			continue
+	if _, ok := s.result[key]; ok {
+		_, fromMap := s.mapKeys[key]
+		if fromMap || s.mapDepth > 0 {
+			return fmt.Errorf("duplicate key %s from flattened map field", key)
 		}
+	}
 
-		tag := fieldType.Tag
-		key := tag.Get("osquery")
-		switch key {
-		case "-":
-			continue
-		case "":
-			key = fieldType.Name
+	if s.mapDepth > 0 {
+		if s.mapKeys == nil {
+			s.mapKeys = make(map[string]struct{})
+		}
+		s.mapKeys[key] = struct{}{}
+	}
+	if s.order != nil {
+		if _, ok := s.result[key]; !ok {
+			*s.order = append(*s.order, key)
 		}
+	}
+	s.result[key] = value
+	s.report(key, true)
+	return nil
+}
+
+// transformError returns the error of the keys source and key, both transformed to
+// transformed by transformKey.
+func (s *encodeState) transformError(source, key, transformed string) error {
+	if s.opts.KeyFunc == nil {
+		return fmt.Errorf("lowercasing maps both %s and %s to %s", source, key, transformed)
+	}
+	return fmt.Errorf("key function maps both %s and %s to %s", source, key, transformed)
+}
 
-		// Determine column type based on Go type
-		var column table.ColumnDefinition
-		fieldKind := fieldType.Type.Kind()
+// transformKey returns the final key of key, transformed by the KeyFunc option and then
+// lowercased with EncodingFlagLowercaseKeys.
+func (s *encodeState) transformKey(key string) string {
+	if s.opts.KeyFunc != nil {
+		key = s.opts.KeyFunc(key)
+	}
+	if s.flags.has(EncodingFlagLowercaseKeys) {
+		key = strings.ToLower(key)
+	}
+	return key
+}
 
-		// Handle pointer types by unwrapping to get the underlying type
-		if fieldKind == reflect.Ptr {
-			fieldKind = fieldType.Type.Elem().Kind()
-		}
+// skip reports that the column stored in key, before it is transformed by transformKey, is
+// left out of the row.
+func (s *encodeState) skip(key string) {
+	if s.opts.OnField == nil {
+		return
+	}
+	s.opts.OnField(s.transformKey(key), false)
+}
 
-		switch fieldKind {
-		case reflect.String:
-			column = table.TextColumn(key)
+// report calls the OnField option, if set, for the final key of a column.
+func (s *encodeState) report(key string, emitted bool) {
+	if s.opts.OnField != nil {
+		s.opts.OnField(key, emitted)
+	}
+}
 
-		case reflect.Bool:
-			column = table.IntegerColumn(key)
+// marshalStruct converts the exported fields of the struct v into the row. Nested struct
+// fields (or non-nil pointers to them) are descended into, and their fields are stored
+// under the parent key followed by a dot, e.g. "process.pid". Map fields are flattened
+// the same way, unless tagged with the "inline" option, which omits the parent key.
+func (s *encodeState) marshalStruct(v reflect.Value, prefix string) error {
+	fields := structFields(v.Type(), s.flags, s.keyTags)
+	for i := range fields {
+		field := &fields[i]
+		fieldValue := v.Field(field.index)
+		key := prefix + field.key
+		s.current, s.currentName = key, field.key
 
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
-			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
-			column = table.IntegerColumn(key)
+		switch field.kind {
+		case fieldPromoted:
+			// Promoted fields come first, so that they are shadowed by the fields of the
+			// outer struct sharing their key, like in Go
+			embedded, ok := derefValue(fieldValue)
+			if !ok {
+				// Nil embedded pointers contribute no keys
+				continue
+			}
+			// Promoted fields have no key of their own, the cycle is reported at the field name
+			if err := s.enter(fieldValue, prefix+v.Type().Field(field.index).Name); err != nil {
+				return err
+			}
+			err := s.marshalStruct(embedded, prefix)
+			s.leave(fieldValue)
+			if err != nil {
+				return err
+			}
+			continue
 
-		case reflect.Int64, reflect.Uint64:
-			column = table.BigIntColumn(key)
+		case fieldNested:
+			nested, ok := derefValue(fieldValue)
+			if !ok {
+				// Nil pointers to nested structs contribute no keys
+				continue
+			}
+			if err := s.enter(fieldValue, key); err != nil {
+				return err
+			}
+			s.path = append(s.path, field.key)
+			err := s.marshalStruct(nested, key+".")
+			s.path = s.path[:len(s.path)-1]
+			s.leave(fieldValue)
+			if err != nil {
+				return err
+			}
+			continue
 
-		case reflect.Float32, reflect.Float64:
-			column = table.DoubleColumn(key)
+		case fieldMap:
+			m, ok := derefValue(fieldValue)
+			if !ok {
+				continue
+			}
+			if err := s.enter(m, key); err != nil {
+				return err
+			}
+			var err error
+			if field.inline {
+				err = s.marshalMap(m, prefix, field)
+			} else {
+				s.path = append(s.path, field.key)
+				err = s.marshalMap(m, key+".", field)
+				s.path = s.path[:len(s.path)-1]
+			}
+			s.leave(m)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-		case reflect.Struct:
-			// Handle time.Time type
-			switch fieldType.Type {
-			case reflect.TypeOf(time.Time{}):
-				if timeFormat, ok := tag.Lookup("format"); ok {
-					switch strings.ToLower(timeFormat) {
-					case "unix", "unixnano", "unixmilli", "unixmicro":
-						column = table.BigIntColumn(key)
-					default:
-						column = table.TextColumn(key)
-					}
-				} else {
-					column = table.TextColumn(key)
+		// Interface fields are marshaled as their dynamic value, like the entries of maps
+		dynamic := fieldValue
+		if fieldValue.Kind() == reflect.Interface && !fieldValue.IsNil() {
+			dynamic = fieldValue.Elem()
+			if isSkippedType(dynamic.Type(), s.flags) {
+				continue
+			}
+			if flattened, err := s.marshalDynamic(dynamic, key, field.key, field); flattened || err != nil {
+				if err != nil {
+					return err
 				}
+				continue
+			}
+		}
+
+		var value string
+		var err error
+		if field.plain {
+			// Builtin scalars have neither marshalers nor registered conversions to look up
+			value, err = s.opts.convertKind(dynamic, s.flags|field.flags, &field.value)
+		} else {
+			value, err = s.convert(dynamic, key, s.flags|field.flags, &field.value)
+		}
+		if err != nil {
+			if err := s.fieldError(key, field.key, err); err != nil {
+				return err
 			}
-		default:
-			// we default to table.TextColumn for unsupported types
-			column = table.TextColumn(key)
+			continue
+		}
+		value, ok := field.options.apply(s.opts, dynamic, value, field.text)
+		if !ok {
+			s.skip(key)
+			continue
+		}
+		if s.flags.has(EncodingFlagWarnIntOverflow) && s.opts.OnOverflow != nil && overflowsColumn(fieldValue, &field.tag) {
+			s.opts.OnOverflow(s.transformKey(key), value)
 		}
 
-		columns = append(columns, column)
+		if err := s.set(key, value); err != nil {
+			return err
+		}
 	}
 
-	return columns, nil
+	return nil
 }
 
-// convertValueToStringWithTag converts a reflect.Value to a string, handling pointers,
-// booleans, integers, unsigned integers, floats, time.Time, and unsupported types.
-// It also handles the EncodingFlagUseNumbersZeroValues flag and the tag format and tz attributes.
-func convertValueToStringWithTag(fieldValue reflect.Value, flag EncodingFlag, tag *reflect.StructTag) (string, error) {
-	// Handle pointers first
-	if fieldValue.Kind() == reflect.Ptr {
-		if fieldValue.IsNil() {
-			return "", nil
+// convert converts the value v stored in key into a string, as convertValueToString does,
+// except that slices and arrays of structs are rendered as JSON arrays of rows, as are maps
+// of structs as JSON objects of rows with EncodingFlagJSONComplex.
+func (s *encodeState) convert(v reflect.Value, key string, flags EncodingFlag, valueOpts *valueOptions) (string, error) {
+	if elem, ok := derefValue(v); ok {
+		switch {
+		case isStructSequence(elem.Type()):
+			// Joining the elements would lose their fields, they are rows even without
+			// EncodingFlagJSONComplex
+			return s.marshalStructSequence(elem, key, flags)
+		case flags.has(EncodingFlagJSONComplex) && isStructMap(elem.Type()):
+			return s.marshalStructMap(elem, key, flags)
 		}
-		return convertValueToStringWithTag(fieldValue.Elem(), flag, tag)
 	}
+	return s.opts.convertValueToString(v, flags, valueOpts)
+}
 
-	switch fieldValue.Kind() {
-	case reflect.String:
-		return fieldValue.String(), nil
+// isStructSequence reports whether t is a slice or array of structs, or of pointers to them,
+// whose fields are marshaled individually.
+func isStructSequence(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return isNestedStruct(t.Elem()) && !implements(t, osqueryMarshalerType) &&
+			!implements(t, textMarshalerType) && !implements(t, stringerType)
+	default:
+		return false
+	}
+}
 
-	case reflect.Bool:
-		// osquery often expects boolean values as "0" or "1"
-		if fieldValue.Bool() {
-			return "1", nil
-		}
-		return "0", nil
+// isStructMap reports whether t is a map of structs, or of pointers to them, whose fields are
+// marshaled individually, with keys supported by formatMapKey.
+func isStructMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && isMapKeyType(t.Key()) && isNestedStruct(t.Elem()) &&
+		!implements(t, osqueryMarshalerType) && !implements(t, textMarshalerType) &&
+		!implements(t, stringerType)
+}
 
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		// osquery often expects empty string for 0 values unless necessary
-		val := fieldValue.Int()
-		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
-			return "", nil
-		}
-		return strconv.FormatInt(val, 10), nil
+// marshalStructSequence renders the slice or array of structs v, stored in key, as a JSON
+// array of the rows of its elements. Nil slices are rendered as empty strings, and nil
+// elements as null. The rows are built as documented in elementRow.
+func (s *encodeState) marshalStructSequence(v reflect.Value, key string, flags EncodingFlag) (string, error) {
+	if v.Kind() == reflect.Slice && v.IsNil() || v.Kind() == reflect.Array && v.Len() == 0 {
+		return "", nil
+	}
 
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		val := fieldValue.Uint()
-		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
-			return "", nil
+	opts := s.elementOptions()
+	rows := make([]map[string]string, v.Len())
+	for i := range rows {
+		row, err := s.elementRow(v.Index(i), key+"."+strconv.Itoa(i), opts, flags)
+		if err != nil {
+			return "", err
 		}
-		return strconv.FormatUint(val, 10), nil
+		rows[i] = row
+	}
 
-	case reflect.Float32:
-		val := fieldValue.Float()
-		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
-			return "", nil
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+// marshalStructMap renders the map of structs v, stored in key, as a JSON object of the rows
+// of its values, e.g. {"eth0":{"mac":"00:00:5e:00:53:01"}}, under their formatted map keys.
+// Nil maps are rendered as empty strings, and nil values as null. The rows are built as
+// documented in elementRow.
+func (s *encodeState) marshalStructMap(v reflect.Value, key string, flags EncodingFlag) (string, error) {
+	if v.IsNil() {
+		return "", nil
+	}
+
+	opts := s.elementOptions()
+	rows := make(map[string]map[string]string, v.Len())
+	for iter := v.MapRange(); iter.Next(); {
+		name, err := s.opts.formatMapKey(iter.Key())
+		if err != nil {
+			return "", err
 		}
-		// Use -1 for precision to format the smallest number of digits necessary
-		return strconv.FormatFloat(val, 'f', -1, 32), nil
-	case reflect.Float64:
-		val := fieldValue.Float()
-		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
-			return "", nil
+		if _, ok := rows[name]; ok {
+			return "", fmt.Errorf("duplicate key %s from map keys formatting to the same string", name)
 		}
-		return strconv.FormatFloat(val, 'f', -1, 64), nil
-
-	case reflect.Struct:
-		// Handle time.Time type
-		switch fieldValue.Type() {
-		case reflect.TypeOf(time.Time{}):
-			return formatTimeWithTagFormat(fieldValue, flag, tag)
-		default:
-			return "", fmt.Errorf("unsupported struct type: %s", fieldValue.Type())
+		row, err := s.elementRow(iter.Value(), key+"."+name, opts, flags)
+		if err != nil {
+			return "", err
 		}
+		rows[name] = row
+	}
 
-	// Default: use Sprintf for unsupported types
-	default:
-		if fieldValue.CanInterface() {
-			return fmt.Sprintf("%v", fieldValue.Interface()), nil
-		}
-		return "", fmt.Errorf("unsupported type (%s)", fieldValue.Kind())
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
 	}
+	return string(b), nil
 }
 
-// formatTimeWithTagFormat formats a time.Time value with the specified format
-// and timezone conversion if specified in the tag.
-func formatTimeWithTagFormat(fieldValue reflect.Value, flag EncodingFlag, tag *reflect.StructTag) (string, error) {
-	// Check if the value is zero and the flag is not set to use numbers zero values
-	if !flag.has(EncodingFlagUseNumbersZeroValues) && fieldValue.IsZero() {
-		return "", nil
-	}
+// elementOptions returns the options of the rows of the elements of slices, arrays and maps
+// rendered as JSON: the options of s, except the ones selecting and renaming the columns of
+// the row being built.
+func (s *encodeState) elementOptions() *Options {
+	opts := *s.opts
+	opts.KeyFunc = nil
+	opts.FieldFilter = nil
+	opts.OnField = nil
+	opts.OnOverflow = nil
+	return &opts
+}
 
-	t, ok := fieldValue.Interface().(time.Time)
+// elementRow returns the row of the struct elem, or of the struct it points to, stored in key
+// as an element of a slice, array or map, or nil for nil pointers. The row is built with opts,
+// as returned by elementOptions, and without EncodingFlagLowercaseKeys.
+func (s *encodeState) elementRow(elem reflect.Value, key string, opts *Options, flags EncodingFlag) (map[string]string, error) {
+	nested, ok := derefValue(elem)
 	if !ok {
-		return "", fmt.Errorf("expected time.Time value but got %v", fieldValue.Type())
+		return nil, nil
+	}
+	if err := s.enter(elem, key); err != nil {
+		return nil, err
 	}
+	defer s.leave(elem)
 
-	// If no tag is specified, use the default format
-	if tag == nil {
-		return t.Format(DefaultTimeFormat), nil
+	// The state of the element shares the descent of s, to detect cycles through it
+	elemState := &encodeState{
+		opts:     opts,
+		result:   make(map[string]string),
+		flags:    flags &^ EncodingFlagLowercaseKeys,
+		keyTags:  s.keyTags,
+		visiting: s.visiting,
+		input:    s.input,
+		depth:    s.depth,
 	}
+	if err := elemState.marshalStruct(nested, ""); err != nil {
+		return nil, err
+	}
+	return elemState.result, nil
+}
+
+// overflowsColumn reports whether the integer v, after dereferencing pointers, is out of
+// range of the osquery column type of its field: a signed 32-bit integer for INTEGER columns,
+// and a signed 64-bit integer for BIGINT columns.
+func overflowsColumn(v reflect.Value, tag *reflect.StructTag) bool {
+	v, ok := derefValue(v)
+	if !ok || !isIntegerKind(v.Kind()) {
+		return false
+	}
+	colType, err := fieldColumnType(v.Type(), v, tag)
+	if err != nil {
+		return false
+	}
+
+	switch colType {
+	case table.ColumnTypeInteger:
+		if v.CanInt() {
+			return v.Int() < math.MinInt32 || v.Int() > math.MaxInt32
+		}
+		return v.Uint() > math.MaxInt32
+	case table.ColumnTypeBigInt:
+		return v.CanUint() && v.Uint() > math.MaxInt64
+	default:
+		return false
+	}
+}
+
+// fieldError returns a MarshalError for the failed conversion of the value stored in key,
+// named name in the struct or map being marshaled. When collecting errors, the error is
+// recorded and nil is returned so that the caller continues with the next field.
+func (s *encodeState) fieldError(key, name string, err error) error {
+	path := make([]string, len(s.path), len(s.path)+1)
+	copy(path, s.path)
+	err = &MarshalError{Field: key, Path: append(path, name), Err: err}
+	if s.collectErrors {
+		s.errs = append(s.errs, err)
+		s.skip(key)
+		return nil
+	}
+	return err
+}
+
+// marshalMap flattens the entries of the map v into the row, storing them under
+// prefix followed by the map key. Struct and map values are flattened recursively, the other
+// values are converted using the tag options of field, the map field.
+func (s *encodeState) marshalMap(v reflect.Value, prefix string, field *fieldInfo) error {
+	s.mapDepth++
+	defer func() { s.mapDepth-- }()
 
-	// Handle timezone conversion if specified in tag
-	if tz, ok := tag.Lookup("tz"); ok {
-		loc, err := time.LoadLocation(tz)
+	flags := s.flags | field.flags
+	text := typeTextMode(v.Type().Elem())
+
+	// Sort the keys so that collisions are reported consistently
+	entries := make([]mapEntry, 0, v.Len())
+	for iter := v.MapRange(); iter.Next(); {
+		name, err := s.opts.formatMapKey(iter.Key())
 		if err != nil {
-			return "", fmt.Errorf("invalid timezone %s: %w", tz, err)
+			return err
 		}
-		t = t.In(loc)
-	} else {
-		loc, err := time.LoadLocation(DefaultTimezone)
+		entries = append(entries, mapEntry{name: name, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	for _, e := range entries {
+		name := e.name
+		key := prefix + name
+		s.current, s.currentName = key, name
+		entry := e.value
+		if entry.Kind() == reflect.Interface && !entry.IsNil() {
+			entry = entry.Elem()
+		}
+		if isSkippedType(entry.Type(), flags) {
+			continue
+		}
+
+		if flattened, err := s.marshalDynamic(entry, key, name, field); flattened || err != nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := s.convert(entry, key, flags, &field.value)
 		if err != nil {
-			return "", fmt.Errorf("invalid default timezone %s: %w", DefaultTimezone, err)
-		}
-		t = t.In(loc)
-	}
-
-	var result string
-	if timeFormat, ok := tag.Lookup("format"); ok {
-		switch strings.ToLower(timeFormat) {
-		case "unix":
-			result = strconv.FormatInt(t.Unix(), 10)
-		case "unixnano":
-			result = strconv.FormatInt(t.UnixNano(), 10)
-		case "unixmilli":
-			result = strconv.FormatInt(t.UnixMilli(), 10)
-		case "unixmicro":
-			result = strconv.FormatInt(t.UnixMicro(), 10)
-		case "rfc3339":
-			result = t.Format(time.RFC3339)
-		case "rfc3339nano":
-			result = t.Format(time.RFC3339Nano)
-		case "rfc822":
-			result = t.Format(time.RFC822)
-		case "rfc822z":
-			result = t.Format(time.RFC822Z)
-		case "rfc850":
-			result = t.Format(time.RFC850)
-		case "rfc1123":
-			result = t.Format(time.RFC1123)
-		case "rfc1123z":
-			result = t.Format(time.RFC1123Z)
-		case "kitchen":
-			result = t.Format(time.Stamp)
-		case "stampmilli":
-			result = t.Format(time.StampMilli)
-		case "stampmicro":
-			result = t.Format(time.StampMicro)
-		case "stampnano":
-			result = t.Format(time.StampNano)
-		default:
-			return "", fmt.Errorf("unsupported time format: %s", timeFormat)
+			if err := s.fieldError(key, name, err); err != nil {
+				return err
+			}
+			continue
 		}
-	} else {
-		result = t.Format(time.RFC3339)
+		value, ok := field.options.apply(s.opts, entry, value, text)
+		if !ok {
+			s.skip(key)
+			continue
+		}
+		if err := s.set(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// marshalDynamic flattens v, the dynamic value of a map entry or interface field stored in
+// key and named name, when it is a struct or a map, or a pointer to one, as the fields of
+// these types are. Maps are flattened with the tag options of field, the field holding v.
+// It returns false when v must be converted as a single value instead.
+func (s *encodeState) marshalDynamic(v reflect.Value, key, name string, field *fieldInfo) (bool, error) {
+	if isNestedStruct(v.Type()) {
+		nested, ok := derefValue(v)
+		if !ok {
+			return true, nil
+		}
+		if s.flags.has(EncodingFlagErrorOnDuplicateKeys) {
+			if err := checkDuplicateKeys(nested.Type(), s.flags, s.keyTags); err != nil {
+				return true, err
+			}
+		}
+		if err := s.enter(v, key); err != nil {
+			return true, err
+		}
+		s.path = append(s.path, name)
+		err := s.marshalStruct(nested, key+".")
+		s.path = s.path[:len(s.path)-1]
+		s.leave(v)
+		return true, err
+	}
+
+	if isFlattenedMap(v.Type(), s.flags) {
+		m, ok := derefValue(v)
+		if !ok {
+			return true, nil
+		}
+		if err := s.enter(m, key); err != nil {
+			return true, err
+		}
+		s.path = append(s.path, name)
+		err := s.marshalMap(m, key+".", field)
+		s.path = s.path[:len(s.path)-1]
+		s.leave(m)
+		return true, err
 	}
 
-	return result, nil
+	return false, nil
+}
+
+// GenerateColumnDefinitions returns the osquery columns of the rows produced by MarshalToMap
+// for a struct, or a pointer to one, using the same key resolution. Nested and embedded
+// structs produce one column per field, like "process.pid". Map fields are skipped, as their
+// keys are only known at runtime, and interface fields are a single TEXT column, whatever
+// their dynamic value.
+//
+// Column types are inferred from the Go types: bools and integers up to 32 bits are INTEGER,
+// 64-bit integers, durations and big.Int are BIGINT, floats and big.Float are DOUBLE,
+// time.Time fields are BIGINT when their "format" tag is a Unix one, and all the other types
+// are TEXT, as well as integers rendered in another base than 10. The type of json.Number
+// fields is inferred from their value in in: BIGINT for integers fitting in an int64, DOUBLE
+// for numbers with a fraction or an exponent, and TEXT for the other integers, which DOUBLE
+// columns cannot represent exactly, and for empty values or when in is a nil pointer. The
+// inferred type can be overridden with the "type" option of the tag, e.g.
+// `osquery:"raw,type=BIGINT"`, set to one of TEXT, INTEGER, BIGINT or DOUBLE, e.g. for
+// json.Number fields whose values vary. An error is returned for other values.
+//
+// Use Encoder.GenerateColumnDefinitions for the columns of the rows produced with options.
+func GenerateColumnDefinitions(in any) ([]table.ColumnDefinition, error) {
+	return defaultEncoder.GenerateColumnDefinitions(in)
+}
+
+// generateColumns returns the columns of the rows produced for in, using the options of
+// state.
+func generateColumns(in any, state *encodeState) ([]table.ColumnDefinition, error) {
+	if in == nil {
+		return nil, fmt.Errorf("input cannot be nil")
+	}
+
+	t := reflect.TypeOf(in)
+	// The value is only used to infer the types of json.Number columns, and is invalid for nil
+	// pointers
+	v, _ := derefValue(reflect.ValueOf(in))
+
+	// Handle pointer types by unwrapping to get the underlying type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unsupported type: %s, must be a struct or pointer to struct", t.Kind())
+	}
+
+	var columns []table.ColumnDefinition
+	if err := state.appendColumns(&columns, make(map[string]int), t, v, "", make(map[reflect.Type]bool)); err != nil {
+		return nil, err
+	}
+	return state.transformColumns(columns)
+}
+
+// appendColumns appends the columns of the fields of the struct type t to columns, using the
+// struct v of type t, if valid, to infer the types of json.Number columns. indexes maps the
+// names of the columns to their index, so that a field shadowing a promoted field replaces
+// its column. parents holds the struct types being visited to detect recursion.
+func (s *encodeState) appendColumns(columns *[]table.ColumnDefinition, indexes map[string]int, t reflect.Type, v reflect.Value, prefix string, parents map[reflect.Type]bool) error {
+	if parents[t] {
+		return fmt.Errorf("recursive type %s cannot be used to generate columns", t)
+	}
+	parents[t] = true
+	defer delete(parents, t)
+
+	for _, field := range structFields(t, s.flags, s.keyTags) {
+		fieldType := t.Field(field.index).Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		var fieldValue reflect.Value
+		if v.IsValid() {
+			fieldValue, _ = derefValue(v.Field(field.index))
+		}
+
+		key := prefix + field.key
+		switch field.kind {
+		case fieldPromoted:
+			if err := s.appendColumns(columns, indexes, fieldType, fieldValue, prefix, parents); err != nil {
+				return err
+			}
+			continue
+		case fieldNested:
+			if err := s.appendColumns(columns, indexes, fieldType, fieldValue, key+".", parents); err != nil {
+				return err
+			}
+			continue
+		case fieldMap:
+			continue
+		}
+
+		colType, err := fieldColumnType(fieldType, fieldValue, &field.tag)
+		if err != nil {
+			return fmt.Errorf("invalid column type for field %s: %w", key, err)
+		}
+
+		column := table.ColumnDefinition{Name: key, Type: colType}
+		if i, ok := indexes[key]; ok {
+			(*columns)[i] = column
+			continue
+		}
+		indexes[key] = len(*columns)
+		*columns = append(*columns, column)
+	}
+
+	return nil
+}
+
+// transformColumns renames the columns with transformKey, as set does for the keys of the
+// rows, and leaves out the columns excluded by the IncludeKeys and ExcludeKeys options.
+func (s *encodeState) transformColumns(columns []table.ColumnDefinition) ([]table.ColumnDefinition, error) {
+	if s.opts.KeyFunc == nil && !s.flags.has(EncodingFlagLowercaseKeys) && s.filter == nil {
+		return columns, nil
+	}
+
+	kept := columns[:0]
+	sources := make(map[string]string, len(columns))
+	for _, column := range columns {
+		name := s.transformKey(column.Name)
+		if source, ok := sources[name]; ok {
+			return nil, s.transformError(source, column.Name, name)
+		}
+		sources[name] = column.Name
+		if !s.filter.keep(name) {
+			continue
+		}
+		column.Name = name
+		kept = append(kept, column)
+	}
+	return kept, nil
+}
+
+// columnTypes maps the values of the "type" option to their osquery column type.
+var columnTypes = map[string]table.ColumnType{
+	"TEXT":    table.ColumnTypeText,
+	"INTEGER": table.ColumnTypeInteger,
+	"BIGINT":  table.ColumnTypeBigInt,
+	"DOUBLE":  table.ColumnTypeDouble,
+}
+
+// parseColumnType returns the osquery column type named by the "type" option, ignoring case.
+func parseColumnType(name string) (table.ColumnType, error) {
+	if colType, ok := columnTypes[strings.ToUpper(name)]; ok {
+		return colType, nil
+	}
+	return "", fmt.Errorf("unsupported column type: %s", name)
+}
+
+// fieldColumnType returns the osquery column type of a field of type t, after dereferencing
+// pointers, set by the "type" option of the tag or inferred by columnType. The value v of the
+// field, if valid, is used for json.Number fields, as inferred by numberColumnType.
+func fieldColumnType(t reflect.Type, v reflect.Value, tag *reflect.StructTag) (table.ColumnType, error) {
+	if name, ok := lookupTagOption(tag, "type"); ok {
+		return parseColumnType(name)
+	}
+	if t == jsonNumberType && v.IsValid() {
+		return numberColumnType(v.String()), nil
+	}
+	return columnType(t, tag), nil
+}
+
+// numberColumnType returns the osquery column type of the json.Number s: BIGINT for integers
+// fitting in an int64, DOUBLE for numbers with a fraction or an exponent, and TEXT otherwise.
+func numberColumnType(s string) table.ColumnType {
+	digits, frac, exp := scanNumber(s)
+	switch {
+	case digits == 0 || digits+frac+exp != len(s):
+		return table.ColumnTypeText
+	case frac > 0 || exp > 0:
+		return table.ColumnTypeDouble
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+		return table.ColumnTypeText
+	}
+	return table.ColumnTypeBigInt
+}
+
+// columnType returns the osquery column type of the values of type t, after dereferencing
+// pointers, as converted by convertValueToString.
+func columnType(t reflect.Type, tag *reflect.StructTag) table.ColumnType {
+	if isSQLNull(t) {
+		return columnType(t.Field(0).Type, tag)
+	}
+	if _, ok := lookupEnum(t); ok || hasConverter(t) {
+		return table.ColumnTypeText
+	}
+
+	switch t {
+	case timeType:
+		if _, ok := lookupTagOption(tag, "layout"); !ok {
+			if timeFormat, ok := tag.Lookup("format"); ok {
+				switch strings.ToLower(timeFormat) {
+				case "unix", "unixnano", "unixmilli", "unixmicro":
+					return table.ColumnTypeBigInt
+				}
+			}
+		}
+		return table.ColumnTypeText
+	case durationType, bigIntType:
+		return table.ColumnTypeBigInt
+	case bigFloatType:
+		return table.ColumnTypeDouble
+	case jsonNumberType:
+		// Without a value, json.Number may hold integers beyond the precision of DOUBLE columns
+		return table.ColumnTypeText
+	}
+
+	// Values rendered by custom marshalers can be anything
+	if implements(t, osqueryMarshalerType) || implements(t, textMarshalerType) || implements(t, valuerType) {
+		return table.ColumnTypeText
+	}
+
+	// Integers rendered in another base than 10 or as characters, and bools rendered as text,
+	// are strings for osquery
+	if base, _, err := integerBase(tag); err == nil && base != 10 && isIntegerKind(t.Kind()) {
+		return table.ColumnTypeText
+	}
+	if t.Kind() == reflect.Int32 && hasTagOption(tag, "char") {
+		return table.ColumnTypeText
+	}
+	if _, custom := lookupTagOption(tag, "bool"); t.Kind() == reflect.Bool && (custom || hasTagOption(tag, "booltext")) {
+		return table.ColumnTypeText
+	}
+
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return table.ColumnTypeInteger
+	case reflect.Int64, reflect.Uint64:
+		return table.ColumnTypeBigInt
+	case reflect.Float32, reflect.Float64:
+		return table.ColumnTypeDouble
+	default:
+		// we default to table.ColumnTypeText for unsupported types
+		return table.ColumnTypeText
+	}
+}
+
+// fieldKey resolves the column name of a struct field from its key tags, "osquery" unless set
+// by Options.TagPriority or Options.UseECSKeys, falling back to the field name when none of
+// the tags sets a name, or to the "json" tag first with EncodingFlagFallbackJSONTag. It
+// returns false for unexported fields, fields tagged with "-" and fields of skipped types,
+// which must be skipped. A "-" name skips the field even when followed by options, e.g.
+// `osquery:"-,omitempty"`, except for the exact tag `osquery:"-,"`, which names the column "-"
+// as in encoding/json. Embedded structs of unexported types are not skipped, as their
+// exported fields are accessible. With EncodingFlagSnakeCaseKeys, field names are converted
+// to snake_case, but the names set in tags are used as is.
+func fieldKey(field reflect.StructField, flags EncodingFlag, keyTags string) (string, bool) {
+	if !field.IsExported() && !(field.Anonymous && isNestedStruct(field.Type)) {
+		return "", false
+	}
+	if isSkippedType(field.Type, flags) {
+		return "", false
+	}
+
+	key, skip := tagName(field, flags, keyTags)
+	if skip {
+		return "", false
+	}
+	if key == "" {
+		key = field.Name
+		if flags.has(EncodingFlagSnakeCaseKeys) {
+			key = toSnakeCase(key)
+		}
+	}
+	return key, true
+}
+
+// isSkippedType reports whether values of type t, or of pointers to it, contribute no key to
+// the row: channels and functions, which have no meaningful representation, and complex
+// numbers with EncodingFlagSkipComplex. Types with a custom marshaler are never skipped.
+func isSkippedType(t reflect.Type, flags EncodingFlag) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func:
+	case reflect.Complex64, reflect.Complex128:
+		if !flags.has(EncodingFlagSkipComplex) {
+			return false
+		}
+	default:
+		return false
+	}
+	return !implements(t, osqueryMarshalerType) && !implements(t, textMarshalerType) &&
+		!implements(t, valuerType) && !implements(t, stringerType)
+}
+
+// tagName returns the name set for the field by the first of the comma-separated keyTags that
+// sets one, then by the "json" tag with EncodingFlagFallbackJSONTag, or "" if none does. It
+// reports whether the field must be skipped instead, as documented in fieldKey. The "json"
+// tag follows the rules of encoding/json, where only `json:"-"` skips the field.
+func tagName(field reflect.StructField, flags EncodingFlag, keyTags string) (string, bool) {
+	for keyTags != "" {
+		var tag string
+		tag, keyTags, _ = strings.Cut(keyTags, ",")
+		value := field.Tag.Get(tag)
+		if value == "-," {
+			return "-", false
+		}
+		if name, _ := parseTag(value); name != "" {
+			return name, name == "-"
+		}
+	}
+	if flags.has(EncodingFlagFallbackJSONTag) {
+		value := field.Tag.Get("json")
+		name, _ := parseTag(value)
+		return name, value == "-"
+	}
+	return "", false
+}
+
+// toSnakeCase converts a CamelCase name to snake_case. Runs of upper case letters are
+// handled as acronyms, e.g. "HTTPStatus" becomes "http_status" and "ProcessID" becomes
+// "process_id", and so are the acronyms followed by a version, e.g. "IPv4Addr" becomes
+// "ipv4_addr".
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	b.Grow(len(name) + 4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextWord := i+1 < len(runes) && unicode.IsLower(runes[i+1]) && !isVersionSuffix(runes[i+1:])
+				// Word boundaries are before an upper case letter following a lower case
+				// letter or a digit, and before the last letter of an acronym followed by
+				// a lower case word
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextWord) {
+					b.WriteByte('_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isVersionSuffix reports whether runes start with a single lower case letter followed by a
+// digit, like the "v4" of "IPv4", which belongs to the acronym before it.
+func isVersionSuffix(runes []rune) bool {
+	return len(runes) >= 2 && unicode.IsLower(runes[0]) && unicode.IsDigit(runes[1])
+}
+
+// isPromoted reports whether the fields of the struct field are promoted to its parent, which
+// is the case for embedded structs without a name in their key tag, as for fieldKey. Like in
+// Go, the exported fields of embedded unexported struct types are promoted too.
+func isPromoted(field reflect.StructField, flags EncodingFlag, keyTags string) bool {
+	if !field.Anonymous {
+		return false
+	}
+	if name, skip := tagName(field, flags, keyTags); name != "" || skip {
+		return false
+	}
+	return isNestedStruct(field.Type)
+}
+
+// apply applies the options to the converted value of a field, using the encoder options for
+// the defaults. text tells whether the value may be truncated. It returns false when the
+// field must be left out of the row because of the "omitempty" option, which takes
+// precedence over the "default" option.
+func (o fieldOptions) apply(opts *Options, fieldValue reflect.Value, value string, text textMode) (string, bool) {
+	maxLen := o.maxLen
+	if maxLen == 0 {
+		maxLen = opts.DefaultMaxLen
+	}
+	if maxLen > 0 && text.truncates(fieldValue) {
+		value = truncateString(value, maxLen, o.ellipsis)
+	}
+	// Nil pointers are rendered as Options.NilString, which is not a secret, but a missing value
+	isNil := isNilPointer(fieldValue)
+	if o.redact && value != "" && !isNil {
+		value = opts.redactMask()
+	}
+	if o.omitEmpty && (value == "" || fieldValue.IsZero()) {
+		return "", false
+	}
+	if (value == "" || isNil) && o.hasDefault {
+		return o.defaultValue, true
+	}
+	return value, true
+}
+
+// textMode tells whether the values of a field are text that may be truncated, as resolved
+// once from the type of the field by typeTextMode.
+type textMode int8
+
+const (
+	// textNever values are numbers, bools or times
+	textNever textMode = iota
+	// textAlways values are text, as reported by isTextType
+	textAlways
+	// textDynamic values are interfaces, whose dynamic value is checked for each value
+	textDynamic
+)
+
+// typeTextMode returns the textMode of the values of type t.
+func typeTextMode(t reflect.Type) textMode {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Interface:
+		return textDynamic
+	case isTextType(t):
+		return textAlways
+	default:
+		return textNever
+	}
+}
+
+// truncates reports whether the value v, of a type of this mode, may be truncated.
+func (m textMode) truncates(v reflect.Value) bool {
+	switch m {
+	case textAlways:
+		return true
+	case textDynamic:
+		v, ok := derefValue(v)
+		for ok && v.Kind() == reflect.Interface && !v.IsNil() {
+			v, ok = derefValue(v.Elem())
+		}
+		return ok && v.Kind() != reflect.Interface && isTextType(v.Type())
+	default:
+		return false
+	}
+}
+
+// isTextType reports whether the values of type t are converted to text that may be
+// truncated: strings, the output of marshalers, Stringers and enums, and slices, maps and
+// structs. Numbers, bools and times are never truncated, whatever their format, as a prefix
+// of them is a different value.
+func isTextType(t reflect.Type) bool {
+	if isSQLNull(t) {
+		return typeTextMode(t.Field(0).Type) == textAlways
+	}
+	switch t {
+	case timeType, durationType, bigIntType, bigFloatType, jsonNumberType:
+		return false
+	}
+	if _, ok := lookupEnum(t); ok || hasConverter(t) {
+		return true
+	}
+	if implements(t, osqueryMarshalerType) || implements(t, textMarshalerType) || implements(t, valuerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		return true
+	default:
+		return false
+	}
+}
+
+// isPlainType reports whether the values of type t are converted by convertKind alone: the
+// builtin scalar types, and the types defined from them without methods changing how they
+// are rendered, a registered enum or a converter.
+func isPlainType(t reflect.Type) bool {
+	if !isScalarKind(t.Kind()) || t == durationType || t == jsonNumberType {
+		return false
+	}
+	if _, ok := lookupEnum(t); ok || hasConverter(t) {
+		return false
+	}
+	return !implements(t, osqueryMarshalerType) && !implements(t, textMarshalerType) && !implements(t, valuerType)
+}
+
+// isNilPointer reports whether v is a nil pointer, or a pointer to one, e.g. a **int pointing
+// to a nil *int.
+func isNilPointer(v reflect.Value) bool {
+	if v.Kind() != reflect.Ptr {
+		return false
+	}
+	_, ok := derefValue(v)
+	return !ok
+}
+
+// isFlattenedMap reports whether t, after dereferencing pointers, is a map with keys supported
+// by formatMapKey whose entries should be marshaled under dotted keys rather than as a single
+// value. Maps are kept as a single value when EncodingFlagJSONComplex is set.
+func isFlattenedMap(t reflect.Type, flags EncodingFlag) bool {
+	if flags.has(EncodingFlagJSONComplex) {
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Map && isMapKeyType(t.Key()) &&
+		!implements(t, osqueryMarshalerType) && !implements(t, textMarshalerType) &&
+		!implements(t, stringerType)
+}
+
+// mapEntry is the value of a map entry along with the formatted name of its key. The values
+// are read with MapRange, as MapIndex cannot look up the entries of NaN keys.
+type mapEntry struct {
+	name  string
+	value reflect.Value
+}
+
+// isMapKeyType reports whether the map keys of type t can be formatted by formatMapKey.
+func isMapKeyType(t reflect.Type) bool {
+	return isScalarKind(t.Kind()) || implements(t, osqueryMarshalerType) || implements(t, textMarshalerType) ||
+		implements(t, stringerType)
+}
+
+// formatMapKey returns the name of the map key k. Strings are used as is, and the other keys
+// are formatted with their MarshalOsquery, MarshalText or String method, or as values
+// otherwise, except that zero numbers and false are rendered as "0" rather than "".
+func (o *Options) formatMapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if m, ok := asInterface[OsqueryMarshaler](k); ok {
+		return m.MarshalOsquery()
+	}
+	if m, ok := asInterface[encoding.TextMarshaler](k); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	if s, ok := asInterface[fmt.Stringer](k); ok {
+		return s.String(), nil
+	}
+	return o.convertValueToString(k, EncodingFlagUseNumbersZeroValues, &untaggedField.value)
+}
+
+// isNestedStruct reports whether t, after dereferencing pointers, is a struct whose fields
+// should be marshaled individually rather than as a single scalar value like time.Time.
+func isNestedStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && !isSQLNull(t) && !hasConverter(t) &&
+		!implements(t, osqueryMarshalerType) && !implements(t, osqueryUnmarshalerType) &&
+		!implements(t, textMarshalerType) && !implements(t, textUnmarshalerType) &&
+		!implements(t, valuerType) && !implements(t, stringerType)
+}
+
+// implements reports whether t or a pointer to t implements the interface type iface.
+func implements(t reflect.Type, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+// asInterface returns v as an implementation of the interface T. Both v and a pointer to v
+// are checked, so that methods with pointer receivers are also found for values that are
+// not addressable, like struct fields of a value passed by copy.
+func asInterface[T any](v reflect.Value) (T, bool) {
+	var zero T
+	if !v.IsValid() || !v.CanInterface() {
+		return zero, false
+	}
+
+	// Check the type first to avoid boxing values that don't implement T
+	byValue, byPointer := typeImplements(v.Type(), reflect.TypeFor[T]())
+	switch {
+	case byValue:
+		i, ok := v.Interface().(T)
+		return i, ok
+	case !byPointer:
+		return zero, false
+	}
+
+	p := reflect.New(v.Type())
+	if v.CanAddr() {
+		p = v.Addr()
+	} else {
+		p.Elem().Set(v)
+	}
+	i, ok := p.Interface().(T)
+	return i, ok
+}
+
+// implementsCacheKey identifies the result of typeImplements.
+type implementsCacheKey struct {
+	t     reflect.Type
+	iface reflect.Type
+}
+
+// implementsCache maps an implementsCacheKey to a [2]bool result of typeImplements.
+var implementsCache sync.Map
+
+// typeImplements reports whether t and a pointer to t implement the interface type iface.
+// The result is cached, as it is checked for every marshaled value.
+func typeImplements(t reflect.Type, iface reflect.Type) (byValue, byPointer bool) {
+	cacheKey := implementsCacheKey{t: t, iface: iface}
+	if result, ok := implementsCache.Load(cacheKey); ok {
+		r := result.([2]bool)
+		return r[0], r[1]
+	}
+
+	byValue = t.Implements(iface)
+	byPointer = byValue || reflect.PointerTo(t).Implements(iface)
+	implementsCache.Store(cacheKey, [2]bool{byValue, byPointer})
+	return byValue, byPointer
+}
+
+// addrOf returns a pointer to v, or to a copy of v if it is not addressable.
+func addrOf(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
+
+// derefValue follows pointers until it reaches a non-pointer value. It returns false if a
+// nil pointer is found along the way.
+func derefValue(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// convertValueToString converts a reflect.Value to a string, handling pointers,
+// booleans, integers, unsigned integers, floats, time.Time, and unsupported types.
+// It also handles the EncodingFlagUseNumbersZeroValues flag and the tag options of the
+// value, resolved by parseValueOptions.
+func (o *Options) convertValueToString(fieldValue reflect.Value, flag EncodingFlag, valueOpts *valueOptions) (string, error) {
+	// Handle pointers first, recursing through every level, e.g. **int, until a nil or a value
+	if fieldValue.Kind() == reflect.Ptr {
+		if fieldValue.IsNil() {
+			return o.NilString, nil
+		}
+		return o.convertValueToString(fieldValue.Elem(), flag, valueOpts)
+	}
+
+	// Interfaces, like the elements of []any, are converted as their dynamic value
+	if fieldValue.Kind() == reflect.Interface {
+		if fieldValue.IsNil() {
+			return "", nil
+		}
+		return o.convertValueToString(fieldValue.Elem(), flag, valueOpts)
+	}
+
+	// Custom marshalers take precedence, followed by the types with dedicated handling,
+	// encoding.TextMarshaler, and finally the conversions based on the kind
+	if m, ok := asInterface[OsqueryMarshaler](fieldValue); ok {
+		return m.MarshalOsquery()
+	}
+
+	// Converters are registered for the types of other packages, to override their methods
+	if convert, ok := lookupConverter(fieldValue.Type()); ok {
+		return convert(fieldValue)
+	}
+
+	// Nullable database/sql types are rendered as their value when valid
+	if isSQLNull(fieldValue.Type()) {
+		value, valid := sqlNullValue(fieldValue)
+		if !valid {
+			return "", nil
+		}
+		return o.convertValueToString(value, flag, valueOpts)
+	}
+
+	// Registered enums are rendered as names, values without one use the rules below
+	if enum, ok := lookupEnum(fieldValue.Type()); ok {
+		if name, ok := enum.name(fieldValue); ok {
+			return name, nil
+		}
+	}
+
+	switch fieldValue.Type() {
+	case timeType:
+		// time.Time implements encoding.TextMarshaler, but its format is set by the tag and flags
+		return formatTimeWithLayout(fieldValue, flag, valueOpts, o.timeLayout())
+	case durationType:
+		// time.Duration is an int64, but shouldn't be rendered as raw nanoseconds
+		return formatDuration(fieldValue, flag, valueOpts)
+	case rawMessageType:
+		// json.RawMessage is a []byte, but already holds serialized JSON
+		return string(fieldValue.Bytes()), nil
+	case jsonNumberType:
+		// json.Number is a string holding the exact text of the number, and isn't trimmed
+		return fieldValue.String(), nil
+	case ipType:
+		// net.IP is a []byte, and its MarshalText method fails for invalid addresses
+		if fieldValue.Len() == 0 {
+			return "", nil
+		}
+		return net.IP(fieldValue.Bytes()).String(), nil
+	case hardwareAddrType:
+		if fieldValue.Len() == 0 {
+			return "", nil
+		}
+		return net.HardwareAddr(fieldValue.Bytes()).String(), nil
+	case bigIntType:
+		// *big.Int implements encoding.TextMarshaler, but zero values follow the number rules
+		n := addrOf(fieldValue).Interface().(*big.Int)
+		if !flag.has(EncodingFlagUseNumbersZeroValues) && n.Sign() == 0 {
+			return "", nil
+		}
+		return n.Text(10), nil
+	case bigFloatType:
+		// *big.Float implements encoding.TextMarshaler, but may use an exponent
+		f := addrOf(fieldValue).Interface().(*big.Float)
+		if !flag.has(EncodingFlagUseNumbersZeroValues) && f.Sign() == 0 {
+			return "", nil
+		}
+		if flag.has(EncodingFlagFiniteFloatsOnly) && f.IsInf() {
+			return "", nil
+		}
+		if valueOpts.precErr != nil {
+			return "", valueOpts.precErr
+		}
+		return f.Text('f', valueOpts.prec), nil
+	case ipNetType:
+		ipNet := fieldValue.Interface().(net.IPNet)
+		if len(ipNet.IP) == 0 {
+			return "", nil
+		}
+		return ipNet.String(), nil
+	}
+
+	if m, ok := asInterface[encoding.TextMarshaler](fieldValue); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return trimString(string(text), flag), nil
+	}
+
+	// driver.Valuer values, like custom nullable or decimal types, are rendered as the
+	// driver.Value they return
+	if v, ok := asInterface[driver.Valuer](fieldValue); ok {
+		value, err := v.Value()
+		if err != nil {
+			return "", err
+		}
+		if value == nil {
+			return "", nil
+		}
+		return o.convertValueToString(reflect.ValueOf(value), flag, valueOpts)
+	}
+
+	// fmt.Stringer takes precedence over the conversions of composite kinds, like joining slices
+	if !isScalarKind(fieldValue.Kind()) {
+		if s, ok := asInterface[fmt.Stringer](fieldValue); ok {
+			return trimString(s.String(), flag), nil
+		}
+	}
+
+	if isByteSequence(fieldValue.Type()) {
+		if valueOpts.bytesAsNums {
+			return o.joinSliceValues(fieldValue, flag, valueOpts)
+		}
+		return formatBytes(fieldValue, flag), nil
+	}
+
+	if flag.has(EncodingFlagJSONComplex) {
+		switch fieldValue.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return marshalJSONValue(fieldValue)
+		}
+	}
+
+	return o.convertKind(fieldValue, flag, valueOpts)
+}
+
+// convertKind converts fieldValue into a string based on its kind, once the marshalers and
+// the types with dedicated handling have been ruled out by convertValueToString.
+func (o *Options) convertKind(fieldValue reflect.Value, flag EncodingFlag, valueOpts *valueOptions) (string, error) {
+	switch fieldValue.Kind() {
+	case reflect.String:
+		return trimString(fieldValue.String(), flag), nil
+
+	case reflect.Bool:
+		if valueOpts.boolErr != nil {
+			return "", valueOpts.boolErr
+		}
+		if valueOpts.customBool {
+			if fieldValue.Bool() {
+				return valueOpts.trueValue, nil
+			}
+			return valueOpts.falseValue, nil
+		}
+		if flag.has(EncodingFlagBoolAsTrueFalse) {
+			return strconv.FormatBool(fieldValue.Bool()), nil
+		}
+		// osquery often expects boolean values as "0" or "1"
+		if fieldValue.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// osquery often expects empty string for 0 values unless necessary
+		val := fieldValue.Int()
+		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
+			return "", nil
+		}
+		if isCharValue(fieldValue, valueOpts) {
+			return string(rune(val)), nil
+		}
+		if valueOpts.baseErr != nil {
+			return "", valueOpts.baseErr
+		}
+		if val < 0 {
+			// The prefix goes after the sign, e.g. "-0x1f"
+			return "-" + valueOpts.prefix + strconv.FormatInt(val, valueOpts.base)[1:], nil
+		}
+		return valueOpts.prefix + strconv.FormatInt(val, valueOpts.base), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val := fieldValue.Uint()
+		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
+			return "", nil
+		}
+		if valueOpts.baseErr != nil {
+			return "", valueOpts.baseErr
+		}
+		return valueOpts.prefix + strconv.FormatUint(val, valueOpts.base), nil
+
+	case reflect.Float32, reflect.Float64:
+		val := fieldValue.Float()
+		if !flag.has(EncodingFlagUseNumbersZeroValues) && val == 0 {
+			return "", nil
+		}
+		if flag.has(EncodingFlagFiniteFloatsOnly) && (math.IsNaN(val) || math.IsInf(val, 0)) {
+			return "", nil
+		}
+		if valueOpts.precErr != nil {
+			return "", valueOpts.precErr
+		}
+		return strconv.FormatFloat(val, 'f', valueOpts.prec, fieldValue.Type().Bits()), nil
+
+	case reflect.Slice, reflect.Array:
+		return o.joinSliceValues(fieldValue, flag, valueOpts)
+
+	case reflect.Struct:
+		return "", fmt.Errorf("unsupported struct type: %s", fieldValue.Type())
+
+	case reflect.Complex64, reflect.Complex128:
+		return "", fmt.Errorf("unsupported complex type: %s", fieldValue.Type())
+
+	// Default: use Sprintf for unsupported types
+	default:
+		if flag.has(EncodingFlagErrorOnUnsupported) {
+			return "", fmt.Errorf("unsupported %s type: %s", fieldValue.Kind(), fieldValue.Type())
+		}
+		if fieldValue.CanInterface() {
+			return fmt.Sprintf("%v", fieldValue.Interface()), nil
+		}
+		return "", fmt.Errorf("unsupported type (%s)", fieldValue.Kind())
+	}
+}
+
+// fieldFlags returns the flags used for a field, adding the flags enabled by its tag options.
+func fieldFlags(flag EncodingFlag, tag *reflect.StructTag) EncodingFlag {
+	if hasTagOption(tag, "string") {
+		flag |= EncodingFlagUseNumbersZeroValues
+	}
+	if hasTagOption(tag, "booltext") {
+		flag |= EncodingFlagBoolAsTrueFalse
+	}
+	return flag
+}
+
+// trimString removes the leading and trailing white space of s when EncodingFlagTrimSpace is
+// set.
+func trimString(s string, flag EncodingFlag) string {
+	if flag.has(EncodingFlagTrimSpace) {
+		return strings.TrimSpace(s)
+	}
+	return s
+}
+
+// isScalarKind reports whether kind is a string, bool or number kind.
+func isScalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// joinSliceValues converts the elements of a slice or array and joins them with the separator
+// set by the "sep" option of their field, or by the SliceSep option. With
+// EncodingFlagJSONSlices, the elements are rendered as a JSON array instead, as returned by
+// jsonSliceElement. Numeric zero values are always rendered as "0" so that elements are
+// never empty.
+func (o *Options) joinSliceValues(fieldValue reflect.Value, flag EncodingFlag, valueOpts *valueOptions) (string, error) {
+	if fieldValue.Len() == 0 {
+		return "", nil
+	}
+
+	elems := make([]string, fieldValue.Len())
+	for i := range elems {
+		elem, err := o.convertValueToString(fieldValue.Index(i), flag|EncodingFlagUseNumbersZeroValues, valueOpts)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert element %d: %w", i, err)
+		}
+		elems[i] = elem
+	}
+
+	if flag.has(EncodingFlagJSONSlices) {
+		values := make([]any, len(elems))
+		for i, elem := range elems {
+			values[i] = jsonSliceElement(fieldValue.Index(i), elem)
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	return strings.Join(elems, o.sliceSeparator(valueOpts)), nil
+}
+
+// jsonSliceElement returns the JSON value of the slice element v, converted to text: a
+// json.Number for the numbers of builtin types and durations rendered as decimal numbers, a
+// bool for the bools rendered as "1" and "0", or as "true" and "false", and text otherwise.
+func jsonSliceElement(v reflect.Value, text string) any {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return text
+		}
+		v = v.Elem()
+	}
+
+	switch t := v.Type(); {
+	case t.Kind() == reflect.Bool && isPlainType(t):
+		switch text {
+		case "1", "true":
+			return true
+		case "0", "false":
+			return false
+		}
+	case isNumberKind(t.Kind()) && (isPlainType(t) || t == durationType) && isJSONNumber(text):
+		return json.Number(text)
+	}
+	return text
+}
+
+// isByteSequence reports whether t is a slice or array of bytes, like []byte or [16]byte.
+func isByteSequence(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() == reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// formatBytes renders a byte slice or array as standard base64, or as lowercase hex or verbatim
+// when EncodingFlagBytesHex or EncodingFlagBytesRaw is set.
+func formatBytes(fieldValue reflect.Value, flag EncodingFlag) string {
+	// Arrays may not be addressable, copy them to a slice
+	b := make([]byte, fieldValue.Len())
+	reflect.Copy(reflect.ValueOf(b), fieldValue)
+
+	switch {
+	case flag.has(EncodingFlagBytesRaw):
+		return string(b)
+	case flag.has(EncodingFlagBytesHex):
+		return hex.EncodeToString(b)
+	default:
+		return base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+// marshalJSONValue renders a value with encoding/json. Nil slices and maps are rendered as
+// empty strings, like nil pointers.
+func marshalJSONValue(fieldValue reflect.Value) (string, error) {
+	switch fieldValue.Kind() {
+	case reflect.Slice, reflect.Map:
+		if fieldValue.IsNil() {
+			return "", nil
+		}
+	case reflect.Array:
+		// Zero-length arrays hold nothing, like nil slices
+		if fieldValue.Len() == 0 {
+			return "", nil
+		}
+	}
+	if !fieldValue.CanInterface() {
+		return "", fmt.Errorf("unsupported type (%s)", fieldValue.Kind())
+	}
+
+	b, err := json.Marshal(fieldValue.Interface())
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+// sliceSeparator returns the separator set by the "sep" option of the field, or by the
+// SliceSep option, in this order. It defaults to DefaultSliceSeparator.
+func (o *Options) sliceSeparator(valueOpts *valueOptions) string {
+	if valueOpts.hasSep {
+		return valueOpts.sep
+	}
+	if o.SliceSep != "" {
+		return o.SliceSep
+	}
+	return DefaultSliceSeparator
+}
+
+// formatTimeWithTagFormat formats a time.Time value with the specified format
+// and timezone conversion if specified in the tag.
+func formatTimeWithTagFormat(fieldValue reflect.Value, flag EncodingFlag, tag *reflect.StructTag) (string, error) {
+	valueOpts := parseValueOptions(tag)
+	return formatTimeWithLayout(fieldValue, flag, &valueOpts, DefaultTimeFormat)
+}
+
+// formatTimeWithLayout is like formatTimeWithTagFormat, with the tag options resolved by
+// parseValueOptions, and uses defaultLayout when neither the tag nor the flags set a format.
+func formatTimeWithLayout(fieldValue reflect.Value, flag EncodingFlag, valueOpts *valueOptions, defaultLayout string) (string, error) {
+	// Check if the value is zero and the flag is not set to use numbers zero values
+	if !flag.has(EncodingFlagUseNumbersZeroValues) && fieldValue.IsZero() {
+		return "", nil
+	}
+
+	t, ok := fieldValue.Interface().(time.Time)
+	if !ok {
+		return "", fmt.Errorf("expected time.Time value but got %v", fieldValue.Type())
+	}
+
+	// Handle timezone conversion if specified in tag, otherwise convert to the default
+	// timezone so that values are rendered consistently regardless of their location
+	if valueOpts.locErr != nil {
+		return "", valueOpts.locErr
+	}
+	if valueOpts.timeErr != nil {
+		return "", valueOpts.timeErr
+	}
+
+	format := valueOpts.timeFormat
+	if !valueOpts.hasTimeFormat {
+		format = flagTimeFormat(flag, defaultLayout)
+	}
+	return format.format(t.In(valueOpts.loc)), nil
+}
+
+// timeLayouts maps the values of the "format" tag to their time layout.
+var timeLayouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc822":      time.RFC822,
+	"rfc822z":     time.RFC822Z,
+	"rfc850":      time.RFC850,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"kitchen":     time.Stamp,
+	"stampmilli":  time.StampMilli,
+	"stampmicro":  time.StampMicro,
+	"stampnano":   time.StampNano,
+}
+
+// timeFormat describes how time.Time values are rendered: either as an integer number
+// of unixUnit since the Unix epoch, or with a time.Format layout.
+type timeFormat struct {
+	unixUnit time.Duration
+	layout   string
+}
+
+// resolveTimeFormat returns the time format set by the "layout" option of the tag or by its
+// "format" tag, in this order. If none is set, the format is chosen based on the flags, and
+// defaults to defaultLayout.
+func resolveTimeFormat(flag EncodingFlag, tag *reflect.StructTag, defaultLayout string) (timeFormat, error) {
+	if format, ok, err := tagTimeFormat(tag); ok || err != nil {
+		return format, err
+	}
+	return flagTimeFormat(flag, defaultLayout), nil
+}
+
+// tagTimeFormat returns the time format set by the "layout" option of the tag or by its
+// "format" tag, in this order, and whether one is set.
+func tagTimeFormat(tag *reflect.StructTag) (timeFormat, bool, error) {
+	if layout, ok := lookupTagOption(tag, "layout"); ok {
+		return timeFormat{layout: layout}, true, nil
+	}
+
+	if tag != nil {
+		if name, ok := tag.Lookup("format"); ok {
+			switch strings.ToLower(name) {
+			case "unix":
+				return timeFormat{unixUnit: time.Second}, true, nil
+			case "unixnano":
+				return timeFormat{unixUnit: time.Nanosecond}, true, nil
+			case "unixmilli":
+				return timeFormat{unixUnit: time.Millisecond}, true, nil
+			case "unixmicro":
+				return timeFormat{unixUnit: time.Microsecond}, true, nil
+			}
+			if layout, ok := timeLayouts[strings.ToLower(name)]; ok {
+				return timeFormat{layout: layout}, true, nil
+			}
+			return timeFormat{}, false, fmt.Errorf("unsupported time format: %s", name)
+		}
+	}
+	return timeFormat{}, false, nil
+}
+
+// flagTimeFormat returns the time format chosen by the flags, defaulting to defaultLayout.
+func flagTimeFormat(flag EncodingFlag, defaultLayout string) timeFormat {
+	switch {
+	case flag.has(EncodingFlagTimeAsUnixMilli):
+		return timeFormat{unixUnit: time.Millisecond}
+	case flag.has(EncodingFlagTimeAsUnix):
+		return timeFormat{unixUnit: time.Second}
+	}
+	return timeFormat{layout: defaultLayout}
+}
+
+// format renders t according to the time format.
+func (f timeFormat) format(t time.Time) string {
+	switch f.unixUnit {
+	case time.Second:
+		return strconv.FormatInt(t.Unix(), 10)
+	case time.Millisecond:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case time.Microsecond:
+		return strconv.FormatInt(t.UnixMicro(), 10)
+	case time.Nanosecond:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return t.Format(f.layout)
+}
+
+// parse is the reverse of format, parsing s in the given location.
+func (f timeFormat) parse(s string, loc *time.Location) (time.Time, error) {
+	if f.unixUnit == 0 {
+		t, err := time.ParseInLocation(f.layout, s, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.In(loc), nil
+	}
+
+	val, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var t time.Time
+	switch f.unixUnit {
+	case time.Second:
+		t = time.Unix(val, 0)
+	case time.Millisecond:
+		t = time.UnixMilli(val)
+	case time.Microsecond:
+		t = time.UnixMicro(val)
+	default:
+		t = time.Unix(0, val)
+	}
+	return t.In(loc), nil
+}
+
+// timeLocation returns the location set by the "tz" tag, or the default timezone.
+func timeLocation(tag *reflect.StructTag) (*time.Location, error) {
+	tz := DefaultTimezone
+	if tag != nil {
+		if tagTimezone, ok := tag.Lookup("tz"); ok {
+			tz = tagTimezone
+		}
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %w", tz, err)
+	}
+	return loc, nil
+}
+
+// isCharValue reports whether the integer v is rendered as a character by the "char" option of
+// its field, which applies to int32 values holding a valid code point other than zero.
+func isCharValue(v reflect.Value, valueOpts *valueOptions) bool {
+	if v.Kind() != reflect.Int32 || !valueOpts.char {
+		return false
+	}
+	r := rune(v.Int())
+	return r != 0 && utf8.ValidRune(r)
+}
+
+// integerBases maps the bases supported by the "base" option to the prefix added by the
+// "prefix" option.
+var integerBases = map[int]string{
+	2:  "0b",
+	8:  "0o",
+	10: "",
+	16: "0x",
+}
+
+// integerBase returns the base set by the "base" option of the tag, defaulting to 10, and the
+// prefix of the base if the "prefix" option is set.
+func integerBase(tag *reflect.StructTag) (int, string, error) {
+	value, ok := lookupTagOption(tag, "base")
+	if !ok {
+		return 10, "", nil
+	}
+	base, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, "", fmt.Errorf("unsupported integer base: %s", value)
+	}
+	prefix, ok := integerBases[base]
+	if !ok {
+		return 0, "", fmt.Errorf("unsupported integer base: %s", value)
+	}
+	if !hasTagOption(tag, "prefix") {
+		prefix = ""
+	}
+	return base, prefix, nil
+}
+
+// boolStrings returns the strings rendering true and false values set by the "bool" option
+// of the tag, e.g. "bool=on:off", and whether the option is set.
+func boolStrings(tag *reflect.StructTag) (string, string, bool, error) {
+	value, ok := lookupTagOption(tag, "bool")
+	if !ok {
+		return "", "", false, nil
+	}
+	trueValue, falseValue, ok := strings.Cut(value, ":")
+	if !ok || trueValue == "" || falseValue == "" || trueValue == falseValue {
+		return "", "", false, fmt.Errorf("invalid bool strings: %s, must be two different strings separated by a colon, e.g. on:off", value)
+	}
+	return trueValue, falseValue, true, nil
+}
+
+// floatPrecision returns the number of decimals set by the "prec" option of the tag. It
+// defaults to -1, which formats the smallest number of digits needed to represent the value.
+func floatPrecision(tag *reflect.StructTag) (int, error) {
+	value, ok := lookupTagOption(tag, "prec")
+	if !ok {
+		return -1, nil
+	}
+	prec, err := strconv.Atoi(value)
+	if err != nil || prec < 0 {
+		return 0, fmt.Errorf("invalid float precision: %s", value)
+	}
+	return prec, nil
+}
+
+// maxLength returns the maximum length set by the "max" option of the tag, or 0 when unset.
+func maxLength(tag *reflect.StructTag) (int, error) {
+	value, ok := lookupTagOption(tag, "max")
+	if !ok {
+		return 0, nil
+	}
+	maxLen, err := strconv.Atoi(value)
+	if err != nil || maxLen <= 0 {
+		return 0, fmt.Errorf("invalid maximum length: %s", value)
+	}
+	return maxLen, nil
+}
+
+// ellipsis is appended to the values truncated by the "max" option with the "ellipsis" option.
+const ellipsis = "…"
+
+// truncateString shortens s to at most maxLen bytes, without splitting UTF-8 characters, and
+// ends it with an ellipsis when requested and there is room for it. A maxLen of 0 or less
+// disables truncation.
+func truncateString(s string, maxLen int, withEllipsis bool) string {
+	if maxLen <= 0 || len(s) <= maxLen {
+		return s
+	}
+	suffix := ""
+	if withEllipsis && maxLen >= len(ellipsis) {
+		suffix = ellipsis
+		maxLen -= len(ellipsis)
+	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen] + suffix
+}
+
+// durationUnit returns the unit set by the "duration" option of the tag, defaulting to seconds.
+func durationUnit(tag *reflect.StructTag) (time.Duration, error) {
+	unit, ok := lookupTagOption(tag, "duration")
+	if !ok {
+		return time.Second, nil
+	}
+
+	switch strings.ToLower(unit) {
+	case "s":
+		return time.Second, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ns":
+		return time.Nanosecond, nil
+	default:
+		return 0, fmt.Errorf("unsupported duration unit: %s", unit)
+	}
+}
+
+// formatDuration formats a time.Duration value as an integer number of the unit set by the
+// "duration" option of its field, or of seconds if no unit is set.
+func formatDuration(fieldValue reflect.Value, flag EncodingFlag, valueOpts *valueOptions) (string, error) {
+	if valueOpts.durationErr != nil {
+		return "", valueOpts.durationErr
+	}
+
+	d := time.Duration(fieldValue.Int())
+	if !flag.has(EncodingFlagUseNumbersZeroValues) && d == 0 {
+		return "", nil
+	}
+	return strconv.FormatInt(int64(d/valueOpts.durationUnit), 10), nil
 }
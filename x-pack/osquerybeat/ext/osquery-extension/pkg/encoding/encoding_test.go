@@ -5,9 +5,18 @@
 package encoding
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/osquery/osquery-go/plugin/table"
 )
@@ -19,6 +28,8 @@ func TestEncodingFlagHas(t *testing.T) {
 		expected bool
 	}{
 		{EncodingFlagUseNumbersZeroValues, EncodingFlagUseNumbersZeroValues, true},
+		{EncodingFlagUseNumbersZeroValues, EncodingFlagEmptyStringAsError, false},
+		{EncodingFlagUseNumbersZeroValues | EncodingFlagEmptyStringAsError, EncodingFlagEmptyStringAsError, true},
 	}
 
 	for _, test := range tests {
@@ -37,6 +48,115 @@ func intPtr(i int) *int {
 	return &i
 }
 
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+type testProcess struct {
+	PID     int       `osquery:"pid"`
+	Name    string    `osquery:"name"`
+	Started time.Time `osquery:"started"`
+}
+
+// testHost is embedded in test structs to exercise field promotion.
+type testHost struct {
+	Hostname string `osquery:"hostname"`
+	OS       string `osquery:"os"`
+}
+
+// testAgentInfo is a struct with nested and custom marshaled fields, used as the element of
+// slices.
+type testAgentInfo struct {
+	Hostname string      `osquery:"hostname"`
+	Version  testVersion `osquery:"version"`
+	Process  testProcess `osquery:"process"`
+	Secret   string      `osquery:"secret,redact"`
+	Skipped  string      `osquery:"-"`
+}
+
+// testAgent is an unexported embedded struct type, whose exported fields are still promoted.
+type testAgent struct {
+	AgentID string `osquery:"agent_id"`
+}
+
+// testNode is a recursive type, which cannot be described by a fixed set of columns.
+type testNode struct {
+	Value int       `osquery:"value"`
+	Next  *testNode `osquery:"next"`
+}
+
+// testVersion implements OsqueryMarshaler with a value receiver.
+type testVersion struct {
+	Major int
+	Minor int
+}
+
+func (v testVersion) MarshalOsquery() (string, error) {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor), nil
+}
+
+// testIPRange implements OsqueryMarshaler with a pointer receiver.
+type testIPRange struct {
+	From string
+	To   string
+}
+
+func (r *testIPRange) MarshalOsquery() (string, error) {
+	if r.From == "" || r.To == "" {
+		return "", errors.New("incomplete range")
+	}
+	return r.From + "-" + r.To, nil
+}
+
+// testLevel is a string type implementing encoding.TextMarshaler.
+type testLevel string
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	if l == "" {
+		return nil, errors.New("empty level")
+	}
+	return []byte(strings.ToUpper(string(l))), nil
+}
+
+// testPadded implements fmt.Stringer, returning a value padded with white space.
+type testPadded struct{}
+
+func (testPadded) String() string { return "\tpadded\n" }
+
+// testBoth implements both OsqueryMarshaler and encoding.TextMarshaler.
+type testBoth struct{}
+
+func (testBoth) MarshalOsquery() (string, error) { return "osquery", nil }
+func (testBoth) MarshalText() ([]byte, error)    { return []byte("text"), nil }
+
+// testPoint implements fmt.Stringer with a pointer receiver.
+type testPoint struct {
+	X int
+	Y int
+}
+
+func (p *testPoint) String() string {
+	return fmt.Sprintf("(%d,%d)", p.X, p.Y)
+}
+
+// testTags is a slice type implementing fmt.Stringer with a pointer receiver.
+type testTags []string
+
+func (t *testTags) String() string {
+	return strings.Join(*t, "+")
+}
+
+// testState is an integer type implementing fmt.Stringer.
+type testState int
+
+func (s testState) String() string {
+	return "running"
+}
+
 func TestMarshalToMapWithFlags(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -80,10 +200,19 @@ func TestMarshalToMapWithFlags(t *testing.T) {
 		{
 			name: "invalid type",
 			input: &struct {
-				InvalidType map[int]string
-			}{InvalidType: map[int]string{1: "value"}},
+				InvalidType map[[2]int]string
+			}{InvalidType: map[[2]int]string{{1, 2}: "value"}},
+			flags:    0,
+			expected: map[string]string{"InvalidType": "map[[1 2]:value]"},
+			err:      false,
+		},
+		{
+			name: "int-keyed map field",
+			input: &struct {
+				Codes map[int]string
+			}{Codes: map[int]string{0: "ok", 1: "error"}},
 			flags:    0,
-			expected: map[string]string{"InvalidType": "map[1:value]"},
+			expected: map[string]string{"Codes.0": "ok", "Codes.1": "error"},
 			err:      false,
 		},
 		{
@@ -219,36 +348,1979 @@ func TestMarshalToMapWithFlags(t *testing.T) {
 			expected: map[string]string{"StrPtr": "", "IntPtr": ""},
 			err:      false,
 		},
-		// Test time.Time type
+		// Test time.Time type
+		{
+			name: "time.Time type",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
+			flags:    0,
+			expected: map[string]string{"time": "2023-06-15T14:30:00Z"},
+			err:      false,
+		},
+		{
+			name: "time.Time",
+			input: &struct {
+				Time time.Time `osquery:"time" format:"unix"`
+			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
+			flags:    0,
+			expected: map[string]string{"time": "1686839400"},
+			err:      false,
+		},
+		// Test nested structs
+		{
+			name: "nested struct",
+			input: &struct {
+				Event   string      `osquery:"event"`
+				Process testProcess `osquery:"process"`
+			}{Event: "exec", Process: testProcess{PID: 42, Name: "bash"}},
+			flags:    0,
+			expected: map[string]string{"event": "exec", "process.pid": "42", "process.name": "bash", "process.started": ""},
+			err:      false,
+		},
+		{
+			name: "nested struct pointer",
+			input: &struct {
+				Process *testProcess `osquery:"process"`
+			}{Process: &testProcess{PID: 42, Started: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)}},
+			flags:    0,
+			expected: map[string]string{"process.pid": "42", "process.name": "", "process.started": "2023-06-15T14:30:00Z"},
+			err:      false,
+		},
+		{
+			name: "nil nested struct pointer",
+			input: &struct {
+				Event   string       `osquery:"event"`
+				Process *testProcess `osquery:"process"`
+			}{Event: "exec"},
+			flags:    0,
+			expected: map[string]string{"event": "exec"},
+			err:      false,
+		},
+		{
+			name: "multi-level nested struct without tags",
+			input: &struct {
+				Event struct {
+					Parent testProcess
+				}
+			}{},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"Event.Parent.pid": "0", "Event.Parent.name": "", "Event.Parent.started": "0001-01-01T00:00:00Z"},
+			err:      false,
+		},
+		{
+			name: "time.Time non-UTC zone is converted to UTC",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.FixedZone("EST", -5*60*60))},
+			flags:    0,
+			expected: map[string]string{"time": "2023-06-15T19:30:00Z"},
+			err:      false,
+		},
+		{
+			name: "time.Time pointers",
+			input: &struct {
+				Time    *time.Time `osquery:"time"`
+				NilTime *time.Time `osquery:"nil_time"`
+			}{Time: timePtr(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC))},
+			flags:    0,
+			expected: map[string]string{"time": "2023-06-15T14:30:00Z", "nil_time": ""},
+			err:      false,
+		},
+		{
+			name: "zero time.Time",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{},
+			flags:    0,
+			expected: map[string]string{"time": ""},
+			err:      false,
+		},
+		{
+			name: "zero time.Time with flag",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"time": "0001-01-01T00:00:00Z"},
+			err:      false,
+		},
+		{
+			name: "time.Time map values",
+			input: map[string]any{
+				"utc":   time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC),
+				"local": time.Date(2023, 6, 15, 23, 30, 0, 0, time.FixedZone("JST", 9*60*60)),
+			},
+			flags:    0,
+			expected: map[string]string{"utc": "2023-06-15T14:30:00Z", "local": "2023-06-15T14:30:00Z"},
+			err:      false,
+		},
+		{
+			name: "time.Time with layout option",
+			input: &struct {
+				Time time.Time `osquery:"time,layout=2006-01-02"`
+			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
+			flags:    0,
+			expected: map[string]string{"time": "2023-06-15"},
+			err:      false,
+		},
+		{
+			name: "tag options are not part of the key",
+			input: &struct {
+				Name string `osquery:"name,layout=2006"`
+			}{Name: "test"},
+			flags:    0,
+			expected: map[string]string{"name": "test"},
+			err:      false,
+		},
+		{
+			name: "time.Time with unix flag",
+			input: &struct {
+				Time    time.Time  `osquery:"time"`
+				TimePtr *time.Time `osquery:"time_ptr"`
+				NilTime *time.Time `osquery:"nil_time"`
+			}{
+				Time:    time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC),
+				TimePtr: timePtr(time.Date(2023, 6, 15, 14, 30, 0, 0, time.FixedZone("EST", -5*60*60))),
+			},
+			flags:    EncodingFlagTimeAsUnix,
+			expected: map[string]string{"time": "1686839400", "time_ptr": "1686857400", "nil_time": ""},
+			err:      false,
+		},
+		{
+			name: "time.Time with unix milli flag",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 123000000, time.UTC)},
+			flags:    EncodingFlagTimeAsUnix | EncodingFlagTimeAsUnixMilli,
+			expected: map[string]string{"time": "1686839400123"},
+			err:      false,
+		},
+		{
+			name: "zero time.Time with unix flag",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{},
+			flags:    EncodingFlagTimeAsUnix,
+			expected: map[string]string{"time": ""},
+			err:      false,
+		},
+		{
+			name: "zero time.Time with unix and zero values flags",
+			input: &struct {
+				Time time.Time `osquery:"time"`
+			}{},
+			flags:    EncodingFlagTimeAsUnix | EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"time": "-62135596800"},
+			err:      false,
+		},
+		{
+			name: "time.Time field format takes precedence over unix flag",
+			input: &struct {
+				Formatted time.Time `osquery:"formatted" format:"rfc3339"`
+				Layout    time.Time `osquery:"layout,layout=2006-01-02"`
+			}{
+				Formatted: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC),
+				Layout:    time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC),
+			},
+			flags:    EncodingFlagTimeAsUnix,
+			expected: map[string]string{"formatted": "2023-06-15T14:30:00Z", "layout": "2023-06-15"},
+			err:      false,
+		},
+		{
+			name:     "time.Time map value with unix flag",
+			input:    map[string]any{"time": time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
+			flags:    EncodingFlagTimeAsUnix,
+			expected: map[string]string{"time": "1686839400"},
+			err:      false,
+		},
+		// Test time.Duration type
+		{
+			name: "time.Duration units",
+			input: &struct {
+				Uptime    time.Duration `osquery:"uptime"`
+				Truncated time.Duration `osquery:"truncated"`
+				Seconds   time.Duration `osquery:"seconds,duration=s"`
+				Millis    time.Duration `osquery:"millis,duration=ms"`
+				Micros    time.Duration `osquery:"micros,duration=us"`
+				Nanos     time.Duration `osquery:"nanos,duration=ns"`
+			}{
+				Uptime:    90 * time.Minute,
+				Truncated: 1500 * time.Millisecond,
+				Seconds:   2 * time.Second,
+				Millis:    1500 * time.Millisecond,
+				Micros:    1500 * time.Millisecond,
+				Nanos:     1500 * time.Millisecond,
+			},
+			flags: 0,
+			expected: map[string]string{
+				"uptime":    "5400",
+				"truncated": "1",
+				"seconds":   "2",
+				"millis":    "1500",
+				"micros":    "1500000",
+				"nanos":     "1500000000",
+			},
+			err: false,
+		},
+		{
+			name: "time.Duration pointers",
+			input: &struct {
+				Uptime    *time.Duration `osquery:"uptime"`
+				NilUptime *time.Duration `osquery:"nil_uptime"`
+			}{Uptime: durationPtr(time.Minute)},
+			flags:    0,
+			expected: map[string]string{"uptime": "60", "nil_uptime": ""},
+			err:      false,
+		},
+		{
+			name: "zero time.Duration",
+			input: &struct {
+				Uptime time.Duration `osquery:"uptime"`
+			}{},
+			flags:    0,
+			expected: map[string]string{"uptime": ""},
+			err:      false,
+		},
+		{
+			name: "zero time.Duration with flag",
+			input: &struct {
+				Uptime time.Duration `osquery:"uptime"`
+			}{},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"uptime": "0"},
+			err:      false,
+		},
+		{
+			name: "time.Duration with invalid unit",
+			input: &struct {
+				Uptime time.Duration `osquery:"uptime,duration=weeks"`
+			}{Uptime: time.Minute},
+			flags:    0,
+			expected: nil,
+			err:      true,
+		},
+		// Test OsqueryMarshaler
+		{
+			name: "OsqueryMarshaler fields",
+			input: struct {
+				Version    testVersion  `osquery:"version"`
+				VersionPtr *testVersion `osquery:"version_ptr"`
+				Range      testIPRange  `osquery:"range"`
+				RangePtr   *testIPRange `osquery:"range_ptr"`
+				NilRange   *testIPRange `osquery:"nil_range"`
+			}{
+				Version:    testVersion{Major: 1, Minor: 2},
+				VersionPtr: &testVersion{Major: 3, Minor: 4},
+				Range:      testIPRange{From: "10.0.0.1", To: "10.0.0.9"},
+				RangePtr:   &testIPRange{From: "10.0.1.1", To: "10.0.1.9"},
+			},
+			flags: 0,
+			expected: map[string]string{
+				"version":     "1.2",
+				"version_ptr": "3.4",
+				"range":       "10.0.0.1-10.0.0.9",
+				"range_ptr":   "10.0.1.1-10.0.1.9",
+				"nil_range":   "",
+			},
+			err: false,
+		},
+		{
+			name: "OsqueryMarshaler inside nested struct",
+			input: &struct {
+				Network struct {
+					Range testIPRange `osquery:"range"`
+				} `osquery:"network"`
+			}{Network: struct {
+				Range testIPRange `osquery:"range"`
+			}{Range: testIPRange{From: "10.0.0.1", To: "10.0.0.9"}}},
+			flags:    0,
+			expected: map[string]string{"network.range": "10.0.0.1-10.0.0.9"},
+			err:      false,
+		},
+		{
+			name:     "OsqueryMarshaler map values",
+			input:    map[string]any{"version": testVersion{Major: 1, Minor: 2}, "range": testIPRange{From: "a", To: "b"}},
+			flags:    0,
+			expected: map[string]string{"version": "1.2", "range": "a-b"},
+			err:      false,
+		},
+		{
+			name: "OsqueryMarshaler error",
+			input: &struct {
+				Range testIPRange `osquery:"range"`
+			}{Range: testIPRange{From: "10.0.0.1"}},
+			flags:    0,
+			expected: nil,
+			err:      true,
+		},
+		// Test encoding.TextMarshaler
+		{
+			name: "TextMarshaler fields",
+			input: struct {
+				IP       net.IP     `osquery:"ip"`
+				Level    testLevel  `osquery:"level"`
+				LevelPtr *testLevel `osquery:"level_ptr"`
+				NilLevel *testLevel `osquery:"nil_level"`
+			}{
+				IP:       net.ParseIP("10.0.0.1"),
+				Level:    "info",
+				LevelPtr: func() *testLevel { l := testLevel("warn"); return &l }(),
+			},
+			flags:    0,
+			expected: map[string]string{"ip": "10.0.0.1", "level": "INFO", "level_ptr": "WARN", "nil_level": ""},
+			err:      false,
+		},
+		{
+			name: "net.IP and net.IPNet fields",
+			input: &struct {
+				IPv4      net.IP     `osquery:"ipv4"`
+				IPv6      net.IP     `osquery:"ipv6"`
+				Invalid   net.IP     `osquery:"invalid"`
+				NilIP     net.IP     `osquery:"nil_ip"`
+				IPPtr     *net.IP    `osquery:"ip_ptr"`
+				Network   net.IPNet  `osquery:"network"`
+				NetPtr    *net.IPNet `osquery:"net_ptr"`
+				ZeroNet   net.IPNet  `osquery:"zero_net"`
+				NilNetPtr *net.IPNet `osquery:"nil_net_ptr"`
+			}{
+				IPv4:    net.ParseIP("10.0.0.1"),
+				IPv6:    net.ParseIP("fe80::1"),
+				Invalid: net.IP{1, 2},
+				IPPtr:   func() *net.IP { ip := net.IPv4(192, 168, 0, 1); return &ip }(),
+				Network: net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+				NetPtr:  &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)},
+			},
+			flags: EncodingFlagJSONComplex,
+			expected: map[string]string{
+				"ipv4":        "10.0.0.1",
+				"ipv6":        "fe80::1",
+				"invalid":     "?0102",
+				"nil_ip":      "",
+				"ip_ptr":      "192.168.0.1",
+				"network":     "10.0.0.0/8",
+				"net_ptr":     "2001:db8::/32",
+				"zero_net":    "",
+				"nil_net_ptr": "",
+			},
+			err: false,
+		},
+		{
+			name: "net.HardwareAddr fields",
+			input: &struct {
+				MAC    net.HardwareAddr  `osquery:"mac"`
+				MACPtr *net.HardwareAddr `osquery:"mac_ptr"`
+				NilMAC net.HardwareAddr  `osquery:"nil_mac"`
+				Empty  net.HardwareAddr  `osquery:"empty"`
+			}{
+				MAC:    net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+				MACPtr: &net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02},
+				Empty:  net.HardwareAddr{},
+			},
+			flags: EncodingFlagBytesHex,
+			expected: map[string]string{
+				"mac":     "00:00:5e:00:53:01",
+				"mac_ptr": "02:42:ac:11:00:02",
+				"nil_mac": "",
+				"empty":   "",
+			},
+			err: false,
+		},
+		{
+			name: "TextMarshaler error",
+			input: struct {
+				Level testLevel `osquery:"level"`
+			}{},
+			flags:    0,
+			expected: nil,
+			err:      true,
+		},
+		{
+			name: "OsqueryMarshaler takes precedence over TextMarshaler",
+			input: struct {
+				Both testBoth `osquery:"both"`
+			}{},
+			flags:    0,
+			expected: map[string]string{"both": "osquery"},
+			err:      false,
+		},
+		{
+			name: "time.Time formatting takes precedence over TextMarshaler",
+			input: struct {
+				Time time.Time `osquery:"time" format:"unix"`
+			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
+			flags:    0,
+			expected: map[string]string{"time": "1686839400"},
+			err:      false,
+		},
+		// Test fmt.Stringer
+		{
+			name: "Stringer with pointer receiver held by value",
+			input: struct {
+				Point    testPoint  `osquery:"point"`
+				PointPtr *testPoint `osquery:"point_ptr"`
+				Tags     testTags   `osquery:"tags"`
+			}{
+				Point:    testPoint{X: 1, Y: 2},
+				PointPtr: &testPoint{X: 3, Y: 4},
+				Tags:     testTags{"a", "b"},
+			},
+			flags:    0,
+			expected: map[string]string{"point": "(1,2)", "point_ptr": "(3,4)", "tags": "a+b"},
+			err:      false,
+		},
+		{
+			name: "Stringer with pointer receiver in addressable struct",
+			input: &struct {
+				Point testPoint `osquery:"point"`
+				Tags  testTags  `osquery:"tags"`
+			}{Point: testPoint{X: 1, Y: 2}, Tags: testTags{"a", "b"}},
+			flags:    0,
+			expected: map[string]string{"point": "(1,2)", "tags": "a+b"},
+			err:      false,
+		},
+		{
+			name: "built-in kind conversions take precedence over Stringer",
+			input: struct {
+				State testState `osquery:"state"`
+			}{State: 2},
+			flags:    0,
+			expected: map[string]string{"state": "2"},
+			err:      false,
+		},
+		// Test slices and arrays
+		{
+			name: "string slice",
+			input: &struct {
+				Tags []string `osquery:"tags"`
+			}{Tags: []string{"a", "b", "c"}},
+			flags:    0,
+			expected: map[string]string{"tags": "a,b,c"},
+			err:      false,
+		},
+		{
+			name: "int slice with zero elements",
+			input: &struct {
+				Codes []int `osquery:"codes"`
+			}{Codes: []int{0, 1, -2}},
+			flags:    0,
+			expected: map[string]string{"codes": "0,1,-2"},
+			err:      false,
+		},
+		{
+			name: "bool slice",
+			input: &struct {
+				Flags []bool `osquery:"flags"`
+			}{Flags: []bool{true, false, true}},
+			flags:    0,
+			expected: map[string]string{"flags": "1,0,1"},
+			err:      false,
+		},
+		{
+			name: "bools as true and false",
+			input: &struct {
+				Enabled bool   `osquery:"enabled"`
+				Hidden  bool   `osquery:"hidden"`
+				Flags   []bool `osquery:"flags"`
+				Ptr     *bool  `osquery:"ptr"`
+			}{Enabled: true, Flags: []bool{true, false}},
+			flags:    EncodingFlagBoolAsTrueFalse,
+			expected: map[string]string{"enabled": "true", "hidden": "false", "flags": "true,false", "ptr": ""},
+			err:      false,
+		},
+		{
+			name: "booltext option",
+			input: &struct {
+				Enabled bool `osquery:"enabled,booltext"`
+				Hidden  bool `osquery:"hidden,booltext"`
+				Active  bool `osquery:"active"`
+			}{Enabled: true, Active: true},
+			flags:    0,
+			expected: map[string]string{"enabled": "true", "hidden": "false", "active": "1"},
+			err:      false,
+		},
+		{
+			name: "bool strings option",
+			input: &struct {
+				State   bool   `osquery:"state,bool=on:off"`
+				Enabled bool   `osquery:"enabled,bool=yes:no,booltext"`
+				Checks  []bool `osquery:"checks,bool=pass:fail"`
+			}{State: true, Checks: []bool{true, false}},
+			flags:    EncodingFlagBoolAsTrueFalse,
+			expected: map[string]string{"state": "on", "enabled": "no", "checks": "pass,fail"},
+			err:      false,
+		},
+		{
+			name: "invalid bool strings option",
+			input: &struct {
+				State bool `osquery:"state,bool=on"`
+			}{},
+			err: true,
+		},
+		{
+			name: "trimmed strings",
+			input: &struct {
+				Name    string     `osquery:"name"`
+				Path    *string    `osquery:"path"`
+				Tags    []string   `osquery:"tags"`
+				Level   testLevel  `osquery:"level"`
+				Padded  testPadded `osquery:"padded"`
+				Blank   string     `osquery:"blank,default=none"`
+				Version testVersion
+			}{
+				Name:  "\t osqueryd \n",
+				Path:  stringPtr("  /usr/bin/osqueryd\r\n"),
+				Tags:  []string{" a", "b\t"},
+				Level: testLevel(" warn\n"),
+				Blank: " \t\n",
+			},
+			flags: EncodingFlagTrimSpace,
+			expected: map[string]string{
+				"name":    "osqueryd",
+				"path":    "/usr/bin/osqueryd",
+				"tags":    "a,b",
+				"level":   "WARN",
+				"padded":  "padded",
+				"blank":   "none",
+				"Version": "0.0",
+			},
+			err: false,
+		},
+		{
+			name: "strings are not trimmed by default",
+			input: &struct {
+				Name string `osquery:"name"`
+			}{Name: "\t osqueryd \n"},
+			flags:    0,
+			expected: map[string]string{"name": "\t osqueryd \n"},
+			err:      false,
+		},
+		{
+			name: "nil and empty slices",
+			input: &struct {
+				Nil   []string  `osquery:"nil"`
+				Empty []int     `osquery:"empty"`
+				Ptr   *[]string `osquery:"ptr"`
+			}{Empty: []int{}},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"nil": "", "empty": "", "ptr": ""},
+			err:      false,
+		},
+		{
+			name: "slice of pointers and time values",
+			input: &struct {
+				Names []*string       `osquery:"names"`
+				Times []time.Time     `osquery:"times,layout=2006-01-02"`
+				Waits []time.Duration `osquery:"waits,duration=ms"`
+			}{
+				Names: []*string{stringPtr("a"), nil, stringPtr("c")},
+				Times: []time.Time{time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), time.Date(2023, 6, 16, 0, 0, 0, 0, time.UTC)},
+				Waits: []time.Duration{time.Second, 0},
+			},
+			flags:    0,
+			expected: map[string]string{"names": "a,,c", "times": "2023-06-15,2023-06-16", "waits": "1000,0"},
+			err:      false,
+		},
+		{
+			name:     "slice map value",
+			input:    map[string]any{"tags": []string{"a", "b"}},
+			flags:    0,
+			expected: map[string]string{"tags": "a,b"},
+			err:      false,
+		},
+		{
+			name: "slice with separator option",
+			input: &struct {
+				Tags  []string `osquery:"tags,sep=|"`
+				Codes [2]int   `osquery:"codes,sep=; "`
+				Empty []string `osquery:"empty,sep="`
+			}{Tags: []string{"a", "b,c"}, Codes: [2]int{1, 2}, Empty: []string{"x", "y"}},
+			flags:    0,
+			expected: map[string]string{"tags": "a|b,c", "codes": "1; 2", "empty": "xy"},
+			err:      false,
+		},
+		{
+			name: "array fields",
+			input: &struct {
+				Names [3]string `osquery:"names"`
+				Codes [2]int    `osquery:"codes"`
+				None  [0]int    `osquery:"none"`
+				Hash  [2]byte   `osquery:"hash"`
+				Ptr   *[2]int   `osquery:"ptr"`
+			}{Names: [3]string{"init", "", "sshd"}, Codes: [2]int{0, 22}, Hash: [2]byte{0xff, 0x01}, Ptr: &[2]int{1, 2}},
+			flags:    0,
+			expected: map[string]string{"names": "init,,sshd", "codes": "0,22", "none": "", "hash": "/wE=", "ptr": "1,2"},
+			err:      false,
+		},
+		{
+			name: "array fields with JSON flags",
+			input: &struct {
+				Names  [3]string      `osquery:"names"`
+				Codes  [2]int         `osquery:"codes"`
+				None   [0]int         `osquery:"none"`
+				Agents [0]testProcess `osquery:"agents"`
+			}{Names: [3]string{"init", "", "sshd"}, Codes: [2]int{0, 22}},
+			flags:    EncodingFlagJSONComplex,
+			expected: map[string]string{"names": `["init","","sshd"]`, "codes": "[0,22]", "none": "", "agents": ""},
+			err:      false,
+		},
+		{
+			name: "slices with JSON flag",
+			input: &struct {
+				Tags   []string  `osquery:"tags,sep=|"`
+				Codes  []int     `osquery:"codes"`
+				Floats []float64 `osquery:"floats,prec=1"`
+				Flags  []bool    `osquery:"flags"`
+				Modes  []uint32  `osquery:"modes,base=16"`
+				Values []any     `osquery:"values"`
+				Nil    []string  `osquery:"nil"`
+			}{
+				Tags:   []string{"a", "b,c", `"d"`},
+				Codes:  []int{1, 0},
+				Floats: []float64{1.25, -2, math.NaN()},
+				Flags:  []bool{true, false},
+				Modes:  []uint32{0x1ff},
+				Values: []any{1, "1", true, nil},
+			},
+			flags: EncodingFlagJSONSlices,
+			expected: map[string]string{
+				"tags": `["a","b,c","\"d\""]`, "codes": "[1,0]", "floats": `[1.2,-2.0,"NaN"]`,
+				"flags": "[true,false]", "modes": `["1ff"]`, "values": `[1,"1",true,""]`, "nil": "",
+			},
+			err: false,
+		},
+		{
+			name: "embedded struct fields promoted",
+			input: &struct {
+				testHost
+				*testProcess
+				testAgent
+				Name string `osquery:"name"`
+			}{
+				testHost:    testHost{Hostname: "localhost", OS: "linux"},
+				testProcess: &testProcess{PID: 1, Name: "init"},
+				testAgent:   testAgent{AgentID: "abc"},
+				Name:        "outer",
+			},
+			expected: map[string]string{
+				"hostname": "localhost",
+				"os":       "linux",
+				"pid":      "1",
+				"name":     "outer",
+				"started":  "",
+				"agent_id": "abc",
+			},
+			err: false,
+		},
+		{
+			name: "embedded struct fields prefixed with the tag name",
+			input: &struct {
+				testHost     `osquery:"host"`
+				*testProcess `osquery:"process"`
+				Name         string `osquery:"name"`
+			}{
+				testHost: testHost{Hostname: "localhost"},
+				Name:     "outer",
+			},
+			expected: map[string]string{
+				"host.hostname": "localhost",
+				"host.os":       "",
+				"name":          "outer",
+			},
+			err: false,
+		},
+		{
+			name: "embedded nil pointer and time",
+			input: &struct {
+				*testHost
+				time.Time
+				Name string `osquery:"name"`
+			}{
+				Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				Name: "outer",
+			},
+			expected: map[string]string{
+				"Time": "2024-01-02T03:04:05Z",
+				"name": "outer",
+			},
+			err: false,
+		},
+		{
+			name: "omitempty fields",
+			input: &struct {
+				Name    string            `osquery:"name,omitempty"`
+				PID     int               `osquery:"pid,omitempty"`
+				Active  bool              `osquery:"active,omitempty"`
+				Started time.Time         `osquery:"started,omitempty"`
+				Parent  *int              `osquery:"parent,omitempty"`
+				Tags    []string          `osquery:"tags,omitempty"`
+				Level   testLevel         `osquery:"level,omitempty"`
+				Labels  map[string]string `osquery:"labels,omitempty"`
+				Path    string            `osquery:"path,omitempty"`
+				Empty   string            `osquery:"empty"`
+			}{
+				Level:  "info",
+				Labels: map[string]string{"env": "", "tier": "web"},
+				Path:   "/bin/sh",
+			},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"level":       "INFO",
+				"labels.tier": "web",
+				"path":        "/bin/sh",
+				"empty":       "",
+			},
+			err: false,
+		},
+		{
+			name: "string option renders zero numbers per field",
+			input: &struct {
+				Count    int           `osquery:"count,string"`
+				Ratio    float64       `osquery:"ratio,string"`
+				Parent   *uint         `osquery:"parent,string"`
+				Nil      *int          `osquery:"nil,string"`
+				Timeout  time.Duration `osquery:"timeout,string"`
+				Other    int           `osquery:"other"`
+				Omitted  int           `osquery:"omitted,string,omitempty"`
+				Positive int           `osquery:"positive,omitempty,string"`
+			}{
+				Parent:   new(uint),
+				Positive: 3,
+			},
+			expected: map[string]string{
+				"count":    "0",
+				"ratio":    "0",
+				"parent":   "0",
+				"nil":      "",
+				"timeout":  "0",
+				"other":    "",
+				"positive": "3",
+			},
+			err: false,
+		},
+		{
+			name: "default option for empty values",
+			input: &struct {
+				Status  string  `osquery:"status,default=unknown"`
+				Parent  *int    `osquery:"parent,default=-1"`
+				Count   int     `osquery:"count,default=none"`
+				Zero    int     `osquery:"zero,string,default=none"`
+				Omitted string  `osquery:"omitted,omitempty,default=unknown"`
+				Name    string  `osquery:"name,default=unknown"`
+				Score   float64 `osquery:"score,default=0.0"`
+			}{
+				Name: "init",
+			},
+			expected: map[string]string{
+				"status": "unknown",
+				"parent": "-1",
+				"count":  "none",
+				"zero":   "0",
+				"name":   "init",
+				"score":  "0.0",
+			},
+			err: false,
+		},
+		{
+			name: "snake case keys for untagged fields",
+			input: &struct {
+				ProcessID  int
+				HTTPStatus int    `osquery:",omitempty"`
+				UserName   string `osquery:"UserName"`
+				Parent     struct {
+					ProcessID int
+				}
+			}{
+				ProcessID:  1,
+				HTTPStatus: 200,
+				UserName:   "root",
+			},
+			flags: EncodingFlagSnakeCaseKeys,
+			expected: map[string]string{
+				"process_id":        "1",
+				"http_status":       "200",
+				"UserName":          "root",
+				"parent.process_id": "",
+			},
+			err: false,
+		},
+		{
+			name: "map fields flattened into dotted keys",
+			input: &struct {
+				Name   string            `osquery:"name"`
+				Labels map[string]any    `osquery:"labels"`
+				Env    map[string]string `osquery:"env,inline"`
+				Nil    map[string]any    `osquery:"nil"`
+			}{
+				Name: "test",
+				Labels: map[string]any{
+					"tier":    "web",
+					"port":    8080,
+					"process": testProcess{PID: 1, Name: "init"},
+					"owner":   map[string]any{"team": "infra"},
+					"created": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				},
+				Env: map[string]string{"HOME": "/root"},
+			},
+			expected: map[string]string{
+				"name":                   "test",
+				"labels.tier":            "web",
+				"labels.port":            "8080",
+				"labels.process.pid":     "1",
+				"labels.process.name":    "init",
+				"labels.process.started": "",
+				"labels.owner.team":      "infra",
+				"labels.created":         "2024-01-02T03:04:05Z",
+				"HOME":                   "/root",
+			},
+			err: false,
+		},
+		{
+			name: "inline map field colliding with a sibling field",
+			input: &struct {
+				Name  string            `osquery:"name"`
+				Extra map[string]string `osquery:"extra,inline"`
+			}{
+				Name:  "test",
+				Extra: map[string]string{"name": "other"},
+			},
+			expected: nil,
+			err:      true,
+		},
+		{
+			name: "inline map field without a name",
+			input: &struct {
+				Name  string         `osquery:"name"`
+				Extra map[string]any `osquery:",inline"`
+			}{
+				Name:  "test",
+				Extra: map[string]any{"env": "prod", "port": 8080},
+			},
+			expected: map[string]string{"name": "test", "env": "prod", "port": "8080"},
+			err:      false,
+		},
+		{
+			name: "inline map field colliding with a later sibling field",
+			input: &struct {
+				Extra map[string]string `osquery:",inline"`
+				Name  string            `osquery:"name"`
+			}{
+				Name:  "test",
+				Extra: map[string]string{"name": "other"},
+			},
+			expected: nil,
+			err:      true,
+		},
+		{
+			name: "map field colliding with a later sibling field",
+			input: &struct {
+				Labels map[string]string `osquery:"labels"`
+				Env    string            `osquery:"labels.env"`
+			}{
+				Labels: map[string]string{"env": "prod"},
+				Env:    "dev",
+			},
+			expected: nil,
+			err:      true,
+		},
+		{
+			name: "map field entries colliding with each other",
+			input: &struct {
+				Labels map[string]any `osquery:"labels"`
+			}{
+				Labels: map[string]any{"a.b": "1", "a": map[string]string{"b": "2"}},
+			},
+			expected: nil,
+			err:      true,
+		},
+		{
+			name: "json.RawMessage fields passed through",
+			input: &struct {
+				Raw    json.RawMessage  `osquery:"raw"`
+				RawPtr *json.RawMessage `osquery:"raw_ptr"`
+				Empty  json.RawMessage  `osquery:"empty"`
+				Nil    json.RawMessage  `osquery:"nil"`
+			}{
+				Raw:    json.RawMessage(`{"a":[1,2]}`),
+				RawPtr: func() *json.RawMessage { r := json.RawMessage(`"x"`); return &r }(),
+				Empty:  json.RawMessage{},
+			},
+			flags: EncodingFlagJSONComplex | EncodingFlagBytesHex,
+			expected: map[string]string{
+				"raw":     `{"a":[1,2]}`,
+				"raw_ptr": `"x"`,
+				"empty":   "",
+				"nil":     "",
+			},
+			err: false,
+		},
+		{
+			name: "float precision option",
+			input: struct {
+				Price   float64    `osquery:"price,prec=2"`
+				Ratio   float32    `osquery:"ratio,prec=3"`
+				Rounded float64    `osquery:"rounded,prec=0"`
+				Zero    float64    `osquery:"zero,prec=2"`
+				Always  float32    `osquery:"always,prec=2,string"`
+				Values  []float64  `osquery:"values,prec=1"`
+				Big     *big.Float `osquery:"big,prec=4"`
+			}{
+				Price:   19.999,
+				Ratio:   0.5,
+				Rounded: 2.5,
+				Values:  []float64{1, 0.25},
+				Big:     big.NewFloat(1.5),
+			},
+			expected: map[string]string{
+				"price":   "20.00",
+				"ratio":   "0.500",
+				"rounded": "2",
+				"zero":    "",
+				"always":  "0.00",
+				"values":  "1.0,0.2",
+				"big":     "1.5000",
+			},
+			err: false,
+		},
+		{
+			name: "float precision option with zero values",
+			input: struct {
+				Price float64 `osquery:"price,prec=2"`
+				Ratio float32 `osquery:"ratio,prec=1"`
+			}{},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"price": "0.00",
+				"ratio": "0.0",
+			},
+			err: false,
+		},
+		{
+			name: "invalid float precision",
+			input: struct {
+				Price float64 `osquery:"price,prec=two"`
+			}{Price: 1},
+			err: true,
+		},
+		{
+			name: "channel and function fields are skipped",
+			input: struct {
+				Name     string        `osquery:"name"`
+				Events   chan int      `osquery:"events"`
+				Done     <-chan bool   `osquery:"done"`
+				Callback func() error  `osquery:"callback"`
+				Handler  *func(string) `osquery:"handler"`
+			}{
+				Name:     "osqueryd",
+				Events:   make(chan int),
+				Callback: func() error { return nil },
+			},
+			expected: map[string]string{"name": "osqueryd"},
+			err:      false,
+		},
+		{
+			name: "complex fields are errors by default",
+			input: struct {
+				Phase complex128 `osquery:"phase"`
+			}{Phase: complex(1, 2)},
+			err: true,
+		},
+		{
+			name: "complex fields with skip complex",
+			input: struct {
+				Name  string     `osquery:"name"`
+				Phase complex128 `osquery:"phase"`
+				Small complex64  `osquery:"small"`
+				Ptr   *complex64 `osquery:"ptr"`
+			}{Name: "osqueryd", Phase: complex(1, 2)},
+			flags:    EncodingFlagSkipComplex,
+			expected: map[string]string{"name": "osqueryd"},
+			err:      false,
+		},
+		{
+			name: "map entries of skipped types",
+			input: map[string]any{
+				"name":     "osqueryd",
+				"events":   make(chan int),
+				"callback": func() {},
+				"phase":    complex(1, 2),
+			},
+			flags:    EncodingFlagSkipComplex,
+			expected: map[string]string{"name": "osqueryd"},
+			err:      false,
+		},
+		{
+			name: "integer base option",
+			input: struct {
+				Mode   uint32 `osquery:"mode,base=8"`
+				Flags  int    `osquery:"flags,base=16,prefix"`
+				Offset int64  `osquery:"offset,base=16,prefix"`
+				Bits   uint8  `osquery:"bits,base=2,prefix"`
+				Zero   int    `osquery:"zero,base=16,prefix"`
+				Plain  int    `osquery:"plain,base=10,prefix"`
+				Masks  []uint `osquery:"masks,base=16"`
+			}{
+				Mode:   0o755,
+				Flags:  0x1a4,
+				Offset: -31,
+				Bits:   5,
+				Plain:  42,
+				Masks:  []uint{0xff, 0},
+			},
+			expected: map[string]string{
+				"mode":   "755",
+				"flags":  "0x1a4",
+				"offset": "-0x1f",
+				"bits":   "0b101",
+				"zero":   "",
+				"plain":  "42",
+				"masks":  "ff,0",
+			},
+			err: false,
+		},
+		{
+			name: "integer base option with zero values",
+			input: struct {
+				Flags int `osquery:"flags,base=16,prefix"`
+			}{},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"flags": "0x0"},
+			err:      false,
+		},
+		{
+			name: "char option",
+			input: struct {
+				Flag    rune   `osquery:"flag,char"`
+				Symbol  int32  `osquery:"symbol,char"`
+				Zero    rune   `osquery:"zero,char"`
+				Invalid rune   `osquery:"invalid,char"`
+				Plain   rune   `osquery:"plain"`
+				Flags   []rune `osquery:"flags,char"`
+				Ptr     *rune  `osquery:"ptr,char"`
+				Nil     *int32 `osquery:"nil,char"`
+			}{
+				Flag:    'Y',
+				Symbol:  '€',
+				Invalid: 0xd800,
+				Plain:   'Y',
+				Flags:   []rune{'r', 'w', 'x'},
+				Ptr:     func() *rune { r := 'ü'; return &r }(),
+			},
+			expected: map[string]string{
+				"flag":    "Y",
+				"symbol":  "€",
+				"zero":    "",
+				"invalid": "55296",
+				"plain":   "89",
+				"flags":   "r,w,x",
+				"ptr":     "ü",
+				"nil":     "",
+			},
+			err: false,
+		},
+		{
+			name: "char option with zero values",
+			input: struct {
+				Flag rune `osquery:"flag,char"`
+			}{},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"flag": "0"},
+			err:      false,
+		},
+		{
+			name: "unsupported integer base",
+			input: struct {
+				Flags int `osquery:"flags,base=36"`
+			}{Flags: 1},
+			err: true,
+		},
+		{
+			name: "non-finite floats by default",
+			input: struct {
+				NaN    float64 `osquery:"nan"`
+				Inf    float32 `osquery:"inf,prec=2"`
+				NegInf float64 `osquery:"neg_inf"`
+			}{
+				NaN:    math.NaN(),
+				Inf:    float32(math.Inf(1)),
+				NegInf: math.Inf(-1),
+			},
+			expected: map[string]string{
+				"nan":     "NaN",
+				"inf":     "+Inf",
+				"neg_inf": "-Inf",
+			},
+			err: false,
+		},
+		{
+			name: "non-finite floats with finite floats only",
+			input: struct {
+				NaN    float64    `osquery:"nan"`
+				Inf    float32    `osquery:"inf,prec=2"`
+				NegInf *float64   `osquery:"neg_inf"`
+				Values []float64  `osquery:"values"`
+				Big    *big.Float `osquery:"big"`
+				Finite float64    `osquery:"finite,prec=1"`
+			}{
+				NaN:    math.NaN(),
+				Inf:    float32(math.Inf(1)),
+				NegInf: func() *float64 { f := math.Inf(-1); return &f }(),
+				Values: []float64{1, math.NaN()},
+				Big:    new(big.Float).SetInf(false),
+				Finite: 1.25,
+			},
+			flags: EncodingFlagFiniteFloatsOnly | EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"nan":     "",
+				"inf":     "",
+				"neg_inf": "",
+				"values":  "1,",
+				"big":     "",
+				"finite":  "1.2",
+			},
+			err: false,
+		},
+		{
+			name: "big numbers in full decimal notation",
+			input: func() any {
+				n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+				f, _ := new(big.Float).SetPrec(200).SetString("12345678901234567890.125")
+				return struct {
+					Int      big.Int    `osquery:"int"`
+					IntPtr   *big.Int   `osquery:"int_ptr"`
+					Float    *big.Float `osquery:"float"`
+					Zero     big.Int    `osquery:"zero"`
+					NilInt   *big.Int   `osquery:"nil_int"`
+					NilFloat *big.Float `osquery:"nil_float"`
+				}{
+					Int:    *n,
+					IntPtr: big.NewInt(-42),
+					Float:  f,
+				}
+			}(),
+			expected: map[string]string{
+				"int":       "123456789012345678901234567890",
+				"int_ptr":   "-42",
+				"float":     "12345678901234567890.125",
+				"zero":      "",
+				"nil_int":   "",
+				"nil_float": "",
+			},
+			err: false,
+		},
+		{
+			name: "byte fields as base64",
+			input: &struct {
+				Data  []byte   `osquery:"data"`
+				Hash  [4]byte  `osquery:"hash"`
+				Empty []byte   `osquery:"empty"`
+				Nil   []byte   `osquery:"nil"`
+				Ptr   *[]byte  `osquery:"ptr"`
+				List  []uint16 `osquery:"list"`
+			}{
+				Data:  []byte("hi"),
+				Hash:  [4]byte{0xde, 0xad, 0xbe, 0xef},
+				Empty: []byte{},
+				Ptr:   &[]byte{0xff},
+				List:  []uint16{104, 105},
+			},
+			expected: map[string]string{
+				"data":  "aGk=",
+				"hash":  "3q2+7w==",
+				"empty": "",
+				"nil":   "",
+				"ptr":   "/w==",
+				"list":  "104,105",
+			},
+			err: false,
+		},
+		{
+			name: "byte fields as hex",
+			input: &struct {
+				Data []byte  `osquery:"data"`
+				Hash [4]byte `osquery:"hash"`
+				Nil  []byte  `osquery:"nil"`
+			}{
+				Data: []byte("hi"),
+				Hash: [4]byte{0xde, 0xad, 0xbe, 0xef},
+			},
+			flags: EncodingFlagBytesHex,
+			expected: map[string]string{
+				"data": "6869",
+				"hash": "deadbeef",
+				"nil":  "",
+			},
+			err: false,
+		},
+		{
+			name: "byte fields verbatim",
+			input: &struct {
+				Data []byte `osquery:"data"`
+				Nil  []byte `osquery:"nil"`
+			}{
+				Data: []byte("héllo"),
+			},
+			flags: EncodingFlagBytesRaw | EncodingFlagBytesHex | EncodingFlagJSONComplex,
+			expected: map[string]string{
+				"data": "héllo",
+				"nil":  "",
+			},
+			err: false,
+		},
+		{
+			name: "byte fields as numbers",
+			input: &struct {
+				Codes  []uint8 `osquery:"codes,bytesasnums"`
+				Octets [4]byte `osquery:"octets,bytesasnums,sep=."`
+				Hex    []byte  `osquery:"hex,bytesasnums,base=16"`
+				Nil    []byte  `osquery:"nil,bytesasnums"`
+				Data   []uint8 `osquery:"data"`
+			}{
+				Codes:  []uint8{1, 0, 255},
+				Octets: [4]byte{10, 0, 0, 1},
+				Hex:    []byte{0xde, 0xad},
+				Data:   []uint8{1, 0, 255},
+			},
+			flags: EncodingFlagJSONComplex,
+			expected: map[string]string{
+				"codes":  "1,0,255",
+				"octets": "10.0.0.1",
+				"hex":    "de,ad",
+				"nil":    "",
+				"data":   "AQD/",
+			},
+			err: false,
+		},
+		{
+			name: "complex values with JSON flag",
+			input: &struct {
+				Labels    map[string]any `osquery:"labels"`
+				Processes []testProcess  `osquery:"processes"`
+				Codes     [2]int         `osquery:"codes"`
+				Tags      []string       `osquery:"tags"`
+				Empty     []string       `osquery:"empty"`
+				NilMap    map[string]any `osquery:"nil_map"`
+				NilSlice  []int          `osquery:"nil_slice"`
+			}{
+				Labels:    map[string]any{"env": "prod", "replicas": 3},
+				Processes: []testProcess{{PID: 1, Name: "init"}},
+				Codes:     [2]int{0, 1},
+				Tags:      []string{"a", "b"},
+				Empty:     []string{},
+			},
+			flags: EncodingFlagJSONComplex | EncodingFlagJSONSlices,
+			expected: map[string]string{
+				"labels":    `{"env":"prod","replicas":3}`,
+				"processes": `[{"name":"init","pid":"1","started":""}]`,
+				"codes":     "[0,1]",
+				"tags":      `["a","b"]`,
+				"empty":     "[]",
+				"nil_map":   "",
+				"nil_slice": "",
+			},
+			err: false,
+		},
+		{
+			name: "slices of structs with JSON flag",
+			input: &struct {
+				Processes []*testProcess   `osquery:"processes"`
+				Agents    [1]testAgentInfo `osquery:"agents"`
+				Nil       []testProcess    `osquery:"nil"`
+				Empty     []testProcess    `osquery:"empty"`
+			}{
+				Processes: []*testProcess{{PID: 1, Name: "init"}, nil},
+				Agents:    [1]testAgentInfo{{Hostname: "db1", Secret: "s3cr3t", Skipped: "x"}},
+				Empty:     []testProcess{},
+			},
+			flags: EncodingFlagJSONComplex | EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"processes": `[{"name":"init","pid":"1","started":"0001-01-01T00:00:00Z"},null]`,
+				"agents":    `[{"hostname":"db1","process.name":"","process.pid":"0","process.started":"0001-01-01T00:00:00Z","secret":"***","version":"0.0"}]`,
+				"nil":       "",
+				"empty":     "[]",
+			},
+			err: false,
+		},
+		{
+			name: "slices of structs without JSON flag",
+			input: &struct {
+				Processes []testProcess  `osquery:"processes"`
+				Pointers  []*testProcess `osquery:"pointers"`
+				Nil       []testProcess  `osquery:"nil"`
+			}{
+				Processes: []testProcess{{PID: 1, Name: "init"}, {PID: 2, Name: "bash"}},
+				Pointers:  []*testProcess{nil},
+			},
+			flags: 0,
+			expected: map[string]string{
+				"processes": `[{"name":"init","pid":"1","started":""},{"name":"bash","pid":"2","started":""}]`,
+				"pointers":  "[null]",
+				"nil":       "",
+			},
+			err: false,
+		},
+		{
+			name:     "complex map values with JSON flag",
+			input:    map[string]any{"labels": map[string]string{"env": "prod"}, "name": "test"},
+			flags:    EncodingFlagJSONComplex,
+			expected: map[string]string{"labels": `{"env":"prod"}`, "name": "test"},
+			err:      false,
+		},
+		{
+			name: "JSON marshal error",
+			input: &struct {
+				Values []any `osquery:"values"`
+			}{Values: []any{func() {}}},
+			flags:    EncodingFlagJSONComplex,
+			expected: nil,
+			err:      true,
+		},
+		{
+			name: "slice element error",
+			input: &struct {
+				Levels []testLevel `osquery:"levels"`
+			}{Levels: []testLevel{"info", ""}},
+			flags:    0,
+			expected: nil,
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := MarshalToMapWithFlags(test.input, test.flags)
+		if (err != nil) != test.err {
+			t.Errorf("%s: MarshalToMapWithFlags(%v, %v) error = %v; expected error = %v", test.name, test.input, test.flags, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: MarshalToMapWithFlags(%v, %v) = %v; expected %v", test.name, test.input, test.flags, result, test.expected)
+			continue
+		}
+	}
+}
+
+func TestMarshalToMapWithFlags_multiLevelPointers(t *testing.T) {
+	type pointers struct {
+		Int    **int         `osquery:"int"`
+		String **string      `osquery:"string"`
+		Bytes  *[]byte       `osquery:"bytes"`
+		Deep   ***int        `osquery:"deep"`
+		Time   **time.Time   `osquery:"time"`
+		Names  **[]string    `osquery:"names"`
+		Proc   **testProcess `osquery:"proc"`
+	}
+
+	n, s, b := 42, "osqueryd", []byte("hi")
+	pn, ps := &n, &s
+	ppn := &pn
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	pt := &started
+	names := []string{"a", "b"}
+	pnames := &names
+	proc := &testProcess{PID: 1}
+	var nilInt *int
+
+	tests := []struct {
+		name     string
+		input    pointers
+		flags    EncodingFlag
+		expected map[string]string
+	}{
+		{
+			name: "pointers are followed to the value",
+			input: pointers{
+				Int: &pn, String: &ps, Bytes: &b, Deep: &ppn, Time: &pt, Names: &pnames, Proc: &proc,
+			},
+			expected: map[string]string{
+				"int": "42", "string": "osqueryd", "bytes": "aGk=", "deep": "42",
+				"time": "2024-01-02T03:04:05Z", "names": "a,b",
+				"proc.pid": "1", "proc.name": "", "proc.started": "",
+			},
+		},
+		{
+			name:  "nil pointers anywhere in the chain are empty",
+			input: pointers{Int: &nilInt, Deep: new(**int)},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"int": "", "string": "", "bytes": "", "deep": "", "time": "", "names": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMapWithFlags(tt.input, tt.flags)
+			if err != nil {
+				t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// testSignal is an integer type implementing fmt.Stringer.
+type testSignal int
+
+func (s testSignal) String() string {
+	return fmt.Sprintf("SIG%d", int(s))
+}
+
+// testParity formats several integers to the same name.
+type testParity int
+
+func (p testParity) String() string {
+	if p%2 == 0 {
+		return "even"
+	}
+	return "odd"
+}
+
+func TestMarshalToMap_mapKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		expected map[string]string
+		err      string
+	}{
+		{
+			name:     "int keys",
+			input:    map[int]string{0: "ok", 404: "not found", -1: "unknown"},
+			expected: map[string]string{"0": "ok", "404": "not found", "-1": "unknown"},
+		},
+		{
+			name:     "float keys",
+			input:    map[float64]uint8{1.5: 1, 0: 2},
+			expected: map[string]string{"1.5": "1", "0": "2"},
+		},
+		{
+			name:     "NaN keys",
+			input:    map[float64]string{math.NaN(): "high", 1: "low"},
+			expected: map[string]string{"NaN": "high", "1": "low"},
+		},
+		{
+			name:     "bool keys",
+			input:    map[bool]string{true: "a", false: "b"},
+			expected: map[string]string{"1": "a", "0": "b"},
+		},
+		{
+			name:     "Stringer keys",
+			input:    map[testSignal]int{9: 1, 15: 2},
+			expected: map[string]string{"SIG9": "1", "SIG15": "2"},
+		},
+		{
+			name:     "registered enum keys",
+			input:    map[testServiceState]string{testServiceRunning: "1", 7: "2"},
+			expected: map[string]string{"running": "1", "7": "2"},
+		},
+		{
+			name:     "MarshalOsquery keys",
+			input:    map[testVersion]string{{Major: 1, Minor: 2}: "stable"},
+			expected: map[string]string{"1.2": "stable"},
+		},
+		{
+			name: "map field",
+			input: &struct {
+				Exits map[testSignal]int `osquery:"exits"`
+			}{Exits: map[testSignal]int{9: 3}},
+			expected: map[string]string{"exits.SIG9": "3"},
+		},
+		{
+			name: "NaN keys of a map field",
+			input: &struct {
+				Scores map[float64]string `osquery:"scores"`
+			}{Scores: map[float64]string{math.NaN(): "high"}},
+			expected: map[string]string{"scores.NaN": "high"},
+		},
+		{
+			name:  "colliding keys",
+			input: map[testParity]string{1: "a", 3: "b"},
+			err:   "duplicate key odd from map keys formatting to the same string",
+		},
+		{
+			name: "colliding keys of a map field",
+			input: &struct {
+				Buckets map[testParity]string `osquery:"buckets"`
+			}{Buckets: map[testParity]string{2: "a", 4: "b"}},
+			err: "duplicate key buckets.even from flattened map field",
+		},
+		{
+			name:  "unsupported keys",
+			input: map[[2]int]string{{1, 2}: "a"},
+			err:   "map keys must be strings, numbers, bools, or implement fmt.Stringer or encoding.TextMarshaler, got [2]int",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMap(tt.input)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("MarshalToMap() error = %v; expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MarshalToMap() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMap() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// Revision implements OsqueryMarshaler and OsqueryUnmarshaler with pointer receivers. It is
+// exported as embedded fields are named after their type, and must be exported to be marshaled.
+type Revision struct {
+	ID string
+}
+
+func (r *Revision) MarshalOsquery() (string, error) {
+	return "rev-" + r.ID, nil
+}
+
+func (r *Revision) UnmarshalOsquery(s string) error {
+	r.ID = strings.TrimPrefix(s, "rev-")
+	return nil
+}
+
+func TestMarshalToMap_embeddedPointers(t *testing.T) {
+	type embedding struct {
+		*testHost
+		*Revision
+		Name string `osquery:"name"`
+	}
+	type tagged struct {
+		*Revision `osquery:"revision"`
+		Name      string `osquery:"name"`
+	}
+
+	tests := []struct {
+		name     string
+		input    any
+		expected map[string]string
+	}{
+		{
+			name:  "non-nil pointers",
+			input: &embedding{testHost: &testHost{Hostname: "db1", OS: "linux"}, Revision: &Revision{ID: "abc"}, Name: "osqueryd"},
+			// The fields of the embedded struct are promoted, the marshaler is used as a single column
+			expected: map[string]string{"hostname": "db1", "os": "linux", "Revision": "rev-abc", "name": "osqueryd"},
+		},
+		{
+			name:  "nil pointers",
+			input: &embedding{Name: "osqueryd"},
+			// A nil struct contributes no columns, a nil marshaler is rendered as a nil value
+			expected: map[string]string{"Revision": "", "name": "osqueryd"},
+		},
+		{
+			name:     "tagged marshaler",
+			input:    tagged{Revision: &Revision{ID: "abc"}},
+			expected: map[string]string{"revision": "rev-abc", "name": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMap(tt.input)
+			if err != nil {
+				t.Fatalf("MarshalToMap() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMap() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	columns, err := GenerateColumnDefinitions(embedding{})
+	if err != nil {
+		t.Fatalf("GenerateColumnDefinitions() failed: %v", err)
+	}
+	expectedColumns := []table.ColumnDefinition{
+		table.TextColumn("hostname"),
+		table.TextColumn("os"),
+		table.TextColumn("Revision"),
+		table.TextColumn("name"),
+	}
+	if !reflect.DeepEqual(columns, expectedColumns) {
+		t.Errorf("GenerateColumnDefinitions() = %v; expected %v", columns, expectedColumns)
+	}
+
+	// The embedded marshaler is decoded back with its unmarshaler
+	var out tagged
+	if err := UnmarshalMap(map[string]string{"revision": "rev-abc", "name": "osqueryd"}, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	if out.Revision == nil || out.Revision.ID != "abc" || out.Name != "osqueryd" {
+		t.Errorf("UnmarshalMap() = %+v; expected revision abc", out)
+	}
+}
+
+func TestMarshalToMap_slices(t *testing.T) {
+	for _, input := range []any{
+		[]string{"a", "b"},
+		[]int{1},
+		[]testProcess{{PID: 1}},
+		&[]testProcess{},
+		[2]int{},
+	} {
+		_, err := MarshalToMap(input)
+		if err == nil || !strings.Contains(err.Error(), "use MarshalRows") {
+			t.Errorf("MarshalToMap(%T) error = %v; expected an error pointing to MarshalRows", input, err)
+		}
+	}
+}
+
+func TestMarshalToMap_dashTags(t *testing.T) {
+	input := struct {
+		Skipped  string `osquery:"-"`
+		SkipOpts string `osquery:"-,omitempty"`
+		Dash     string `osquery:"-,"`
+		Name     string `osquery:"name"`
+	}{Skipped: "a", SkipOpts: "b", Dash: "c", Name: "d"}
+
+	got, err := MarshalToMap(input)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	expected := map[string]string{"-": "c", "name": "d"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMap() = %v; expected %v", got, expected)
+	}
+}
+
+func TestMarshalToMap_nilInputs(t *testing.T) {
+	var nilMap map[string]string
+	var nilAnyMap map[string]any
+
+	tests := []struct {
+		name  string
+		input any
+		err   string
+	}{
+		{name: "untyped nil", input: nil, err: "input cannot be nil"},
+		{name: "nil struct pointer", input: (*testProcess)(nil), err: "input pointer is nil"},
+		{name: "nil map pointer", input: (*map[string]string)(nil), err: "input pointer is nil"},
+		{name: "typed nil map", input: nilMap},
+		{name: "typed nil map of any", input: nilAnyMap},
+		{name: "pointer to a nil map", input: &nilMap},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMap(tt.input)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("MarshalToMap() error = %v; expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MarshalToMap() failed: %v", err)
+			}
+			if got == nil || len(got) != 0 {
+				t.Errorf("MarshalToMap() = %#v; expected an empty non-nil row", got)
+			}
+		})
+	}
+}
+
+func TestMarshalToMapWithFlags_marshalerError(t *testing.T) {
+	_, err := MarshalToMap(&struct {
+		Network struct {
+			Range testIPRange `osquery:"range"`
+		} `osquery:"network"`
+	}{})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "network.range") || !strings.Contains(err.Error(), "incomplete range") {
+		t.Errorf("expected error to reference the field and the marshaler error, got %v", err)
+	}
+}
+
+func TestMarshalToMapWithFlags_duplicateKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		expected map[string]string
+		message  string
+	}{
+		{
+			name: "duplicate tags",
+			input: &struct {
+				Name  string `osquery:"name"`
+				Other string `osquery:"name"`
+			}{Name: "a", Other: "b"},
+			message: "fields Name and Other both resolve to column name",
+		},
+		{
+			name: "nested and dotted keys",
+			input: &struct {
+				Process testProcess `osquery:"process"`
+				PID     int         `osquery:"process.pid"`
+			}{},
+			message: "fields Process.PID and PID both resolve to column process.pid",
+		},
+		{
+			name: "ambiguous promoted fields",
+			input: &struct {
+				testHost
+				Nested struct {
+					testAgent
+					Agent testAgent `osquery:"agent"`
+					ID    string    `osquery:"agent_id"`
+					Other string    `osquery:"agent_id"`
+				} `osquery:"nested"`
+			}{},
+			message: "fields Nested.ID and Nested.Other both resolve to column nested.agent_id",
+		},
+		{
+			name: "struct in a map field",
+			input: &struct {
+				Labels map[string]any `osquery:"labels"`
+			}{Labels: map[string]any{
+				"host": struct {
+					Name  string `osquery:"name"`
+					Other string `osquery:"name"`
+				}{},
+			}},
+			message: "fields Name and Other both resolve to column name",
+		},
+		{
+			name: "shadowed promoted fields are not duplicates",
+			input: &struct {
+				testHost
+				Hostname string `osquery:"hostname"`
+			}{testHost: testHost{Hostname: "inner", OS: "linux"}, Hostname: "outer"},
+			expected: map[string]string{"hostname": "outer", "os": "linux"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMapWithFlags(tt.input, EncodingFlagErrorOnDuplicateKeys)
+			if tt.message != "" {
+				if err == nil || err.Error() != tt.message {
+					t.Errorf("MarshalToMapWithFlags() error = %v; expected %q", err, tt.message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	// Without the flag, the last field wins
+	got, err := MarshalToMap(&struct {
+		Name  string `osquery:"name"`
+		Other string `osquery:"name"`
+	}{Name: "a", Other: "b"})
+	if err != nil || got["name"] != "b" {
+		t.Errorf("MarshalToMap() = %v, %v; expected name to be b", got, err)
+	}
+}
+
+func TestMarshalToMapWithFlags_fallbackJSONTag(t *testing.T) {
+	type jsonTagged struct {
+		PID     int    `json:"pid,omitempty"`
+		Name    string `osquery:"process_name" json:"name"`
+		Path    string `osquery:",omitempty" json:"path"`
+		Secret  string `json:"-"`
+		Cmdline string
+	}
+	input := jsonTagged{PID: 42, Name: "osqueryd", Path: "/usr/bin/osqueryd", Secret: "s", Cmdline: "osqueryd -S"}
+
+	tests := []struct {
+		name     string
+		flags    EncodingFlag
+		expected map[string]string
+	}{
+		{
+			name:  "json tags are ignored by default",
+			flags: 0,
+			expected: map[string]string{
+				"PID": "42", "process_name": "osqueryd", "Path": "/usr/bin/osqueryd", "Secret": "s", "Cmdline": "osqueryd -S",
+			},
+		},
+		{
+			name:  "json tags name the fields without an osquery name",
+			flags: EncodingFlagFallbackJSONTag,
+			expected: map[string]string{
+				"pid": "42", "process_name": "osqueryd", "path": "/usr/bin/osqueryd", "Cmdline": "osqueryd -S",
+			},
+		},
+		{
+			name:  "field names without a json tag follow the flags",
+			flags: EncodingFlagFallbackJSONTag | EncodingFlagSnakeCaseKeys,
+			expected: map[string]string{
+				"pid": "42", "process_name": "osqueryd", "path": "/usr/bin/osqueryd", "cmdline": "osqueryd -S",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMapWithFlags(input, tt.flags)
+			if err != nil {
+				t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMarshalToMapWithFlags_cycles(t *testing.T) {
+	loop := &testNode{Value: 1}
+	loop.Next = &testNode{Value: 2, Next: loop}
+
+	labels := map[string]any{"env": "prod"}
+	labels["self"] = labels
+
+	type embeddedLoop struct {
+		*embeddedLoop
+		Name string
+	}
+	embedded := &embeddedLoop{Name: "loop"}
+	embedded.embeddedLoop = embedded
+
+	tests := []struct {
+		name    string
+		input   any
+		message string
+	}{
+		{
+			name:    "pointer cycle",
+			input:   loop,
+			message: "cycle detected at next.next: value of type *encoding.testNode references itself",
+		},
+		{
+			name: "map cycle",
+			input: &struct {
+				Labels map[string]any `osquery:"labels"`
+			}{Labels: labels},
+			message: "cycle detected at labels.self: value of type map[string]interface {} references itself",
+		},
+		{
+			name:    "embedded pointer cycle",
+			input:   embedded,
+			message: "cycle detected at embeddedLoop: value of type *encoding.embeddedLoop references itself",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MarshalToMap(tt.input)
+			if err == nil || err.Error() != tt.message {
+				t.Errorf("MarshalToMap() error = %v; expected %q", err, tt.message)
+			}
+		})
+	}
+
+	// A value referencing itself is reported at the field referencing it, before any other
+	// field is converted through it
+	self := &testNode{Value: 1}
+	self.Next = self
+	var keys []string
+	_, err := NewEncoder(Options{OnField: func(key string, _ bool) { keys = append(keys, key) }}).Marshal(self)
+	message := "cycle detected at next: value of type *encoding.testNode references itself"
+	if err == nil || err.Error() != message || len(keys) != 1 || keys[0] != "value" {
+		t.Errorf("Marshal() error = %v, fields = %v; expected a cycle at next", err, keys)
+	}
+
+	// Slices of structs rendered as JSON are descended into too
+	type treeNode struct {
+		Name     string      `osquery:"name"`
+		Children []*treeNode `osquery:"children"`
+	}
+	tree := &treeNode{Name: "root"}
+	tree.Children = []*treeNode{{Name: "leaf"}, tree}
+	if _, err := MarshalToMapWithFlags(tree, EncodingFlagJSONComplex); err == nil || !strings.Contains(err.Error(), "cycle detected at children.1") {
+		t.Errorf("MarshalToMapWithFlags() error = %v; expected a cycle at children.1", err)
+	}
+
+	// The same value can be referenced more than once when there is no cycle
+	shared := &testNode{Value: 3}
+	got, err := MarshalToMap(&struct {
+		First  *testNode `osquery:"first"`
+		Second *testNode `osquery:"second"`
+	}{First: shared, Second: shared})
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+	expected := map[string]string{"first.value": "3", "second.value": "3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMap() = %v; expected %v", got, expected)
+	}
+}
+
+func TestMarshalToMapWithKeyFunc(t *testing.T) {
+	type keyFuncStruct struct {
+		PID     int         `osquery:"pid"`
+		Name    string      `osquery:"name"`
+		Process testProcess `osquery:"process"`
+	}
+
+	tests := []struct {
+		name     string
+		input    any
+		keyFunc  func(string) string
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:    "prefix keys",
+			input:   &keyFuncStruct{PID: 1, Name: "init", Process: testProcess{PID: 2}},
+			keyFunc: func(key string) string { return "host_" + key },
+			expected: map[string]string{
+				"host_pid":             "1",
+				"host_name":            "init",
+				"host_process.pid":     "2",
+				"host_process.name":    "",
+				"host_process.started": "",
+			},
+		},
 		{
-			name: "time.Time type",
-			input: &struct {
-				Time time.Time `osquery:"time"`
-			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
-			flags:    0,
-			expected: map[string]string{"time": "2023-06-15T14:30:00Z"},
-			err:      false,
+			name:     "map input",
+			input:    map[string]string{"a": "1", "b": "2"},
+			keyFunc:  strings.ToUpper,
+			expected: map[string]string{"A": "1", "B": "2"},
 		},
 		{
-			name: "time.Time",
-			input: &struct {
-				Time time.Time `osquery:"time" format:"unix"`
-			}{Time: time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)},
-			flags:    0,
-			expected: map[string]string{"time": "1686839400"},
-			err:      false,
+			name:    "replace dots",
+			input:   &keyFuncStruct{PID: 1, Name: "init"},
+			keyFunc: func(key string) string { return strings.ReplaceAll(key, ".", "_") },
+			expected: map[string]string{
+				"pid":             "1",
+				"name":            "init",
+				"process_pid":     "",
+				"process_name":    "",
+				"process_started": "",
+			},
+		},
+		{
+			name:    "collision",
+			input:   &keyFuncStruct{PID: 1, Name: "init"},
+			keyFunc: func(key string) string { key, _, _ = strings.Cut(key, "."); return key },
+			err:     true,
 		},
 	}
 
 	for _, test := range tests {
-		result, err := MarshalToMapWithFlags(test.input, test.flags)
+		result, err := MarshalToMapWithKeyFunc(test.input, 0, test.keyFunc)
 		if (err != nil) != test.err {
-			t.Errorf("%s: MarshalToMapWithFlags(%v, %v) error = %v; expected error = %v", test.name, test.input, test.flags, err, test.err)
+			t.Errorf("%s: MarshalToMapWithKeyFunc() error = %v; expected error = %v", test.name, err, test.err)
 			continue
 		}
-		if !reflect.DeepEqual(result, test.expected) {
-			t.Errorf("%s: MarshalToMapWithFlags(%v, %v) = %v; expected %v", test.name, test.input, test.flags, result, test.expected)
-			continue
+		if !test.err && !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: MarshalToMapWithKeyFunc() = %v; expected %v", test.name, result, test.expected)
 		}
 	}
 }
@@ -258,6 +2330,333 @@ func tagPtr(tag string) *reflect.StructTag {
 	return &st
 }
 
+func TestMarshalToMapWithFlags_lowercaseKeys(t *testing.T) {
+	type mixedCase struct {
+		PID     int               `osquery:"PID"`
+		Name    string            `osquery:"ProcessName"`
+		Parent  int               // untagged fields use their name
+		Host    testHost          `osquery:"Host"`
+		Labels  map[string]string `osquery:"Labels"`
+		Ignored string            `osquery:"-"`
+	}
+	input := mixedCase{PID: 1, Name: "init", Labels: map[string]string{"Env": "prod"}}
+
+	got, err := MarshalToMapWithFlags(input, EncodingFlagLowercaseKeys)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+	expected := map[string]string{
+		"pid":           "1",
+		"processname":   "init",
+		"parent":        "",
+		"host.hostname": "",
+		"host.os":       "",
+		"labels.env":    "prod",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, expected)
+	}
+
+	// Keys are lowercased after the key function
+	got, err = MarshalToMapWithKeyFunc(input, EncodingFlagLowercaseKeys, func(key string) string { return "Proc_" + key })
+	if err != nil {
+		t.Fatalf("MarshalToMapWithKeyFunc() failed: %v", err)
+	}
+	if got["proc_pid"] != "1" || got["proc_labels.env"] != "prod" {
+		t.Errorf("MarshalToMapWithKeyFunc() = %v; expected lowercased prefixed keys", got)
+	}
+
+	// Keys differing only by case collide
+	_, err = MarshalToMapWithFlags(&struct {
+		Upper string `osquery:"ID"`
+		Lower string `osquery:"id"`
+	}{}, EncodingFlagLowercaseKeys)
+	if err == nil || err.Error() != "lowercasing maps both ID and id to id" {
+		t.Errorf("MarshalToMapWithFlags() error = %v; expected a collision of ID and id", err)
+	}
+	_, err = MarshalToMapWithFlags(map[string]string{"Env": "prod", "ENV": "dev"}, EncodingFlagLowercaseKeys)
+	if err == nil || !strings.Contains(err.Error(), "to env") {
+		t.Errorf("MarshalToMapWithFlags() error = %v; expected a collision of map keys", err)
+	}
+
+	// The same field set twice doesn't collide with itself
+	rows, err := MarshalRows([]mixedCase{input, input}, EncodingFlagLowercaseKeys)
+	if err != nil || len(rows) != 2 || rows[1]["pid"] != "1" {
+		t.Errorf("MarshalRows() = %v, %v; expected two rows", rows, err)
+	}
+}
+
+func TestMarshalToMapWithFlags_interfaceFields(t *testing.T) {
+	type dynamic struct {
+		Count   any `osquery:"count"`
+		Name    any `osquery:"name"`
+		Active  any `osquery:"active"`
+		Process any `osquery:"process"`
+		Parent  any `osquery:"parent"`
+		Labels  any `osquery:"labels"`
+		Args    any `osquery:"args"`
+		Missing any `osquery:"missing"`
+		Zero    any `osquery:"zero,omitempty"`
+		Level   any `osquery:"level"`
+	}
+	input := dynamic{
+		Count:   42,
+		Name:    "init",
+		Active:  true,
+		Process: testProcess{PID: 1, Name: "systemd"},
+		Parent:  &testProcess{PID: 2},
+		Labels:  map[string]any{"env": "prod"},
+		Args:    []any{"-v", 3, nil},
+		Zero:    0,
+		Level:   testLevel("warn"),
+	}
+
+	expected := map[string]string{
+		"count":           "42",
+		"name":            "init",
+		"active":          "1",
+		"process.pid":     "1",
+		"process.name":    "systemd",
+		"process.started": "",
+		"parent.pid":      "2",
+		"parent.name":     "",
+		"parent.started":  "",
+		"labels.env":      "prod",
+		"args":            "-v,3,",
+		"missing":         "",
+		"level":           "WARN",
+	}
+	// The dynamic values are converted as if the fields were declared with their type
+	for _, flags := range []EncodingFlag{0, EncodingFlagErrorOnUnsupported} {
+		got, err := MarshalToMapWithFlags(input, flags)
+		if err != nil {
+			t.Fatalf("MarshalToMapWithFlags(%d) failed: %v", flags, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("MarshalToMapWithFlags(%d) = %v; expected %v", flags, got, expected)
+		}
+	}
+
+	// Flattened dynamic values are checked for cycles like nested fields
+	type node struct {
+		Name string `osquery:"name"`
+		Next any    `osquery:"next"`
+	}
+	loop := &node{Name: "a"}
+	loop.Next = loop
+	if _, err := MarshalToMapWithFlags(loop, 0); err == nil {
+		t.Errorf("MarshalToMapWithFlags() succeeded; expected a cycle error")
+	}
+}
+
+func TestMarshalToMapWithFlags_structMaps(t *testing.T) {
+	type netInterface struct {
+		MAC string `osquery:"mac"`
+		MTU int    `osquery:"mtu"`
+	}
+	type host struct {
+		Name       string                   `osquery:"name"`
+		Interfaces map[string]netInterface  `osquery:"interfaces"`
+		Routes     map[int]*netInterface    `osquery:"routes"`
+		Empty      map[string]netInterface  `osquery:"empty"`
+		Nil        map[string]*netInterface `osquery:"nil"`
+	}
+	input := host{
+		Name: "db1",
+		Interfaces: map[string]netInterface{
+			"eth0": {MAC: "00:00:5e:00:53:01", MTU: 1500},
+			"lo":   {MTU: 65536},
+		},
+		Routes: map[int]*netInterface{0: {MAC: "00:00:5e:00:53:02"}, 1: nil},
+		Empty:  map[string]netInterface{},
+	}
+
+	// The map keys are combined with the names of the fields
+	got, err := MarshalToMapWithFlags(input, 0)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+	expected := map[string]string{
+		"name":                "db1",
+		"interfaces.eth0.mac": "00:00:5e:00:53:01",
+		"interfaces.eth0.mtu": "1500",
+		"interfaces.lo.mac":   "",
+		"interfaces.lo.mtu":   "65536",
+		"routes.0.mac":        "00:00:5e:00:53:02",
+		"routes.0.mtu":        "",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, expected)
+	}
+
+	// The values are JSON objects of their rows with EncodingFlagJSONComplex
+	got, err = MarshalToMapWithFlags(input, EncodingFlagJSONComplex)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+	expected = map[string]string{
+		"name":       "db1",
+		"interfaces": `{"eth0":{"mac":"00:00:5e:00:53:01","mtu":"1500"},"lo":{"mac":"","mtu":"65536"}}`,
+		"routes":     `{"0":{"mac":"00:00:5e:00:53:02","mtu":""},"1":null}`,
+		"empty":      "{}",
+		"nil":        "",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, expected)
+	}
+
+	// The struct and map values of top-level maps are flattened too
+	got, err = MarshalToMapWithFlags(map[string]any{
+		"eth0":   input.Interfaces["eth0"],
+		"labels": map[string]string{"env": "prod"},
+		"name":   "db1",
+	}, 0)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+	expected = map[string]string{"eth0.mac": "00:00:5e:00:53:01", "eth0.mtu": "1500", "labels.env": "prod", "name": "db1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, expected)
+	}
+
+	// Flattened keys cannot collide with the other entries
+	_, err = MarshalToMapWithFlags(map[string]any{"eth0": input.Interfaces["eth0"], "eth0.mac": "x"}, 0)
+	if err == nil || err.Error() != "duplicate key eth0.mac from flattened map field" {
+		t.Errorf("MarshalToMapWithFlags() error = %v; expected a duplicate key error", err)
+	}
+}
+
+// testHandle is a uintptr rendered by its String method.
+type testHandle uintptr
+
+func (h testHandle) String() string {
+	return fmt.Sprintf("handle-%d", uintptr(h))
+}
+
+func TestMarshalToMapWithFlags_errorOnUnsupported(t *testing.T) {
+	type unmapped struct {
+		Name string         `osquery:"name"`
+		Addr uintptr        `osquery:"addr"`
+		Grid map[[2]int]int `osquery:"grid"`
+	}
+	input := unmapped{Name: "init", Addr: 42, Grid: map[[2]int]int{{1, 2}: 3}}
+
+	// The fallback is used by default
+	got, err := MarshalToMapWithFlags(input, 0)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+	expected := map[string]string{"name": "init", "addr": "42", "grid": "map[[1 2]:3]"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, expected)
+	}
+
+	// Each unmapped field is reported with its key
+	got, err = MarshalToMapAll(input, EncodingFlagErrorOnUnsupported)
+	var marshalErr *MarshalError
+	if !errors.As(err, &marshalErr) {
+		t.Fatalf("MarshalToMapAll() error = %v; expected a MarshalError", err)
+	}
+	if !reflect.DeepEqual(got, map[string]string{"name": "init"}) {
+		t.Errorf("MarshalToMapAll() = %v; expected only the name", got)
+	}
+	for _, expected := range []string{
+		"failed to convert field addr: unsupported uintptr type: uintptr",
+		"failed to convert field grid: unsupported map type: map[[2]int]int",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("MarshalToMapAll() error = %v; expected %q", err, expected)
+		}
+	}
+
+	// Slice elements and map entries are checked too
+	if _, err := MarshalToMapWithFlags(struct {
+		Addrs []uintptr `osquery:"addrs"`
+	}{Addrs: []uintptr{1}}, EncodingFlagErrorOnUnsupported); err == nil {
+		t.Errorf("MarshalToMapWithFlags() succeeded; expected an error for the slice elements")
+	}
+	if _, err := MarshalToMapWithFlags(map[string]uintptr{"addr": 1}, EncodingFlagErrorOnUnsupported); err == nil {
+		t.Errorf("MarshalToMapWithFlags() succeeded; expected an error for the map entry")
+	}
+
+	// The interfaces still render the values of unmapped kinds
+	got, err = MarshalToMapWithFlags(struct {
+		Name   string     `osquery:"name"`
+		Handle testHandle `osquery:"handle"`
+		Sizes  []int      `osquery:"sizes"`
+	}{Name: "init", Handle: 7, Sizes: []int{1, 2}}, EncodingFlagErrorOnUnsupported)
+	if err != nil {
+		t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+	}
+	expected = map[string]string{"name": "init", "handle": "handle-7", "sizes": "1,2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, expected)
+	}
+}
+
+func Test_toSnakeCase(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "Name", expected: "name"},
+		{name: "ProcessID", expected: "process_id"},
+		{name: "ID", expected: "id"},
+		{name: "HTTPStatus", expected: "http_status"},
+		{name: "IPv4Addr", expected: "ipv4_addr"},
+		{name: "LocalIPv6", expected: "local_ipv6"},
+		{name: "HTTPv2Enabled", expected: "httpv2_enabled"},
+		{name: "OSVersion", expected: "os_version"},
+		{name: "HTTPServer2", expected: "http_server2"},
+		{name: "ParentPID", expected: "parent_pid"},
+		{name: "UserAgentString", expected: "user_agent_string"},
+		{name: "Field2Name", expected: "field2_name"},
+		{name: "already_snake", expected: "already_snake"},
+		{name: "", expected: ""},
+	}
+
+	for _, test := range tests {
+		if got := toSnakeCase(test.name); got != test.expected {
+			t.Errorf("toSnakeCase(%q) = %q; expected %q", test.name, got, test.expected)
+		}
+	}
+}
+
+func Test_truncateString(t *testing.T) {
+	tests := []struct {
+		s        string
+		maxLen   int
+		ellipsis bool
+		expected string
+	}{
+		{s: "osqueryd", maxLen: 0, expected: "osqueryd"},
+		{s: "osqueryd", maxLen: 8, expected: "osqueryd"},
+		{s: "osqueryd", maxLen: 5, expected: "osque"},
+		{s: "osqueryd", maxLen: 5, ellipsis: true, expected: "os…"},
+		{s: "osqueryd", maxLen: 8, ellipsis: true, expected: "osqueryd"},
+		{s: "osqueryd", maxLen: 2, ellipsis: true, expected: "os"},
+		// "é" is 2 bytes, "日" is 3 bytes and "🦆" is 4 bytes
+		{s: "café", maxLen: 4, expected: "caf"},
+		{s: "café", maxLen: 5, expected: "café"},
+		{s: "日本語", maxLen: 5, expected: "日"},
+		{s: "日本語", maxLen: 6, expected: "日本"},
+		{s: "日本語", maxLen: 8, ellipsis: true, expected: "日…"},
+		{s: "🦆🦆", maxLen: 7, expected: "🦆"},
+		{s: "🦆🦆", maxLen: 3, expected: ""},
+		{s: "🦆🦆", maxLen: 6, ellipsis: true, expected: "…"},
+	}
+
+	for _, test := range tests {
+		got := truncateString(test.s, test.maxLen, test.ellipsis)
+		if got != test.expected {
+			t.Errorf("truncateString(%q, %d, %v) = %q; expected %q", test.s, test.maxLen, test.ellipsis, got, test.expected)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateString(%q, %d, %v) = %q is not valid UTF-8", test.s, test.maxLen, test.ellipsis, got)
+		}
+	}
+}
+
 func Test_formatTimeWithTagFormat(t *testing.T) {
 	tests := []struct {
 		name string // description of this test case
@@ -444,6 +2843,54 @@ func Test_formatTimeWithTagFormat(t *testing.T) {
 			want:       "1686839400000000000",
 			wantErr:    false,
 		},
+		{
+			name:       "Layout option",
+			fieldValue: reflect.ValueOf(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)),
+			flag:       0,
+			tag:        tagPtr(`osquery:"created,layout=2006-01-02"`),
+			want:       "2023-06-15",
+			wantErr:    false,
+		},
+		{
+			name:       "Layout option with timezone",
+			fieldValue: reflect.ValueOf(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)),
+			flag:       0,
+			tag:        tagPtr(`osquery:"created,layout=2006-01-02 15:04 MST" tz:"Asia/Tokyo"`),
+			want:       "2023-06-15 23:30 JST",
+			wantErr:    false,
+		},
+		{
+			name:       "Layout option takes precedence over format",
+			fieldValue: reflect.ValueOf(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)),
+			flag:       0,
+			tag:        tagPtr(`osquery:"created,layout=15:04:05" format:"unix"`),
+			want:       "14:30:00",
+			wantErr:    false,
+		},
+		{
+			name:       "Layout option without layout elements is rendered literally",
+			fieldValue: reflect.ValueOf(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)),
+			flag:       0,
+			tag:        tagPtr(`osquery:"created,layout=invalid"`),
+			want:       "invalid",
+			wantErr:    false,
+		},
+		{
+			name:       "Zero time with layout option",
+			fieldValue: reflect.ValueOf(time.Time{}),
+			flag:       0,
+			tag:        tagPtr(`osquery:"created,layout=2006-01-02"`),
+			want:       "",
+			wantErr:    false,
+		},
+		{
+			name:       "No layout option uses the default format",
+			fieldValue: reflect.ValueOf(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)),
+			flag:       0,
+			tag:        tagPtr(`osquery:"created"`),
+			want:       "2023-06-15T14:30:00Z",
+			wantErr:    false,
+		},
 		{
 			name:       "Unix microseconds format",
 			fieldValue: reflect.ValueOf(time.Date(2023, 6, 15, 14, 30, 0, 0, time.UTC)),
@@ -502,6 +2949,60 @@ func TestGenerateColumnDefinitions(t *testing.T) {
 			},
 			expectedError: false,
 		},
+		{
+			name: "big numbers",
+			input: struct {
+				Int   *big.Int   `osquery:"int"`
+				Float big.Float  `osquery:"float"`
+				Text  *big.Float `osquery:"text,type=TEXT"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.BigIntColumn("int"),
+				table.DoubleColumn("float"),
+				table.TextColumn("text"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "integers in another base",
+			input: struct {
+				Mode  uint32 `osquery:"mode,base=8"`
+				Flags int64  `osquery:"flags,base=10"`
+				Raw   int64  `osquery:"raw,base=16,type=BIGINT"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.TextColumn("mode"),
+				table.BigIntColumn("flags"),
+				table.BigIntColumn("raw"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "bools rendered as text",
+			input: struct {
+				Enabled bool `osquery:"enabled,booltext"`
+				State   bool `osquery:"state,bool=on:off"`
+				Active  bool `osquery:"active"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.TextColumn("enabled"),
+				table.TextColumn("state"),
+				table.IntegerColumn("active"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "runes rendered as characters",
+			input: struct {
+				Flag rune `osquery:"flag,char"`
+				Code rune `osquery:"code"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.TextColumn("flag"),
+				table.IntegerColumn("code"),
+			},
+			expectedError: false,
+		},
 		{
 			name: "struct with skipped fields",
 			input: struct {
@@ -602,6 +3103,94 @@ func TestGenerateColumnDefinitions(t *testing.T) {
 			},
 			expectedError: false,
 		},
+		{
+			name: "nested and embedded structs",
+			input: struct {
+				testHost
+				Process testProcess  `osquery:"process"`
+				Parent  *testProcess `osquery:"parent"`
+				Name    string       `osquery:"name"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.TextColumn("hostname"),
+				table.TextColumn("os"),
+				table.IntegerColumn("process.pid"),
+				table.TextColumn("process.name"),
+				table.TextColumn("process.started"),
+				table.IntegerColumn("parent.pid"),
+				table.TextColumn("parent.name"),
+				table.TextColumn("parent.started"),
+				table.TextColumn("name"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "field shadowing a promoted field",
+			input: struct {
+				testHost
+				OS int `osquery:"os"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.TextColumn("hostname"),
+				table.IntegerColumn("os"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "special types",
+			input: struct {
+				Version  testVersion       `osquery:"version"`
+				Level    testLevel         `osquery:"level"`
+				Elapsed  time.Duration     `osquery:"elapsed"`
+				Created  time.Time         `osquery:"created" format:"unix"`
+				Modified time.Time         `osquery:"modified,layout=2006" format:"unix"`
+				Tags     []string          `osquery:"tags"`
+				Data     []byte            `osquery:"data"`
+				Labels   map[string]string `osquery:"labels"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.TextColumn("version"),
+				table.TextColumn("level"),
+				table.BigIntColumn("elapsed"),
+				table.BigIntColumn("created"),
+				table.TextColumn("modified"),
+				table.TextColumn("tags"),
+				table.TextColumn("data"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "column type overrides",
+			input: struct {
+				Raw     string    `osquery:"raw,type=BIGINT"`
+				Ratio   string    `osquery:"ratio,type=DOUBLE"`
+				Count   int64     `osquery:"count,type=TEXT"`
+				Small   float64   `osquery:"small,omitempty,type=integer"`
+				Created time.Time `osquery:"created,type=BIGINT" format:"rfc3339"`
+			}{},
+			expectedCols: []table.ColumnDefinition{
+				table.BigIntColumn("raw"),
+				table.DoubleColumn("ratio"),
+				table.TextColumn("count"),
+				table.IntegerColumn("small"),
+				table.BigIntColumn("created"),
+			},
+			expectedError: false,
+		},
+		{
+			name: "invalid column type override",
+			input: struct {
+				Raw string `osquery:"raw,type=BLOB"`
+			}{},
+			expectedCols:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "recursive type",
+			input:         testNode{},
+			expectedCols:  nil,
+			expectedError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -762,3 +3351,102 @@ func TestGenerateColumnDefinitions_unexportedFields(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateColumnDefinitions_matchesRows(t *testing.T) {
+	type options struct {
+		ProcessName string            `osquery:"process_name" ecs:"process.name"`
+		ParentPID   int               `json:"ppid"`
+		CommandLine string            `ecs:"process.command_line"`
+		Phase       complex128        `osquery:"phase"`
+		Labels      map[string]string `osquery:"labels"`
+	}
+
+	tests := []struct {
+		name  string
+		opts  Options
+		input any
+	}{
+		{
+			name:  "defaults",
+			input: &testProcess{},
+		},
+		{
+			name: "embedded and nested structs",
+			input: &struct {
+				testHost
+				*testAgent
+				Process testProcess `osquery:"process,omitempty"`
+				Count   int         `osquery:"count,string"`
+				Active  bool
+				Skipped string `osquery:"-"`
+			}{testAgent: &testAgent{}},
+		},
+		{
+			name:  "flags naming and skipping fields",
+			opts:  Options{Flags: EncodingFlagSnakeCaseKeys | EncodingFlagFallbackJSONTag | EncodingFlagSkipComplex},
+			input: &options{},
+		},
+		{
+			name:  "JSON flag",
+			opts:  Options{Flags: EncodingFlagJSONComplex | EncodingFlagSkipComplex},
+			input: &options{},
+		},
+		{
+			name:  "key tags",
+			opts:  Options{TagPriority: []string{"ecs", "osquery"}, Flags: EncodingFlagSkipComplex},
+			input: &options{},
+		},
+		{
+			name: "transformed and filtered keys",
+			opts: Options{
+				Flags:       EncodingFlagLowercaseKeys | EncodingFlagSkipComplex,
+				KeyFunc:     func(key string) string { return "proc_" + key },
+				ExcludeKeys: []string{"proc_parentpid"},
+			},
+			input: &options{},
+		},
+	}
+
+	// The columns must match the keys of the rows, when no field is omitted
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := NewEncoder(tt.opts)
+			cols, err := enc.GenerateColumnDefinitions(tt.input)
+			if err != nil {
+				t.Fatalf("GenerateColumnDefinitions(%T) failed: %v", tt.input, err)
+			}
+			row, err := enc.Marshal(tt.input)
+			if err != nil {
+				t.Fatalf("Marshal(%T) failed: %v", tt.input, err)
+			}
+
+			if len(cols) != len(row) {
+				t.Errorf("GenerateColumnDefinitions(%T) returned %d columns %v for a row with %d keys %v", tt.input, len(cols), cols, len(row), row)
+			}
+			for _, col := range cols {
+				if _, ok := row[col.Name]; !ok {
+					t.Errorf("GenerateColumnDefinitions(%T) returned column %s missing from the row %v", tt.input, col.Name, row)
+				}
+			}
+		})
+	}
+
+	// Map fields are a single TEXT column with the JSON flag
+	cols, err := NewEncoder(Options{Flags: EncodingFlagJSONComplex | EncodingFlagSkipComplex}).GenerateColumnDefinitions(options{})
+	if err != nil {
+		t.Fatalf("GenerateColumnDefinitions() failed: %v", err)
+	}
+	expected := table.TextColumn("labels")
+	if i := slices.IndexFunc(cols, func(col table.ColumnDefinition) bool { return col.Name == "labels" }); i < 0 || cols[i] != expected {
+		t.Errorf("GenerateColumnDefinitions() = %v; expected the column %v", cols, expected)
+	}
+
+	// Keys transformed to the same column are reported, as when marshaling
+	_, err = NewEncoder(Options{Flags: EncodingFlagLowercaseKeys}).GenerateColumnDefinitions(struct {
+		PID int
+		Pid int
+	}{})
+	if expectedErr := "lowercasing maps both PID and Pid to pid"; err == nil || err.Error() != expectedErr {
+		t.Errorf("GenerateColumnDefinitions() error = %v; expected %q", err, expectedErr)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package encodingtest provides helpers to test the structs converted by the encoding
+// package, kept apart so that the production code doesn't import testing.
+package encodingtest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/v7/x-pack/osquerybeat/ext/osquery-extension/pkg/encoding"
+)
+
+// AssertRoundTrip checks that the struct v, or the struct pointed to by v, survives a round
+// trip through the encoding package: it is marshaled with MarshalToMapWithFlags, the row is
+// unmarshaled with UnmarshalMapWithFlags into a new value of the same type, and both values
+// are compared. A failure is reported with t.Errorf, naming the first field that differs,
+// e.g. "Process.Args[1]", along with the row.
+//
+// Values whose type has an Equal method, like time.Time, are compared with it, so that times
+// in different locations are equal when they are the same instant. The fields that are never
+// marshaled are not compared: unexported fields, channel and function fields, and the fields
+// skipped by their tag, like `osquery:"-"` or `osquery:"-,omitempty"`. Interface fields are
+// decoded as strings, so they only survive the round trip when holding one, or nil.
+func AssertRoundTrip(t testing.TB, v any, flags encoding.EncodingFlag) {
+	t.Helper()
+
+	want := reflect.ValueOf(v)
+	if want.Kind() == reflect.Ptr && !want.IsNil() {
+		want = want.Elem()
+	}
+	if want.Kind() != reflect.Struct {
+		t.Errorf("AssertRoundTrip requires a struct or a pointer to one, got %T", v)
+		return
+	}
+
+	row, err := encoding.MarshalToMapWithFlags(v, flags)
+	if err != nil {
+		t.Errorf("round trip of %T: MarshalToMapWithFlags() failed: %v", v, err)
+		return
+	}
+	got := reflect.New(want.Type())
+	if err := encoding.UnmarshalMapWithFlags(row, got.Interface(), flags); err != nil {
+		t.Errorf("round trip of %T: UnmarshalMapWithFlags(%v) failed: %v", v, row, err)
+		return
+	}
+
+	if path, gotField, wantField, ok := firstDifference("", got.Elem(), want); ok {
+		t.Errorf("round trip of %T differs at %s: got %s, expected %s (row %v)", v, path, gotField, wantField, row)
+	}
+}
+
+// firstDifference returns the path of the first difference between got and want, and the
+// formatted values at this path, or false if they are equal. path is the path of got and
+// want in the value being compared.
+func firstDifference(path string, got, want reflect.Value) (string, string, string, bool) {
+	if !got.IsValid() || !want.IsValid() {
+		if got.IsValid() == want.IsValid() {
+			return "", "", "", false
+		}
+		return pathOrRoot(path), format(got), format(want), true
+	}
+	if equal, ok := equalMethod(got, want); ok {
+		if equal {
+			return "", "", "", false
+		}
+		return pathOrRoot(path), format(got), format(want), true
+	}
+
+	switch want.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if got.IsNil() || want.IsNil() {
+			if got.IsNil() == want.IsNil() {
+				return "", "", "", false
+			}
+			return pathOrRoot(path), format(got), format(want), true
+		}
+		return firstDifference(path, got.Elem(), want.Elem())
+
+	case reflect.Struct:
+		for i := 0; i < want.NumField(); i++ {
+			field := want.Type().Field(i)
+			if !isMarshaled(field) {
+				continue
+			}
+			fieldPath := field.Name
+			if path != "" {
+				fieldPath = path + "." + field.Name
+			}
+			if p, g, w, ok := firstDifference(fieldPath, got.Field(i), want.Field(i)); ok {
+				return p, g, w, true
+			}
+		}
+		return "", "", "", false
+
+	case reflect.Slice, reflect.Array:
+		if want.Kind() == reflect.Slice && got.IsNil() != want.IsNil() || got.Len() != want.Len() {
+			return pathOrRoot(path), format(got), format(want), true
+		}
+		for i := 0; i < want.Len(); i++ {
+			if p, g, w, ok := firstDifference(fmt.Sprintf("%s[%d]", path, i), got.Index(i), want.Index(i)); ok {
+				return p, g, w, true
+			}
+		}
+		return "", "", "", false
+
+	case reflect.Map:
+		if got.IsNil() != want.IsNil() || got.Len() != want.Len() {
+			return pathOrRoot(path), format(got), format(want), true
+		}
+		for iter := want.MapRange(); iter.Next(); {
+			k := iter.Key()
+			if p, g, w, ok := firstDifference(fmt.Sprintf("%s[%v]", path, k), got.MapIndex(k), iter.Value()); ok {
+				return p, g, w, true
+			}
+		}
+		return "", "", "", false
+
+	default:
+		if !want.CanInterface() || reflect.DeepEqual(got.Interface(), want.Interface()) {
+			return "", "", "", false
+		}
+		return pathOrRoot(path), format(got), format(want), true
+	}
+}
+
+// isMarshaled reports whether the struct field may be marshaled, and must then survive the
+// round trip.
+func isMarshaled(field reflect.StructField) bool {
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case !field.IsExported() && !(field.Anonymous && t.Kind() == reflect.Struct):
+		// Only the fields of unexported embedded structs are promoted
+		return false
+	case isSkippedTag(field.Tag.Get("osquery")):
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func:
+		return false
+	default:
+		return true
+	}
+}
+
+// isSkippedTag reports whether the "osquery" tag skips its field, as its name is "-", e.g.
+// `osquery:"-,omitempty"`, except for the exact tag `osquery:"-,"`, which names the column "-".
+func isSkippedTag(tag string) bool {
+	name, _, _ := strings.Cut(tag, ",")
+	return name == "-" && tag != "-,"
+}
+
+// equalMethod compares got and want with the Equal method of their type, like time.Time.Equal,
+// if it has one taking a value of the same type and returning a bool.
+func equalMethod(got, want reflect.Value) (equal, ok bool) {
+	if !want.CanInterface() {
+		return false, false
+	}
+	method, found := want.Type().MethodByName("Equal")
+	if !found {
+		return false, false
+	}
+	mt := method.Type
+	if mt.NumIn() != 2 || mt.In(1) != want.Type() || mt.NumOut() != 1 || mt.Out(0).Kind() != reflect.Bool {
+		return false, false
+	}
+	return method.Func.Call([]reflect.Value{want, got})[0].Bool(), true
+}
+
+// pathOrRoot returns path, or a name for the value itself when it is empty.
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "the root value"
+	}
+	return path
+}
+
+// format formats v for the failure messages, showing missing values as <invalid>.
+func format(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<invalid>"
+	}
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return "&" + format(v.Elem())
+	}
+	if v.CanInterface() {
+		return fmt.Sprintf("%#v", v.Interface())
+	}
+	return fmt.Sprintf("%#v", v)
+}
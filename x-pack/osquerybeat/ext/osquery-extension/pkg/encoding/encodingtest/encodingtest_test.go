@@ -0,0 +1,138 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encodingtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/v7/x-pack/osquerybeat/ext/osquery-extension/pkg/encoding"
+)
+
+// recorder records the failures reported by AssertRoundTrip.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+type testProcess struct {
+	PID  int      `osquery:"pid"`
+	Name string   `osquery:"name"`
+	Args []string `osquery:"args"`
+}
+
+type testRow struct {
+	Host     string       `osquery:"host"`
+	Process  testProcess  `osquery:"process"`
+	Parent   *testProcess `osquery:"parent"`
+	Started  time.Time    `osquery:"started"`
+	Active   bool         `osquery:"active"`
+	Ignored  string       `osquery:"-"`
+	Callback func()       `osquery:"callback"`
+	internal int
+}
+
+// testDash has a skipped field with options, and a field named "-".
+type testDash struct {
+	Skipped string `osquery:"-,omitempty"`
+	Dash    string `osquery:"-,"`
+}
+
+// testLossy loses the precision of its duration, which is rendered in whole seconds.
+type testLossy struct {
+	Name    string        `osquery:"name"`
+	Elapsed time.Duration `osquery:"elapsed,duration=s"`
+}
+
+// testAny has an interface field, decoded as the string its value was rendered as.
+type testAny struct {
+	Name  string `osquery:"name"`
+	Value any    `osquery:"value"`
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		name  string
+		input any
+		flags encoding.EncodingFlag
+		err   string
+	}{
+		{
+			name: "struct",
+			input: testRow{
+				Host:     "db1",
+				Process:  testProcess{PID: 42, Name: "sshd", Args: []string{"-D", "-p", "22"}},
+				Parent:   &testProcess{PID: 1, Name: "init"},
+				Started:  started,
+				Active:   true,
+				Ignored:  "not marshaled",
+				Callback: func() {},
+				internal: 1,
+			},
+		},
+		{
+			name:  "pointer with flags",
+			input: &testRow{Process: testProcess{Args: []string{"a,b"}}},
+			flags: encoding.EncodingFlagJSONSlices | encoding.EncodingFlagUseNumbersZeroValues,
+		},
+		{
+			name:  "skipped field with options",
+			input: testDash{Skipped: "not marshaled", Dash: "-"},
+		},
+		{
+			name:  "interface field",
+			input: testAny{Name: "label", Value: "prod"},
+		},
+		{
+			name:  "nil interface field",
+			input: testAny{Name: "label"},
+		},
+		{
+			name:  "lossy interface field",
+			input: testAny{Name: "count", Value: 5},
+			err:   `round trip of encodingtest.testAny differs at Value: got "5", expected 5`,
+		},
+		{
+			name:  "lossy field",
+			input: testLossy{Name: "job", Elapsed: 1500 * time.Millisecond},
+			err:   "round trip of encodingtest.testLossy differs at Elapsed: got 1000000000, expected 1500000000",
+		},
+		{
+			name:  "lossy element",
+			input: testRow{Process: testProcess{Args: []string{"a,b", "c"}}},
+			err:   `round trip of encodingtest.testRow differs at Process.Args: got []string{"a", "b", "c"}, expected []string{"a,b", "c"}`,
+		},
+		{
+			name:  "marshal error",
+			input: struct{ Value complex64 }{},
+			err:   "MarshalToMapWithFlags() failed: failed to convert field Value: unsupported complex type: complex64",
+		},
+		{
+			name:  "not a struct",
+			input: map[string]string{},
+			err:   "AssertRoundTrip requires a struct or a pointer to one, got map[string]string",
+		},
+	}
+
+	for _, test := range tests {
+		r := &recorder{}
+		AssertRoundTrip(r, test.input, test.flags)
+		switch {
+		case test.err == "" && len(r.errors) > 0:
+			t.Errorf("%s: AssertRoundTrip() failed: %v", test.name, r.errors)
+		case test.err != "" && (len(r.errors) != 1 || !strings.Contains(r.errors[0], test.err)):
+			t.Errorf("%s: AssertRoundTrip() errors = %q; expected %q", test.name, r.errors, test.err)
+		}
+	}
+}
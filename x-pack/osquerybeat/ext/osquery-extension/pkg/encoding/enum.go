@@ -0,0 +1,97 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// enumNames holds the names registered for an integer type, in both directions.
+type enumNames struct {
+	names  map[int64]string
+	values map[string]int64
+}
+
+// enums maps a reflect.Type to the *enumNames registered with RegisterEnum.
+var enums sync.Map
+
+// RegisterEnum registers the names used to render the values of the integer type of
+// example, e.g. RegisterEnum(State(0), map[int64]string{0: "stopped", 1: "running"}).
+// Fields of the registered type are rendered as the name of their value, or as numbers for
+// values without a name, and UnmarshalMap decodes the names back to the values. Columns of
+// registered types are TEXT.
+//
+// RegisterEnum is meant to be called from init functions, registering a type again replaces
+// its names. It panics if example is not of an integer kind, or if two values share a name.
+func RegisterEnum(example any, names map[int64]string) {
+	t := reflect.TypeOf(example)
+	if t == nil || !isIntegerKind(t.Kind()) {
+		panic(fmt.Sprintf("encoding: RegisterEnum requires an integer type, got %v", t))
+	}
+
+	enum := &enumNames{
+		names:  make(map[int64]string, len(names)),
+		values: make(map[string]int64, len(names)),
+	}
+	for value, name := range names {
+		if other, ok := enum.values[name]; ok {
+			panic(fmt.Sprintf("encoding: RegisterEnum for %s maps both %d and %d to %q", t, other, value, name))
+		}
+		enum.names[value] = name
+		enum.values[name] = value
+	}
+	enums.Store(t, enum)
+}
+
+// lookupEnum returns the names registered for the type t, if any.
+func lookupEnum(t reflect.Type) (*enumNames, bool) {
+	if !isIntegerKind(t.Kind()) {
+		return nil, false
+	}
+	enum, ok := enums.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return enum.(*enumNames), true
+}
+
+// name returns the name registered for the integer value v.
+func (e *enumNames) name(v reflect.Value) (string, bool) {
+	var value int64
+	if v.CanInt() {
+		value = v.Int()
+	} else {
+		value = int64(v.Uint())
+	}
+	name, ok := e.names[value]
+	return name, ok
+}
+
+// set stores in v the value registered for name, and reports whether there is one.
+func (e *enumNames) set(v reflect.Value, name string) bool {
+	value, ok := e.values[name]
+	if !ok {
+		return false
+	}
+	if v.CanInt() {
+		v.SetInt(value)
+	} else {
+		v.SetUint(uint64(value))
+	}
+	return true
+}
+
+// isIntegerKind reports whether kind is one of the signed or unsigned integer kinds.
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
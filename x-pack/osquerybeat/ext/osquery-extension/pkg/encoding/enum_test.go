@@ -0,0 +1,142 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+type testServiceState int
+
+const (
+	testServiceStopped testServiceState = iota
+	testServiceStarting
+	testServiceRunning
+)
+
+type testPriority uint8
+
+func init() {
+	RegisterEnum(testServiceStopped, map[int64]string{
+		int64(testServiceStopped):  "stopped",
+		int64(testServiceStarting): "starting",
+		int64(testServiceRunning):  "running",
+	})
+	RegisterEnum(testPriority(0), map[int64]string{1: "low", 2: "high"})
+}
+
+type testService struct {
+	State    testServiceState   `osquery:"state"`
+	Priority testPriority       `osquery:"priority"`
+	Previous *testServiceState  `osquery:"previous"`
+	States   []testServiceState `osquery:"states"`
+}
+
+func TestRegisterEnum(t *testing.T) {
+	starting := testServiceStarting
+
+	tests := []struct {
+		name     string
+		input    testService
+		flags    EncodingFlag
+		expected map[string]string
+	}{
+		{
+			name: "registered values are rendered as names",
+			input: testService{
+				State:    testServiceRunning,
+				Priority: 2,
+				Previous: &starting,
+				States:   []testServiceState{testServiceStopped, testServiceRunning},
+			},
+			expected: map[string]string{
+				"state":    "running",
+				"priority": "high",
+				"previous": "starting",
+				"states":   "stopped,running",
+			},
+		},
+		{
+			name:  "values without a name are rendered as numbers",
+			input: testService{State: 7},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"state":    "7",
+				"priority": "0",
+				"previous": "",
+				"states":   "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMapWithFlags(tt.input, tt.flags)
+			if err != nil {
+				t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegisterEnum_unmarshal(t *testing.T) {
+	var out testService
+	in := map[string]string{"state": "running", "priority": "1", "previous": "starting"}
+	if err := UnmarshalMap(in, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	if out.State != testServiceRunning || out.Priority != 1 || out.Previous == nil || *out.Previous != testServiceStarting {
+		t.Errorf("UnmarshalMap() = %+v", out)
+	}
+
+	if err := UnmarshalMap(map[string]string{"state": "crashed"}, &out); err == nil {
+		t.Errorf("UnmarshalMap() with an unknown name succeeded; expected an error")
+	}
+}
+
+func TestRegisterEnum_columns(t *testing.T) {
+	got, err := GenerateColumnDefinitions(testService{})
+	if err != nil {
+		t.Fatalf("GenerateColumnDefinitions() failed: %v", err)
+	}
+	expected := []table.ColumnDefinition{
+		table.TextColumn("state"),
+		table.TextColumn("priority"),
+		table.TextColumn("previous"),
+		table.TextColumn("states"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("GenerateColumnDefinitions() = %v; expected %v", got, expected)
+	}
+}
+
+func TestRegisterEnum_panics(t *testing.T) {
+	tests := []struct {
+		name    string
+		example any
+		names   map[int64]string
+	}{
+		{name: "non-integer type", example: "running", names: map[int64]string{0: "a"}},
+		{name: "nil example", example: nil, names: map[int64]string{0: "a"}},
+		{name: "duplicate names", example: int8(0), names: map[int64]string{0: "a", 1: "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterEnum() did not panic")
+				}
+			}()
+			RegisterEnum(tt.example, tt.names)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import "fmt"
+
+// MarshalError is returned when the value of a field cannot be converted.
+type MarshalError struct {
+	// Field is the key of the field in the row, e.g. "process.args".
+	Field string
+	// Path holds the names of the nested fields and map entries leading to the field, e.g.
+	// ["process", "args"]. Promoted fields of embedded structs are named directly.
+	Path []string
+	// Err is the conversion error.
+	Err error
+}
+
+func (e *MarshalError) Error() string {
+	return fmt.Sprintf("failed to convert field %s: %v", e.Field, e.Err)
+}
+
+func (e *MarshalError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,249 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var errTestMarshal = errors.New("test marshal error")
+
+// testFailing always fails to marshal.
+type testFailing struct{}
+
+func (testFailing) MarshalOsquery() (string, error) {
+	return "", errTestMarshal
+}
+
+// testPanicking panics when marshaled, like a method dereferencing a nil field.
+type testPanicking struct {
+	values map[string]*int
+}
+
+func (p testPanicking) MarshalOsquery() (string, error) {
+	return strconv.Itoa(*p.values["missing"]), nil
+}
+
+// testReflecting panics when marshaled, as it reads its unexported field through
+// reflection.
+type testReflecting struct {
+	secret string
+}
+
+func (r testReflecting) MarshalOsquery() (string, error) {
+	return reflect.ValueOf(r).Field(0).Interface().(string), nil
+}
+
+// testErrorHost is embedded to check the path of promoted fields.
+type testErrorHost struct {
+	Failing testFailing `osquery:"failing"`
+}
+
+func TestMarshalError(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   any
+		field   string
+		path    []string
+		message string
+	}{
+		{
+			name:    "top-level field",
+			input:   &struct{ Value testFailing }{},
+			field:   "Value",
+			path:    []string{"Value"},
+			message: "failed to convert field Value: test marshal error",
+		},
+		{
+			name: "nested field",
+			input: &struct {
+				Process struct {
+					Args testFailing `osquery:"args"`
+				} `osquery:"process"`
+			}{},
+			field:   "process.args",
+			path:    []string{"process", "args"},
+			message: "failed to convert field process.args: test marshal error",
+		},
+		{
+			name: "promoted field",
+			input: &struct {
+				testHost
+				Nested struct {
+					testErrorHost
+				} `osquery:"nested"`
+			}{},
+			field: "nested.failing",
+			path:  []string{"nested", "failing"},
+		},
+		{
+			name: "map field entry",
+			input: &struct {
+				Labels map[string]any `osquery:"labels"`
+			}{Labels: map[string]any{"owner": map[string]any{"team": testFailing{}}}},
+			field: "labels.owner.team",
+			path:  []string{"labels", "owner", "team"},
+		},
+		{
+			name:  "map input",
+			input: map[string]any{"value": testFailing{}},
+			field: "value",
+			path:  []string{"value"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := MarshalToMap(test.input)
+
+		var marshalErr *MarshalError
+		if !errors.As(err, &marshalErr) {
+			t.Errorf("%s: MarshalToMap() error = %v; expected a MarshalError", test.name, err)
+			continue
+		}
+		if marshalErr.Field != test.field || !reflect.DeepEqual(marshalErr.Path, test.path) {
+			t.Errorf("%s: MarshalError field = %s, path = %q; expected %s, %q", test.name, marshalErr.Field, marshalErr.Path, test.field, test.path)
+		}
+		if !errors.Is(err, errTestMarshal) {
+			t.Errorf("%s: MarshalToMap() error = %v; expected it to wrap the marshaler error", test.name, err)
+		}
+		if test.message != "" && err.Error() != test.message {
+			t.Errorf("%s: MarshalToMap() error message = %q; expected %q", test.name, err.Error(), test.message)
+		}
+	}
+}
+
+func TestMarshalError_rows(t *testing.T) {
+	_, err := MarshalRows([]any{&testHost{}, &struct{ Value testFailing }{}}, 0)
+
+	var marshalErr *MarshalError
+	if !errors.As(err, &marshalErr) || marshalErr.Field != "Value" {
+		t.Errorf("MarshalRows() error = %v; expected a MarshalError for field Value", err)
+	}
+}
+
+func TestMarshalToMapAll(t *testing.T) {
+	input := &struct {
+		Name    string      `osquery:"name"`
+		First   testFailing `osquery:"first"`
+		Process struct {
+			PID   int         `osquery:"pid"`
+			Range testIPRange `osquery:"range"`
+		} `osquery:"process"`
+	}{Name: "test"}
+	input.Process.PID = 1
+
+	result, err := MarshalToMapAll(input, 0)
+	expected := map[string]string{"name": "test", "process.pid": "1"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("MarshalToMapAll() = %v; expected %v", result, expected)
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("MarshalToMapAll() error = %v; expected joined errors", err)
+	}
+	errs := joined.Unwrap()
+	if len(errs) != 2 {
+		t.Fatalf("MarshalToMapAll() returned %d errors; expected 2: %v", len(errs), err)
+	}
+	for i, field := range []string{"first", "process.range"} {
+		var marshalErr *MarshalError
+		if !errors.As(errs[i], &marshalErr) || marshalErr.Field != field {
+			t.Errorf("MarshalToMapAll() error %d = %v; expected a MarshalError for field %s", i, errs[i], field)
+		}
+	}
+
+	// Without failures, no error is returned
+	if _, err := MarshalToMapAll(&testHost{}, 0); err != nil {
+		t.Errorf("MarshalToMapAll() failed: %v", err)
+	}
+	if _, err := MarshalToMapAll(nil, 0); err == nil {
+		t.Error("expected error for nil input, got nil")
+	}
+}
+
+func TestMarshalError_complex(t *testing.T) {
+	_, err := MarshalToMap(&struct {
+		Process struct {
+			Phase complex64 `osquery:"phase"`
+		} `osquery:"process"`
+	}{})
+
+	var marshalErr *MarshalError
+	if !errors.As(err, &marshalErr) {
+		t.Fatalf("MarshalToMap() error = %v; expected a *MarshalError", err)
+	}
+	expected := "failed to convert field process.phase: unsupported complex type: complex64"
+	if marshalErr.Field != "process.phase" || err.Error() != expected {
+		t.Errorf("MarshalToMap() error = %v; expected %q", err, expected)
+	}
+}
+
+func TestMarshalError_panics(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   any
+		field   string
+		path    []string
+		message string
+	}{
+		{
+			name: "panicking marshaler",
+			input: &struct {
+				Process struct {
+					State testPanicking `osquery:"state"`
+				} `osquery:"process"`
+			}{},
+			field: "process.state",
+			path:  []string{"process", "state"},
+		},
+		{
+			name: "unexported field read through reflection",
+			input: &struct {
+				Name   string         `osquery:"name"`
+				Secret testReflecting `osquery:"secret"`
+			}{Name: "init"},
+			field:   "secret",
+			path:    []string{"secret"},
+			message: "failed to convert field secret: panic: reflect.Value.Interface: cannot return value obtained from unexported field or method",
+		},
+	}
+
+	for _, test := range tests {
+		result, err := MarshalToMap(test.input)
+
+		var marshalErr *MarshalError
+		if !errors.As(err, &marshalErr) {
+			t.Errorf("%s: MarshalToMap() = %v, %v; expected a MarshalError", test.name, result, err)
+			continue
+		}
+		if result != nil {
+			t.Errorf("%s: MarshalToMap() = %v; expected no result", test.name, result)
+		}
+		if marshalErr.Field != test.field || !reflect.DeepEqual(marshalErr.Path, test.path) {
+			t.Errorf("%s: MarshalError field = %s, path = %q; expected %s, %q", test.name, marshalErr.Field, marshalErr.Path, test.field, test.path)
+		}
+		if test.message != "" && err.Error() != test.message {
+			t.Errorf("%s: MarshalToMap() error message = %q; expected %q", test.name, err.Error(), test.message)
+		}
+	}
+
+	// The errors collected and the keys converted before the panic are kept
+	result, err := MarshalToMapAll(&struct {
+		Name  string        `osquery:"name"`
+		First testFailing   `osquery:"first"`
+		State testPanicking `osquery:"state"`
+	}{Name: "init"}, 0)
+	if !errors.Is(err, errTestMarshal) || !strings.Contains(err.Error(), "failed to convert field state: panic: ") {
+		t.Errorf("MarshalToMapAll() error = %v; expected the failure of first and the panic of state", err)
+	}
+	if expected := map[string]string{"name": "init"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("MarshalToMapAll() = %v; expected %v", result, expected)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// fieldKind tells how the value of a struct field is marshaled.
+type fieldKind int
+
+const (
+	// fieldValue is converted into a single column
+	fieldValue fieldKind = iota
+	// fieldNested is a struct whose fields are stored under dotted keys
+	fieldNested
+	// fieldPromoted is an embedded struct whose fields are promoted to the parent keys
+	fieldPromoted
+	// fieldMap is a map whose entries are stored under dotted keys
+	fieldMap
+)
+
+// fieldInfo holds the metadata of a struct field resolved from its type and tags.
+type fieldInfo struct {
+	index int
+	name  string
+	kind  fieldKind
+	// key is the column name, without the prefix of the parent struct. It is empty for
+	// promoted fields.
+	key string
+	tag reflect.StructTag
+	// flags are the flags enabled by the tag options, like "string"
+	flags   EncodingFlag
+	options fieldOptions
+	value   valueOptions
+	// text tells whether the values of the field may be truncated
+	text textMode
+	// plain tells whether the values of the field are converted by convertKind alone, as
+	// resolved by isPlainType
+	plain  bool
+	inline bool
+}
+
+// untaggedField is the metadata of the values stored outside of struct fields, like the
+// entries of top-level maps, which are converted without tag options.
+var untaggedField = fieldInfo{value: parseValueOptions(nil)}
+
+// fieldOptions holds the tag options applied to the converted value of a field.
+type fieldOptions struct {
+	omitEmpty    bool
+	defaultValue string
+	hasDefault   bool
+	redact       bool
+	// maxLen is the maximum length of the value, or 0 to use Options.DefaultMaxLen
+	maxLen   int
+	ellipsis bool
+}
+
+// parseFieldOptions returns the options of the tag applied by applyTagOptions.
+func parseFieldOptions(tag *reflect.StructTag) fieldOptions {
+	def, hasDefault := lookupTagOption(tag, "default")
+	// Invalid lengths are reported by Validate
+	maxLen, _ := maxLength(tag)
+	return fieldOptions{
+		omitEmpty:    hasTagOption(tag, "omitempty"),
+		defaultValue: def,
+		hasDefault:   hasDefault,
+		redact:       hasTagOption(tag, "redact"),
+		maxLen:       maxLen,
+		ellipsis:     hasTagOption(tag, "ellipsis"),
+	}
+}
+
+// valueOptions holds the tag options changing how a value is converted, resolved with the
+// fields of its struct rather than for each conversion. The errors of invalid options are
+// kept, and returned when converting a value they apply to.
+type valueOptions struct {
+	// base is set by the "base" option, and prefix is its prefix with the "prefix" option
+	base    int
+	prefix  string
+	baseErr error
+	char    bool
+	// prec is set by the "prec" option, or -1 for the smallest number of digits
+	prec    int
+	precErr error
+	// trueValue and falseValue are set by the "bool" option when customBool is true
+	trueValue  string
+	falseValue string
+	customBool bool
+	boolErr    error
+	// sep is set by the "sep" option when hasSep is true
+	sep         string
+	hasSep      bool
+	bytesAsNums bool
+	// timeFormat is set by the "layout" option or the "format" tag when hasTimeFormat is
+	// true, and loc by the "tz" tag
+	timeFormat    timeFormat
+	hasTimeFormat bool
+	timeErr       error
+	loc           *time.Location
+	locErr        error
+	durationUnit  time.Duration
+	durationErr   error
+}
+
+// parseValueOptions returns the options of the tag used by convertValueToString. A nil tag
+// sets no option.
+func parseValueOptions(tag *reflect.StructTag) valueOptions {
+	var opts valueOptions
+	opts.base, opts.prefix, opts.baseErr = integerBase(tag)
+	opts.char = hasTagOption(tag, "char")
+	opts.prec, opts.precErr = floatPrecision(tag)
+	opts.trueValue, opts.falseValue, opts.customBool, opts.boolErr = boolStrings(tag)
+	opts.sep, opts.hasSep = lookupTagOption(tag, "sep")
+	opts.bytesAsNums = hasTagOption(tag, "bytesasnums")
+	opts.timeFormat, opts.hasTimeFormat, opts.timeErr = tagTimeFormat(tag)
+	opts.loc, opts.locErr = timeLocation(tag)
+	opts.durationUnit, opts.durationErr = durationUnit(tag)
+	return opts
+}
+
+// fieldCacheKey identifies the fields of a struct type resolved with the flags that change
+// how fields are resolved, and the tag holding their names.
+type fieldCacheKey struct {
+	t       reflect.Type
+	flags   EncodingFlag
+	keyTags string
+}
+
+// fieldCacheFlags are the flags that change the result of structFields.
+const fieldCacheFlags = EncodingFlagSnakeCaseKeys | EncodingFlagJSONComplex | EncodingFlagSkipComplex |
+	EncodingFlagFallbackJSONTag
+
+// fieldCache maps a fieldCacheKey to the []fieldInfo of the struct type.
+var fieldCache sync.Map
+
+// structFields returns the metadata of the fields of the struct type t that are marshaled,
+// with the promoted fields first. The result is cached and must not be modified.
+func structFields(t reflect.Type, flags EncodingFlag, keyTags string) []fieldInfo {
+	cacheKey := fieldCacheKey{t: t, flags: flags & fieldCacheFlags, keyTags: keyTags}
+	if fields, ok := fieldCache.Load(cacheKey); ok {
+		return fields.([]fieldInfo)
+	}
+
+	var promoted, fields []fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isPromoted(field, flags, keyTags) {
+			promoted = append(promoted, fieldInfo{index: i, name: field.Name, kind: fieldPromoted})
+			continue
+		}
+
+		key, ok := fieldKey(field, flags, keyTags)
+		if !ok {
+			continue
+		}
+
+		info := fieldInfo{
+			index:   i,
+			name:    field.Name,
+			key:     key,
+			tag:     field.Tag,
+			flags:   fieldFlags(0, &field.Tag),
+			options: parseFieldOptions(&field.Tag),
+			value:   parseValueOptions(&field.Tag),
+			text:    typeTextMode(field.Type),
+			plain:   isPlainType(field.Type),
+		}
+		switch {
+		case isNestedStruct(field.Type):
+			info.kind = fieldNested
+		case isFlattenedMap(field.Type, flags):
+			info.kind = fieldMap
+			info.inline = hasTagOption(&field.Tag, "inline") || hasTagOption(&field.Tag, "remaining")
+		}
+		fields = append(fields, info)
+	}
+
+	fields = append(promoted, fields...)
+	actual, _ := fieldCache.LoadOrStore(cacheKey, fields)
+	return actual.([]fieldInfo)
+}
+
+// keyOwner records the field that produced a key, and how many embedded structs it was
+// promoted through.
+type keyOwner struct {
+	field string
+	depth int
+}
+
+// keyOwners maps keys to the fields producing them, to detect fields resolving to the same
+// key. Fields are expected in the order of structFields, with promoted fields first.
+type keyOwners map[string]keyOwner
+
+// claim records that field, promoted through depth embedded structs, produces key. Like in
+// Go, a field shadows the ones promoted through more embedded structs, but an error is
+// returned if key is already produced by a field at the same depth.
+func (o keyOwners) claim(key, field string, depth int) error {
+	owner, ok := o[key]
+	if ok && owner.depth == depth {
+		return fmt.Errorf("fields %s and %s both resolve to column %s", owner.field, field, key)
+	}
+	if !ok || depth < owner.depth {
+		o[key] = keyOwner{field: field, depth: depth}
+	}
+	return nil
+}
+
+// duplicateKeyCache maps a fieldCacheKey to the error returned by checkDuplicateKeys.
+var duplicateKeyCache sync.Map
+
+// checkDuplicateKeys returns an error if fields of the struct type t, or of the structs
+// nested in it, resolve to the same key, as reported by duplicateKeys. The result is cached.
+func checkDuplicateKeys(t reflect.Type, flags EncodingFlag, keyTags string) error {
+	cacheKey := fieldCacheKey{t: t, flags: flags & fieldCacheFlags, keyTags: keyTags}
+	if err, ok := duplicateKeyCache.Load(cacheKey); ok {
+		err, _ := err.(error)
+		return err
+	}
+	err := errors.Join(duplicateKeys(t, flags, keyTags)...)
+	duplicateKeyCache.Store(cacheKey, err)
+	return err
+}
+
+// duplicateKeys returns an error for each field of the struct type t, or of the structs
+// nested in it, resolving to the same key as a previous field at the same promotion depth.
+// The keys of map fields are only known at runtime, and are not considered.
+func duplicateKeys(t reflect.Type, flags EncodingFlag, keyTags string) []error {
+	var errs []error
+	appendDuplicateKeys(&errs, t, flags, keyTags, "", "", 0, make(keyOwners), make(map[reflect.Type]bool))
+	return errs
+}
+
+// appendDuplicateKeys claims the keys of the fields of the struct type t, stored under
+// prefix, and appends the errors to errs. path is the Go path of the struct, e.g. "Process.",
+// and depth the number of embedded structs it was promoted through.
+func appendDuplicateKeys(errs *[]error, t reflect.Type, flags EncodingFlag, keyTags, prefix, path string, depth int, owners keyOwners, parents map[reflect.Type]bool) {
+	// Recursive types are reported separately by Validate
+	if parents[t] {
+		return
+	}
+	parents[t] = true
+	defer delete(parents, t)
+
+	for _, field := range structFields(t, flags, keyTags) {
+		fieldType := t.Field(field.index).Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		fieldPath := path + field.name
+		key := prefix + field.key
+
+		switch field.kind {
+		case fieldPromoted:
+			appendDuplicateKeys(errs, fieldType, flags, keyTags, prefix, fieldPath+".", depth+1, owners, parents)
+			continue
+		case fieldNested:
+			appendDuplicateKeys(errs, fieldType, flags, keyTags, key+".", fieldPath+".", 0, owners, parents)
+			continue
+		case fieldMap:
+			continue
+		}
+
+		if err := owners.claim(key, fieldPath, depth); err != nil {
+			*errs = append(*errs, err)
+		}
+	}
+}
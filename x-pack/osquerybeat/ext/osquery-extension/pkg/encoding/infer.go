@@ -0,0 +1,111 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"math"
+	"strconv"
+)
+
+// DecodeToMap converts a map[string]string, such as an osquery row, into a map[string]any
+// whose values are typed according to their text, for rows without a target struct. The
+// inference is conservative, so that values are only typed when their text is the one the
+// encoder would write for them:
+//   - "true" and "false" are bools. Bools rendered by the encoder as "1" and "0" are ints.
+//   - decimal integers fitting in an int64 are int64s, e.g. "42" or "-7". Leading zeros and
+//     signs are kept as strings, e.g. "01" or "+1", as they are usually identifiers.
+//   - decimal numbers with a fraction or an exponent are float64s, e.g. "1.5" or "1e-3",
+//     under the same rules for their integer part. "NaN", "Inf" and values overflowing a
+//     float64 are kept as strings.
+//   - all the other values are kept as strings, including empty ones.
+func DecodeToMap(in map[string]string) map[string]any {
+	out := make(map[string]any, len(in))
+	for key, value := range in {
+		out[key] = inferValue(value)
+	}
+	return out
+}
+
+// inferValue returns s converted to the type inferred by DecodeToMap.
+func inferValue(s string) any {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	digits, frac, exp := scanNumber(s)
+	if digits == 0 || digits+frac+exp != len(s) {
+		return s
+	}
+	if frac == 0 && exp == 0 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		return s
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return s
+	}
+	return f
+}
+
+// scanNumber returns the lengths of the integer part of the decimal number at the start of s,
+// including its minus sign, of its fraction, including the dot, and of its exponent. The
+// integer part is 0 when s doesn't start with a number, or when it has leading zeros.
+func scanNumber(s string) (digits, frac, exp int) {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	start := i
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	if i == start || (s[start] == '0' && i-start > 1) {
+		return 0, 0, 0
+	}
+	digits = i
+
+	if i < len(s) && s[i] == '.' {
+		j := i + 1
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			return 0, 0, 0
+		}
+		frac, i = j-i, j
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		expStart := j
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		if j == expStart {
+			return 0, 0, 0
+		}
+		exp = j - i
+	}
+	return digits, frac, exp
+}
+
+// isJSONNumber reports whether s is a number in the JSON syntax, e.g. "-1.5e3".
+func isJSONNumber(s string) bool {
+	digits, frac, exp := scanNumber(s)
+	return digits > 0 && digits+frac+exp == len(s)
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,61 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeToMap(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected any
+	}{
+		{value: "", expected: ""},
+		{value: "osqueryd", expected: "osqueryd"},
+		{value: "true", expected: true},
+		{value: "false", expected: false},
+		{value: "True", expected: "True"},
+		{value: "1", expected: int64(1)},
+		{value: "0", expected: int64(0)},
+		{value: "42", expected: int64(42)},
+		{value: "-7", expected: int64(-7)},
+		{value: "9223372036854775807", expected: int64(9223372036854775807)},
+		{value: "9223372036854775808", expected: "9223372036854775808"},
+		{value: "01", expected: "01"},
+		{value: "-01", expected: "-01"},
+		{value: "+1", expected: "+1"},
+		{value: "-", expected: "-"},
+		{value: "0x1a", expected: "0x1a"},
+		{value: "1_000", expected: "1_000"},
+		{value: " 1", expected: " 1"},
+		{value: "1.5", expected: 1.5},
+		{value: "-0.25", expected: -0.25},
+		{value: "0.0", expected: 0.0},
+		{value: "1e-3", expected: 1e-3},
+		{value: "2.5E+10", expected: 2.5e10},
+		{value: "1e400", expected: "1e400"},
+		{value: ".5", expected: ".5"},
+		{value: "1.", expected: "1."},
+		{value: "01.5", expected: "01.5"},
+		{value: "1e", expected: "1e"},
+		{value: "NaN", expected: "NaN"},
+		{value: "Inf", expected: "Inf"},
+		{value: "10.0.0.1", expected: "10.0.0.1"},
+		{value: "2024-01-02", expected: "2024-01-02"},
+	}
+
+	for _, tt := range tests {
+		got := DecodeToMap(map[string]string{"value": tt.value})
+		if !reflect.DeepEqual(got["value"], tt.expected) {
+			t.Errorf("DecodeToMap(%q) = %#v; expected %#v", tt.value, got["value"], tt.expected)
+		}
+	}
+
+	if got := DecodeToMap(nil); got == nil || len(got) != 0 {
+		t.Errorf("DecodeToMap(nil) = %#v; expected an empty map", got)
+	}
+}
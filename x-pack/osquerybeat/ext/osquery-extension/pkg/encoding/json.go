@@ -0,0 +1,29 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import "encoding/json"
+
+// MarshalToJSON converts in into a row as documented in MarshalToMap, and returns the row as
+// a JSON object of strings with sorted keys, e.g. {"name":"bash","pid":"42"}. It's the exact
+// row handed to osquery, which is useful for logging.
+func MarshalToJSON(in any, flags EncodingFlag) ([]byte, error) {
+	row, err := MarshalToMapWithFlags(in, flags)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(row)
+}
+
+// MarshalRowsToJSON converts the elements of a slice or array into rows as documented in
+// MarshalRows, and returns them as a JSON array of objects like the ones of MarshalToJSON.
+// Nil and empty slices produce an empty array.
+func MarshalRowsToJSON(in any, flags EncodingFlag) ([]byte, error) {
+	rows, err := MarshalRows(in, flags)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rows)
+}
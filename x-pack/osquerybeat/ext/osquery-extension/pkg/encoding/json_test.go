@@ -0,0 +1,88 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type jsonTestProcess struct {
+	PID     int       `osquery:"pid"`
+	Name    string    `osquery:"name"`
+	Active  bool      `osquery:"active"`
+	Started time.Time `osquery:"started" format:"unix"`
+	Parent  *int      `osquery:"parent"`
+}
+
+func TestMarshalToJSON(t *testing.T) {
+	in := jsonTestProcess{PID: 42, Name: `say "hi"`, Active: true, Started: time.Unix(1700000000, 0)}
+
+	got, err := MarshalToJSON(in, 0)
+	if err != nil {
+		t.Fatalf("MarshalToJSON() failed: %v", err)
+	}
+
+	// The same row, as encoding/json renders it with string fields
+	expected, err := json.Marshal(struct {
+		Active  string `json:"active"`
+		Name    string `json:"name"`
+		Parent  string `json:"parent"`
+		PID     string `json:"pid"`
+		Started string `json:"started"`
+	}{Active: "1", Name: `say "hi"`, PID: "42", Started: "1700000000"})
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("MarshalToJSON() = %s; expected %s", got, expected)
+	}
+
+	if _, err := MarshalToJSON(nil, 0); err == nil {
+		t.Errorf("MarshalToJSON(nil) succeeded; expected an error")
+	}
+}
+
+func TestMarshalRowsToJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		flags    EncodingFlag
+		expected string
+	}{
+		{
+			name:     "slice of structs",
+			input:    []jsonTestProcess{{PID: 1, Name: "init"}, {PID: 2, Name: "bash"}},
+			expected: `[{"active":"0","name":"init","parent":"","pid":"1","started":""},{"active":"0","name":"bash","parent":"","pid":"2","started":""}]`,
+		},
+		{
+			name:     "slice of maps",
+			input:    []map[string]any{{"b": 1, "a": "x"}},
+			expected: `[{"a":"x","b":"1"}]`,
+		},
+		{
+			name:     "nil slice",
+			input:    []jsonTestProcess(nil),
+			expected: `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalRowsToJSON(tt.input, tt.flags)
+			if err != nil {
+				t.Fatalf("MarshalRowsToJSON() failed: %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("MarshalRowsToJSON() = %s; expected %s", got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := MarshalRowsToJSON(jsonTestProcess{}, 0); err == nil {
+		t.Errorf("MarshalRowsToJSON() with a struct succeeded; expected an error")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"sort"
+)
+
+// KV is a key and its value in a row.
+type KV struct {
+	Key   string
+	Value string
+}
+
+// MarshalToPairs is like MarshalToMapWithFlags, but returns the columns in a deterministic
+// order: the order of the struct fields for structs, and the sorted order of the keys for
+// maps. The fields of embedded structs come first, as they can be shadowed by the fields of
+// the outer struct, which then keep the position of the promoted field.
+func MarshalToPairs(in any, flags EncodingFlag) ([]KV, error) {
+	return defaultEncoder.marshalPairs(in, flags)
+}
+
+// MarshalPairs is like Marshal, but returns the columns in the order documented in
+// MarshalToPairs.
+func (e *Encoder) MarshalPairs(in any) ([]KV, error) {
+	return e.marshalPairs(in, 0)
+}
+
+func (e *Encoder) marshalPairs(in any, flags EncodingFlag) ([]KV, error) {
+	order := []string{}
+	state := e.newState(flags)
+	state.order = &order
+	result, err := marshalToMap(in, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if isMapInput(in) {
+		sort.Strings(order)
+	}
+	pairs := make([]KV, len(order))
+	for i, key := range order {
+		pairs[i] = KV{Key: key, Value: result[key]}
+	}
+	return pairs, nil
+}
+
+// isMapInput reports whether in is a map or a pointer to one, whose keys have no order.
+func isMapInput(in any) bool {
+	t := reflect.TypeOf(in)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Map
+}
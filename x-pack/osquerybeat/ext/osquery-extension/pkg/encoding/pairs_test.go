@@ -0,0 +1,121 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalToPairs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		flags    EncodingFlag
+		expected []KV
+		err      bool
+	}{
+		{
+			name: "struct fields in declaration order",
+			input: &struct {
+				Name    string            `osquery:"name"`
+				Process testProcess       `osquery:"process"`
+				Count   int               `osquery:"count"`
+				Labels  map[string]string `osquery:"labels"`
+				Empty   string            `osquery:"empty,omitempty"`
+			}{
+				Name:    "osqueryd",
+				Process: testProcess{PID: 42, Name: "osqueryd"},
+				Labels:  map[string]string{"team": "a", "env": "prod"},
+			},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: []KV{
+				{Key: "name", Value: "osqueryd"},
+				{Key: "process.pid", Value: "42"},
+				{Key: "process.name", Value: "osqueryd"},
+				{Key: "process.started", Value: "0001-01-01T00:00:00Z"},
+				{Key: "count", Value: "0"},
+				{Key: "labels.env", Value: "prod"},
+				{Key: "labels.team", Value: "a"},
+			},
+		},
+		{
+			name: "promoted fields come first",
+			input: &struct {
+				Name string `osquery:"name"`
+				testHost
+				OS string `osquery:"os"`
+			}{Name: "a", testHost: testHost{Hostname: "b", OS: "c"}, OS: "d"},
+			expected: []KV{
+				{Key: "hostname", Value: "b"},
+				{Key: "os", Value: "d"},
+				{Key: "name", Value: "a"},
+			},
+		},
+		{
+			name:  "map entries in sorted order",
+			input: map[string]any{"b": 2, "c": "3", "a": true},
+			expected: []KV{
+				{Key: "a", Value: "1"},
+				{Key: "b", Value: "2"},
+				{Key: "c", Value: "3"},
+			},
+		},
+		{
+			name:     "empty struct",
+			input:    struct{}{},
+			expected: []KV{},
+		},
+		{
+			name:  "conversion errors",
+			input: &struct{ Value testFailing }{},
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToPairs(tt.input, tt.flags)
+			if (err != nil) != tt.err {
+				t.Fatalf("MarshalToPairs() error = %v; expected error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToPairs() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncoder_MarshalPairs(t *testing.T) {
+	enc := NewEncoder(Options{KeyFunc: strings.ToUpper})
+	got, err := enc.MarshalPairs(map[string]string{"b": "2", "a": "1"})
+	if err != nil {
+		t.Fatalf("MarshalPairs() failed: %v", err)
+	}
+	expected := []KV{{Key: "A", Value: "1"}, {Key: "B", Value: "2"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MarshalPairs() = %v; expected %v", got, expected)
+	}
+
+	// The pairs hold the same columns as the map
+	in := newBenchmarkRow()
+	pairs, err := enc.MarshalPairs(in)
+	if err != nil {
+		t.Fatalf("MarshalPairs() failed: %v", err)
+	}
+	row, err := enc.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if len(pairs) != len(row) {
+		t.Fatalf("MarshalPairs() returned %d pairs; expected %d", len(pairs), len(row))
+	}
+	for _, kv := range pairs {
+		if row[kv.Key] != kv.Value {
+			t.Errorf("MarshalPairs() %s = %q; expected %q", kv.Key, kv.Value, row[kv.Key])
+		}
+	}
+}
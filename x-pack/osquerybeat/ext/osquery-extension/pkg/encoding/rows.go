@@ -0,0 +1,195 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// MarshalRows converts each element of a slice or array of structs or maps, or a pointer
+// to one, into a row as documented in MarshalToMap. It returns an empty non-nil slice for
+// nil and empty slices, so that the result can be returned as is by an osquery table.
+// Elements of other kinds, like the strings of a []string, are reported as errors, as they
+// have no column name: wrap them in a struct to produce a single-column table.
+func MarshalRows(in any, flags EncodingFlag) ([]map[string]string, error) {
+	return defaultEncoder.marshalRows(in, flags)
+}
+
+// MarshalRows is like Marshal, but converts each element of a slice or array as documented
+// in the MarshalRows function.
+func (e *Encoder) MarshalRows(in any) ([]map[string]string, error) {
+	return e.marshalRows(in, 0)
+}
+
+func (e *Encoder) marshalRows(in any, flags EncodingFlag) ([]map[string]string, error) {
+	if in == nil {
+		return nil, fmt.Errorf("input cannot be nil")
+	}
+
+	v := reflect.ValueOf(in)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, fmt.Errorf("input pointer is nil")
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("unsupported type: %s, must be a slice, array, or pointer to one of them", v.Kind())
+	}
+
+	rows := make([]map[string]string, v.Len())
+	for i := range rows {
+		elem := v.Index(i)
+		// Marshal elements through a pointer when possible to avoid copying structs
+		if elem.Kind() == reflect.Struct && elem.CanAddr() {
+			elem = elem.Addr()
+		}
+
+		row, err := e.marshal(elem.Interface(), flags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal row %d: %w", i, err)
+		}
+		rows[i] = row
+	}
+
+	return rows, nil
+}
+
+// MarshalRowsNonEmpty is like MarshalRows, but leaves out the rows whose values are all
+// empty strings, including the rows without columns, as they carry no information.
+func MarshalRowsNonEmpty(in any, flags EncodingFlag) ([]map[string]string, error) {
+	return defaultEncoder.marshalRowsNonEmpty(in, flags)
+}
+
+// MarshalRowsNonEmpty is like MarshalRows, but leaves out the empty rows as documented in the
+// MarshalRowsNonEmpty function.
+func (e *Encoder) MarshalRowsNonEmpty(in any) ([]map[string]string, error) {
+	return e.marshalRowsNonEmpty(in, 0)
+}
+
+func (e *Encoder) marshalRowsNonEmpty(in any, flags EncodingFlag) ([]map[string]string, error) {
+	rows, err := e.marshalRows(in, flags)
+	if err != nil {
+		return nil, err
+	}
+	nonEmpty := rows[:0]
+	for _, row := range rows {
+		if !isEmptyRow(row) {
+			nonEmpty = append(nonEmpty, row)
+		}
+	}
+	// Clear the leftover rows of the backing array, so that they can be garbage collected
+	clear(rows[len(nonEmpty):])
+	return nonEmpty, nil
+}
+
+// isEmptyRow reports whether all the values of row are empty strings.
+func isEmptyRow(row map[string]string) bool {
+	for _, value := range row {
+		if value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// RowHash returns the hex SHA-256 hash of the keys and values of row, e.g. to detect that a
+// row changed between two queries of a table and skip emitting it again. The hash doesn't
+// depend on the order of the keys, and rows with the same keys and values have the same
+// hash. It is meant for change detection, not for security: the hash is not keyed, and the
+// values of a row can be guessed from it.
+func RowHash(row map[string]string) string {
+	keys := make([]string, 0, len(row))
+	for key := range row {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// The lengths prefixing the keys and values make the hashed bytes unambiguous, even when
+	// they contain the separators
+	h := sha256.New()
+	var buf []byte
+	for _, key := range keys {
+		value := row[key]
+		buf = strconv.AppendInt(buf[:0], int64(len(key)), 10)
+		buf = append(buf, ':')
+		buf = append(buf, key...)
+		buf = append(buf, '=')
+		buf = strconv.AppendInt(buf, int64(len(value)), 10)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+		buf = append(buf, ';')
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DiffRows returns the changes between the rows before and after, e.g. to emit minimal
+// change events, mapping each key whose value differs to its [before, after] values. Keys
+// present in a single row are included, with "" as the value of the row missing them, so a
+// key set to "" in only one of the rows is reported too. The result is empty, but not nil,
+// when the rows are equal.
+func DiffRows(before, after map[string]string) map[string][2]string {
+	diff := make(map[string][2]string)
+	for key, value := range before {
+		if afterValue, ok := after[key]; !ok || afterValue != value {
+			diff[key] = [2]string{value, afterValue}
+		}
+	}
+	for key, value := range after {
+		if _, ok := before[key]; !ok {
+			diff[key] = [2]string{"", value}
+		}
+	}
+	return diff
+}
+
+// DedupeRows returns the rows without the duplicates of a previous row, keeping the rows in
+// the order of their first occurrence. Rows are compared by their RowHash. The returned
+// slice is new, but shares the rows with the input, and is nil only when rows is nil.
+func DedupeRows(rows []map[string]string) []map[string]string {
+	if rows == nil {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(rows))
+	unique := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		hash := RowHash(row)
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+		unique = append(unique, row)
+	}
+	return unique
+}
+
+// SortRows sorts rows in place by the values of the columns by, e.g. to make the output of a
+// table deterministic in tests. Rows are compared on the first column, then on the next
+// columns for the rows with equal values. The comparison is lexicographic on the string
+// values, and not aware of their types, so "10" sorts before "9". Missing columns compare
+// as "". The sort is stable, rows with equal values on all the columns keep their order, and
+// rows are not reordered when by is empty.
+func SortRows(rows []map[string]string, by ...string) {
+	if len(by) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, column := range by {
+			a, b := rows[i][column], rows[j][column]
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,357 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMarshalRows(t *testing.T) {
+	type row struct {
+		PID  int    `osquery:"pid"`
+		Name string `osquery:"name"`
+	}
+
+	tests := []struct {
+		name     string
+		input    any
+		flags    EncodingFlag
+		expected []map[string]string
+		err      string
+	}{
+		{
+			name:  "slice of structs",
+			input: []row{{PID: 1, Name: "init"}, {PID: 2, Name: "bash"}},
+			expected: []map[string]string{
+				{"pid": "1", "name": "init"},
+				{"pid": "2", "name": "bash"},
+			},
+		},
+		{
+			name:  "pointer to slice of struct pointers",
+			input: &[]*row{{PID: 1}},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: []map[string]string{
+				{"pid": "1", "name": ""},
+			},
+		},
+		{
+			name:  "array of maps",
+			input: [2]map[string]any{{"pid": 1}, {"name": "bash"}},
+			expected: []map[string]string{
+				{"pid": "1"},
+				{"name": "bash"},
+			},
+		},
+		{
+			name:     "nil slice",
+			input:    []row(nil),
+			expected: []map[string]string{},
+		},
+		{
+			name:     "empty slice",
+			input:    []row{},
+			expected: []map[string]string{},
+		},
+		{
+			name:  "nil element",
+			input: []*row{{PID: 1}, nil},
+			err:   "failed to marshal row 1",
+		},
+		{
+			name:  "unsupported element",
+			input: []int{1},
+			err:   "failed to marshal row 0",
+		},
+		{
+			name:  "slice of strings",
+			input: []string{"a"},
+			err:   "failed to marshal row 0: unsupported type: string",
+		},
+		{
+			name:  "not a slice",
+			input: row{},
+			err:   "unsupported type",
+		},
+		{
+			name:  "nil input",
+			input: nil,
+			err:   "input cannot be nil",
+		},
+	}
+
+	for _, test := range tests {
+		rows, err := MarshalRows(test.input, test.flags)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: MarshalRows() error = %v; expected error containing %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: MarshalRows() failed: %v", test.name, err)
+			continue
+		}
+		if rows == nil || !reflect.DeepEqual(rows, test.expected) {
+			t.Errorf("%s: MarshalRows() = %#v; expected %#v", test.name, rows, test.expected)
+		}
+	}
+}
+
+func TestMarshalRowsNonEmpty(t *testing.T) {
+	type row struct {
+		Name    string `osquery:"name"`
+		PID     int    `osquery:"pid"`
+		Comment string `osquery:"comment,omitempty"`
+	}
+
+	rows, err := MarshalRowsNonEmpty([]any{
+		row{},
+		row{Name: "init"},
+		map[string]string{},
+		row{Comment: "note"},
+		map[string]string{"a": "", "b": ""},
+		&row{PID: 7},
+	}, 0)
+	if err != nil {
+		t.Fatalf("MarshalRowsNonEmpty() failed: %v", err)
+	}
+	expected := []map[string]string{
+		{"name": "init", "pid": ""},
+		{"name": "", "pid": "", "comment": "note"},
+		{"name": "", "pid": "7"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("MarshalRowsNonEmpty() = %v; expected %v", rows, expected)
+	}
+
+	// Zero numbers rendered as "0" are not empty
+	rows, err = MarshalRowsNonEmpty([]row{{}}, EncodingFlagUseNumbersZeroValues)
+	if err != nil || len(rows) != 1 {
+		t.Errorf("MarshalRowsNonEmpty() = %v, %v; expected a single row", rows, err)
+	}
+
+	rows, err = MarshalRowsNonEmpty([]row{{}, {}}, 0)
+	if err != nil || rows == nil || len(rows) != 0 {
+		t.Errorf("MarshalRowsNonEmpty() = %#v, %v; expected an empty non-nil slice", rows, err)
+	}
+
+	if _, err := MarshalRowsNonEmpty([]string{"a"}, 0); err == nil {
+		t.Errorf("MarshalRowsNonEmpty() with a []string succeeded; expected an error")
+	}
+}
+
+func TestRowHash(t *testing.T) {
+	row := map[string]string{"pid": "1", "name": "init", "path": "/sbin/init"}
+	same := map[string]string{"path": "/sbin/init", "name": "init", "pid": "1"}
+
+	hash := RowHash(row)
+	if len(hash) != 64 {
+		t.Errorf("RowHash() = %q; expected a hex SHA-256", hash)
+	}
+	if got := RowHash(same); got != hash {
+		t.Errorf("RowHash() of the same row = %s; expected %s", got, hash)
+	}
+	for i := 0; i < 10; i++ {
+		if got := RowHash(row); got != hash {
+			t.Fatalf("RowHash() = %s on call %d; expected %s", got, i, hash)
+		}
+	}
+
+	// Rows differing by a value, a key, or how keys and values are split all differ
+	different := []map[string]string{
+		{"pid": "2", "name": "init", "path": "/sbin/init"},
+		{"pid": "1", "name": "init"},
+		{"pid": "1", "name": "init", "path": "/sbin/init", "cmdline": ""},
+		{"a=b": "c"},
+		{"a": "b=c"},
+		{"a": "b;c=d"},
+		{"a": "b", "c": "d"},
+		{},
+	}
+	seen := map[string]int{}
+	for i, r := range different {
+		got := RowHash(r)
+		if got == hash {
+			t.Errorf("RowHash(%v) = the hash of %v", r, row)
+		}
+		if j, ok := seen[got]; ok {
+			t.Errorf("RowHash(%v) = RowHash(%v)", r, different[j])
+		}
+		seen[got] = i
+	}
+
+	if RowHash(nil) != RowHash(map[string]string{}) {
+		t.Errorf("RowHash(nil) differs from the hash of an empty row")
+	}
+}
+
+func TestDiffRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   map[string]string
+		after    map[string]string
+		expected map[string][2]string
+	}{
+		{
+			name:     "equal rows",
+			before:   map[string]string{"pid": "1", "name": "init"},
+			after:    map[string]string{"name": "init", "pid": "1"},
+			expected: map[string][2]string{},
+		},
+		{
+			name:     "changed value",
+			before:   map[string]string{"pid": "1", "state": "running"},
+			after:    map[string]string{"pid": "1", "state": "stopped"},
+			expected: map[string][2]string{"state": {"running", "stopped"}},
+		},
+		{
+			name:     "added key",
+			before:   map[string]string{"pid": "1"},
+			after:    map[string]string{"pid": "1", "exit_code": "0"},
+			expected: map[string][2]string{"exit_code": {"", "0"}},
+		},
+		{
+			name:     "removed key",
+			before:   map[string]string{"pid": "1", "parent": "0"},
+			after:    map[string]string{"pid": "1"},
+			expected: map[string][2]string{"parent": {"0", ""}},
+		},
+		{
+			name:     "empty value in a single row",
+			before:   map[string]string{"comment": ""},
+			after:    nil,
+			expected: map[string][2]string{"comment": {"", ""}},
+		},
+		{
+			name:     "added, removed and changed keys",
+			before:   map[string]string{"pid": "1", "name": "init", "user": "root"},
+			after:    map[string]string{"pid": "2", "name": "init", "path": "/sbin/init"},
+			expected: map[string][2]string{"pid": {"1", "2"}, "user": {"root", ""}, "path": {"", "/sbin/init"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffRows(tt.before, tt.after)
+			if got == nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("DiffRows() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDedupeRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     []map[string]string
+		expected []map[string]string
+	}{
+		{
+			name:     "nil rows",
+			rows:     nil,
+			expected: nil,
+		},
+		{
+			name:     "empty rows",
+			rows:     []map[string]string{},
+			expected: []map[string]string{},
+		},
+		{
+			name: "duplicates keep the first occurrence",
+			rows: []map[string]string{
+				{"pid": "2", "name": "sshd"},
+				{"pid": "1", "name": "init"},
+				{"name": "sshd", "pid": "2"},
+				{"pid": "1", "name": "init"},
+				{"pid": "3"},
+			},
+			expected: []map[string]string{
+				{"pid": "2", "name": "sshd"},
+				{"pid": "1", "name": "init"},
+				{"pid": "3"},
+			},
+		},
+		{
+			name: "rows differing by an empty column are kept",
+			rows: []map[string]string{
+				{"pid": "1"},
+				{"pid": "1", "comment": ""},
+			},
+			expected: []map[string]string{
+				{"pid": "1"},
+				{"pid": "1", "comment": ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DedupeRows(tt.rows)
+			if (got == nil) != (tt.expected == nil) || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("DedupeRows() = %#v; expected %#v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkDedupeRows(b *testing.B) {
+	rows := make([]map[string]string, 10000)
+	for i := range rows {
+		// Every row is repeated 4 times
+		pid := strconv.Itoa(i % (len(rows) / 4))
+		rows[i] = map[string]string{"pid": pid, "name": "process-" + pid, "path": "/usr/bin/process", "state": "running"}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DedupeRows(rows)
+	}
+}
+
+func TestSortRows(t *testing.T) {
+	newRows := func() []map[string]string {
+		return []map[string]string{
+			{"name": "sshd", "pid": "9", "id": "a"},
+			{"name": "init", "pid": "1", "id": "b"},
+			{"name": "sshd", "pid": "10", "id": "c"},
+			{"pid": "2", "id": "d"},
+			{"name": "init", "pid": "1", "id": "e"},
+		}
+	}
+	ids := func(rows []map[string]string) string {
+		var ids []string
+		for _, row := range rows {
+			ids = append(ids, row["id"])
+		}
+		return strings.Join(ids, ",")
+	}
+
+	tests := []struct {
+		name     string
+		by       []string
+		expected string
+	}{
+		{name: "no columns", by: nil, expected: "a,b,c,d,e"},
+		{name: "single column", by: []string{"name"}, expected: "d,b,e,a,c"},
+		{name: "ties fall through to the next column", by: []string{"name", "pid"}, expected: "d,b,e,c,a"},
+		{name: "values compare as strings", by: []string{"pid"}, expected: "b,e,c,d,a"},
+		{name: "missing column", by: []string{"ppid", "id"}, expected: "a,b,c,d,e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := newRows()
+			SortRows(rows, tt.by...)
+			if got := ids(rows); got != tt.expected {
+				t.Errorf("SortRows(%v) order = %s; expected %s", tt.by, got, tt.expected)
+			}
+		})
+	}
+}
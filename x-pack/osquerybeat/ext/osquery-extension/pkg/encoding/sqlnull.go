@@ -0,0 +1,28 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"strings"
+)
+
+// isSQLNull reports whether t is one of the nullable types of database/sql, like
+// sql.NullString, sql.NullInt64 or sql.Null[T]. They all hold the value in their first
+// field, followed by a Valid bool field.
+func isSQLNull(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.PkgPath() != "database/sql" ||
+		!strings.HasPrefix(t.Name(), "Null") || t.NumField() != 2 {
+		return false
+	}
+	valid := t.Field(1)
+	return valid.Name == "Valid" && valid.Type.Kind() == reflect.Bool
+}
+
+// sqlNullValue returns the value held by v, a database/sql nullable type, and whether it
+// is valid.
+func sqlNullValue(v reflect.Value) (reflect.Value, bool) {
+	return v.Field(0), v.Field(1).Bool()
+}
@@ -0,0 +1,306 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+type testSQLRow struct {
+	Name    sql.NullString     `osquery:"name"`
+	Size    sql.NullInt64      `osquery:"size"`
+	PID     sql.NullInt32      `osquery:"pid"`
+	Mode    sql.NullInt16      `osquery:"mode"`
+	Flag    sql.NullByte       `osquery:"flag"`
+	Score   sql.NullFloat64    `osquery:"score"`
+	Active  sql.NullBool       `osquery:"active"`
+	Started sql.NullTime       `osquery:"started" format:"unix"`
+	Port    sql.Null[uint16]   `osquery:"port"`
+	Parent  *sql.NullString    `osquery:"parent"`
+	Tags    sql.Null[[]string] `osquery:"tags"`
+}
+
+func TestMarshalToMap_sqlNull(t *testing.T) {
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		input    testSQLRow
+		flags    EncodingFlag
+		expected map[string]string
+	}{
+		{
+			name: "valid values",
+			input: testSQLRow{
+				Name:    sql.NullString{String: "osqueryd", Valid: true},
+				Size:    sql.NullInt64{Int64: 1 << 40, Valid: true},
+				PID:     sql.NullInt32{Int32: 42, Valid: true},
+				Mode:    sql.NullInt16{Int16: 420, Valid: true},
+				Flag:    sql.NullByte{Byte: 7, Valid: true},
+				Score:   sql.NullFloat64{Float64: 0.5, Valid: true},
+				Active:  sql.NullBool{Bool: true, Valid: true},
+				Started: sql.NullTime{Time: started, Valid: true},
+				Port:    sql.Null[uint16]{V: 8080, Valid: true},
+				Parent:  &sql.NullString{String: "launchd", Valid: true},
+				Tags:    sql.Null[[]string]{V: []string{"a", "b"}, Valid: true},
+			},
+			expected: map[string]string{
+				"name":    "osqueryd",
+				"size":    "1099511627776",
+				"pid":     "42",
+				"mode":    "420",
+				"flag":    "7",
+				"score":   "0.5",
+				"active":  "1",
+				"started": "1704164645",
+				"port":    "8080",
+				"parent":  "launchd",
+				"tags":    "a,b",
+			},
+		},
+		{
+			name: "invalid values",
+			input: testSQLRow{
+				Name:    sql.NullString{String: "ignored"},
+				Size:    sql.NullInt64{Int64: 1},
+				PID:     sql.NullInt32{Int32: 1},
+				Mode:    sql.NullInt16{Int16: 1},
+				Flag:    sql.NullByte{Byte: 1},
+				Score:   sql.NullFloat64{Float64: 1},
+				Active:  sql.NullBool{Bool: true},
+				Started: sql.NullTime{Time: started},
+				Port:    sql.Null[uint16]{V: 1},
+				Parent:  &sql.NullString{String: "ignored"},
+			},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"name":    "",
+				"size":    "",
+				"pid":     "",
+				"mode":    "",
+				"flag":    "",
+				"score":   "",
+				"active":  "",
+				"started": "",
+				"port":    "",
+				"parent":  "",
+				"tags":    "",
+			},
+		},
+		{
+			name: "valid zero values follow the flags",
+			input: testSQLRow{
+				Size:   sql.NullInt64{Valid: true},
+				Active: sql.NullBool{Valid: true},
+			},
+			flags: EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{
+				"name":    "",
+				"size":    "0",
+				"pid":     "",
+				"mode":    "",
+				"flag":    "",
+				"score":   "",
+				"active":  "0",
+				"started": "",
+				"port":    "",
+				"parent":  "",
+				"tags":    "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMapWithFlags(tt.input, tt.flags)
+			if err != nil {
+				t.Fatalf("MarshalToMapWithFlags() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateColumnDefinitions_sqlNull(t *testing.T) {
+	got, err := GenerateColumnDefinitions(testSQLRow{})
+	if err != nil {
+		t.Fatalf("GenerateColumnDefinitions() failed: %v", err)
+	}
+	expected := []table.ColumnDefinition{
+		table.TextColumn("name"),
+		table.BigIntColumn("size"),
+		table.IntegerColumn("pid"),
+		table.IntegerColumn("mode"),
+		table.IntegerColumn("flag"),
+		table.DoubleColumn("score"),
+		table.IntegerColumn("active"),
+		table.BigIntColumn("started"),
+		table.IntegerColumn("port"),
+		table.TextColumn("parent"),
+		table.TextColumn("tags"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("GenerateColumnDefinitions() = %v; expected %v", got, expected)
+	}
+
+	if err := Validate(testSQLRow{}); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
+
+func TestUnmarshalMap_sqlNull(t *testing.T) {
+	in := testSQLRow{
+		Name:    sql.NullString{String: "osqueryd", Valid: true},
+		Size:    sql.NullInt64{Int64: 1 << 40, Valid: true},
+		Active:  sql.NullBool{Valid: true},
+		Started: sql.NullTime{Time: time.Unix(1704164645, 0).UTC(), Valid: true},
+		Port:    sql.Null[uint16]{V: 8080, Valid: true},
+		Parent:  &sql.NullString{String: "launchd", Valid: true},
+		Tags:    sql.Null[[]string]{},
+	}
+
+	m, err := MarshalToMap(in)
+	if err != nil {
+		t.Fatalf("MarshalToMap() failed: %v", err)
+	}
+
+	var out testSQLRow
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap(%v) failed: %v", m, err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v; expected %+v", out, in)
+	}
+}
+
+// testDecimal is a fixed-point decimal implementing driver.Valuer and sql.Scanner.
+type testDecimal struct {
+	units int64
+	scale int
+}
+
+func (d testDecimal) Value() (driver.Value, error) {
+	if d.scale < 0 {
+		return nil, errors.New("negative scale")
+	}
+	s := strconv.FormatInt(d.units, 10)
+	if d.scale == 0 {
+		return s, nil
+	}
+	for len(s) <= d.scale {
+		s = "0" + s
+	}
+	return s[:len(s)-d.scale] + "." + s[len(s)-d.scale:], nil
+}
+
+func (d *testDecimal) Scan(src any) error {
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("unsupported source %T", src)
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return err
+	}
+	*d = testDecimal{units: units, scale: len(frac)}
+	return nil
+}
+
+// testNullableInt is a custom nullable type implementing driver.Valuer.
+type testNullableInt struct {
+	n     int64
+	valid bool
+}
+
+func (n testNullableInt) Value() (driver.Value, error) {
+	if !n.valid {
+		return nil, nil
+	}
+	return n.n, nil
+}
+
+func TestMarshalToMap_driverValuer(t *testing.T) {
+	type row struct {
+		Price testDecimal     `osquery:"price"`
+		Count testNullableInt `osquery:"count"`
+	}
+
+	tests := []struct {
+		name     string
+		input    row
+		flags    EncodingFlag
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:     "values are converted through the normal path",
+			input:    row{Price: testDecimal{units: 1999, scale: 2}, Count: testNullableInt{n: 3, valid: true}},
+			expected: map[string]string{"price": "19.99", "count": "3"},
+		},
+		{
+			name:     "nil values are empty",
+			input:    row{Price: testDecimal{units: 5}, Count: testNullableInt{}},
+			expected: map[string]string{"price": "5", "count": ""},
+		},
+		{
+			name:     "zero values follow the flags",
+			input:    row{Count: testNullableInt{valid: true}},
+			flags:    EncodingFlagUseNumbersZeroValues,
+			expected: map[string]string{"price": "0", "count": "0"},
+		},
+		{
+			name:  "errors are reported",
+			input: row{Price: testDecimal{scale: -1}},
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalToMapWithFlags(tt.input, tt.flags)
+			if (err != nil) != tt.err {
+				t.Fatalf("MarshalToMapWithFlags() error = %v; expected error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MarshalToMapWithFlags() = %v; expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	cols, err := GenerateColumnDefinitions(row{})
+	if err != nil {
+		t.Fatalf("GenerateColumnDefinitions() failed: %v", err)
+	}
+	expectedCols := []table.ColumnDefinition{table.TextColumn("price"), table.TextColumn("count")}
+	if !reflect.DeepEqual(cols, expectedCols) {
+		t.Errorf("GenerateColumnDefinitions() = %v; expected %v", cols, expectedCols)
+	}
+}
+
+func TestUnmarshalMap_sqlScanner(t *testing.T) {
+	var out struct {
+		Price testDecimal  `osquery:"price"`
+		Ptr   *testDecimal `osquery:"ptr"`
+	}
+	if err := UnmarshalMap(map[string]string{"price": "19.99", "ptr": "7"}, &out); err != nil {
+		t.Fatalf("UnmarshalMap() failed: %v", err)
+	}
+	if out.Price != (testDecimal{units: 1999, scale: 2}) || out.Ptr == nil || *out.Ptr != (testDecimal{units: 7}) {
+		t.Errorf("UnmarshalMap() = %+v", out)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// GenerateRows returns a table.GenerateFunc calling producer and converting the slice or array
+// it returns into rows with MarshalRows, e.g.
+//
+//	table.NewPlugin("processes", columns, encoding.GenerateRows(listProcesses, 0))
+//
+// The errors of producer are returned as is, and a nil result, or a nil pointer, produces no
+// rows.
+func GenerateRows(producer func(ctx context.Context, queryContext table.QueryContext) (any, error), flags EncodingFlag) table.GenerateFunc {
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		result, err := producer(ctx, queryContext)
+		if err != nil {
+			return nil, err
+		}
+		if v := reflect.ValueOf(result); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+			return []map[string]string{}, nil
+		}
+		return MarshalRows(result, flags)
+	}
+}
+
+// EqualConstraint returns the value of the first "=" constraint osquery pushed down for column,
+// e.g. "1" for `WHERE pid = 1`, so that a table can look up a single entry. Only the
+// table.OperatorEquals operator is considered: "IN" lists are split by osquery into one call
+// of the GenerateFunc per value, and the other operators like "<" or "GLOB" are ignored.
+func EqualConstraint(queryContext table.QueryContext, column string) (string, bool) {
+	for _, constraint := range queryContext.Constraints[column].Constraints {
+		if constraint.Operator == table.OperatorEquals {
+			return constraint.Expression, true
+		}
+	}
+	return "", false
+}
+
+// LikeConstraints returns the patterns of the "LIKE" constraints osquery pushed down for
+// column, e.g. "/usr/%" for `WHERE path LIKE '/usr/%'`, in the order of the query. Only the
+// table.OperatorLike operator is considered. The patterns are returned as is, with the SQL
+// wildcards "%" and "_", and as osquery still filters the rows, a table may return a
+// superset of the matching rows.
+func LikeConstraints(queryContext table.QueryContext, column string) []string {
+	var patterns []string
+	for _, constraint := range queryContext.Constraints[column].Constraints {
+		if constraint.Operator == table.OperatorLike {
+			patterns = append(patterns, constraint.Expression)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,123 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+func TestGenerateRows(t *testing.T) {
+	errProducer := errors.New("cannot list processes")
+
+	tests := []struct {
+		name     string
+		result   any
+		err      error
+		flags    EncodingFlag
+		expected []map[string]string
+	}{
+		{
+			name:   "slice of structs",
+			result: []testProcess{{PID: 1, Name: "init"}},
+			flags:  EncodingFlagUseNumbersZeroValues,
+			expected: []map[string]string{
+				{"pid": "1", "name": "init", "started": "0001-01-01T00:00:00Z"},
+			},
+		},
+		{
+			name:     "nil result",
+			result:   nil,
+			expected: []map[string]string{},
+		},
+		{
+			name:     "nil pointer",
+			result:   (*[]testProcess)(nil),
+			expected: []map[string]string{},
+		},
+		{
+			name:     "nil slice",
+			result:   []testProcess(nil),
+			expected: []map[string]string{},
+		},
+		{
+			name: "producer error",
+			err:  errProducer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got table.QueryContext
+			generate := GenerateRows(func(ctx context.Context, queryContext table.QueryContext) (any, error) {
+				got = queryContext
+				return tt.result, tt.err
+			}, tt.flags)
+
+			queryContext := table.QueryContext{Constraints: map[string]table.ConstraintList{"pid": {}}}
+			rows, err := generate(context.Background(), queryContext)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("GenerateFunc() error = %v; expected %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, queryContext) {
+				t.Errorf("producer called with %v; expected %v", got, queryContext)
+			}
+			if !reflect.DeepEqual(rows, tt.expected) {
+				t.Errorf("GenerateFunc() = %v; expected %v", rows, tt.expected)
+			}
+		})
+	}
+
+	generate := GenerateRows(func(context.Context, table.QueryContext) (any, error) {
+		return testProcess{}, nil
+	}, 0)
+	if _, err := generate(context.Background(), table.QueryContext{}); err == nil {
+		t.Errorf("GenerateFunc() succeeded for a struct result; expected an error")
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	queryContext := table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"pid": {Constraints: []table.Constraint{
+			{Operator: table.OperatorGreaterThan, Expression: "1"},
+			{Operator: table.OperatorEquals, Expression: "42"},
+			{Operator: table.OperatorEquals, Expression: "43"},
+		}},
+		"path": {Constraints: []table.Constraint{
+			{Operator: table.OperatorLike, Expression: "/usr/%"},
+			{Operator: table.OperatorGlob, Expression: "/opt/*"},
+			{Operator: table.OperatorLike, Expression: "%/osquery_"},
+		}},
+	}}
+
+	tests := []struct {
+		column   string
+		equal    string
+		hasEqual bool
+		like     []string
+	}{
+		{column: "pid", equal: "42", hasEqual: true},
+		{column: "path", like: []string{"/usr/%", "%/osquery_"}},
+		{column: "name"},
+	}
+
+	for _, tt := range tests {
+		equal, ok := EqualConstraint(queryContext, tt.column)
+		if equal != tt.equal || ok != tt.hasEqual {
+			t.Errorf("EqualConstraint(%s) = %q, %v; expected %q, %v", tt.column, equal, ok, tt.equal, tt.hasEqual)
+		}
+		if like := LikeConstraints(queryContext, tt.column); !reflect.DeepEqual(like, tt.like) {
+			t.Errorf("LikeConstraints(%s) = %q; expected %q", tt.column, like, tt.like)
+		}
+	}
+
+	if _, ok := EqualConstraint(table.QueryContext{}, "pid"); ok {
+		t.Errorf("EqualConstraint() found a constraint in an empty query context")
+	}
+}
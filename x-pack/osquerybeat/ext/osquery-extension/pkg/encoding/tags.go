@@ -0,0 +1,89 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"strings"
+)
+
+// tagOptions is the comma-separated list of options that follows the column name in an
+// "osquery" tag, e.g. "omitempty,sep=;" in `osquery:"tags,omitempty,sep=;"`.
+type tagOptions string
+
+// parseTag splits an "osquery" tag into the column name and its options. The name is empty
+// when the tag is empty or starts with a comma, e.g. `osquery:",omitempty"`.
+func parseTag(tag string) (string, tagOptions) {
+	name, options, _ := strings.Cut(tag, ",")
+	return name, tagOptions(options)
+}
+
+// Contains reports whether the bare option name, like "omitempty", is in the options.
+func (o tagOptions) Contains(name string) bool {
+	options := string(o)
+	for options != "" {
+		var opt string
+		opt, options, _ = strings.Cut(options, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the value of the "name=value" option with the given name.
+func (o tagOptions) Lookup(name string) (string, bool) {
+	options := string(o)
+	for options != "" {
+		var opt string
+		opt, options, _ = strings.Cut(options, ",")
+		if optName, value, ok := strings.Cut(opt, "="); ok && optName == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// knownTagOptions maps the supported "osquery" tag options to whether they take a value,
+// like "sep=;", or are bare, like "omitempty".
+var knownTagOptions = map[string]bool{
+	"layout":      true,
+	"duration":    true,
+	"sep":         true,
+	"default":     true,
+	"type":        true,
+	"prec":        true,
+	"base":        true,
+	"max":         true,
+	"bool":        true,
+	"prefix":      false,
+	"char":        false,
+	"bytesasnums": false,
+	"booltext":    false,
+	"ellipsis":    false,
+	"redact":      false,
+	"inline":      false,
+	"omitempty":   false,
+	"remaining":   false,
+	"string":      false,
+}
+
+// lookupTagOption returns the value of a "name=value" option from the "osquery" tag.
+func lookupTagOption(tag *reflect.StructTag, option string) (string, bool) {
+	if tag == nil {
+		return "", false
+	}
+	_, options := parseTag(tag.Get("osquery"))
+	return options.Lookup(option)
+}
+
+// hasTagOption reports whether a bare option, like "inline", is set in the "osquery" tag.
+func hasTagOption(tag *reflect.StructTag, option string) bool {
+	if tag == nil {
+		return false
+	}
+	_, options := parseTag(tag.Get("osquery"))
+	return options.Contains(option)
+}
@@ -0,0 +1,141 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag          string
+		name         string
+		options      tagOptions
+		contains     []string
+		notContains  []string
+		lookupName   string
+		lookupValue  string
+		lookupExists bool
+	}{
+		{
+			tag:  "",
+			name: "",
+		},
+		{
+			tag:  "pid",
+			name: "pid",
+		},
+		{
+			tag:         "pid,omitempty,string",
+			name:        "pid",
+			options:     "omitempty,string",
+			contains:    []string{"omitempty", "string"},
+			notContains: []string{"pid", "omit", "inline"},
+		},
+		{
+			tag:         ",omitempty",
+			name:        "",
+			options:     "omitempty",
+			contains:    []string{"omitempty"},
+			notContains: []string{""},
+		},
+		{
+			tag:          "tags,sep=;,omitempty",
+			name:         "tags",
+			options:      "sep=;,omitempty",
+			contains:     []string{"omitempty"},
+			notContains:  []string{"sep"},
+			lookupName:   "sep",
+			lookupValue:  ";",
+			lookupExists: true,
+		},
+		{
+			tag:          "created,layout=",
+			name:         "created",
+			options:      "layout=",
+			lookupName:   "layout",
+			lookupValue:  "",
+			lookupExists: true,
+		},
+		{
+			tag:          "created,omitempty",
+			name:         "created",
+			options:      "omitempty",
+			lookupName:   "omitempty",
+			lookupExists: false,
+		},
+		{
+			tag:  "-",
+			name: "-",
+		},
+	}
+
+	for _, test := range tests {
+		name, options := parseTag(test.tag)
+		if name != test.name || options != test.options {
+			t.Errorf("parseTag(%q) = %q, %q; expected %q, %q", test.tag, name, options, test.name, test.options)
+		}
+		for _, opt := range test.contains {
+			if !options.Contains(opt) {
+				t.Errorf("parseTag(%q) options do not contain %q", test.tag, opt)
+			}
+		}
+		for _, opt := range test.notContains {
+			if options.Contains(opt) {
+				t.Errorf("parseTag(%q) options unexpectedly contain %q", test.tag, opt)
+			}
+		}
+		if test.lookupName != "" {
+			value, ok := options.Lookup(test.lookupName)
+			if value != test.lookupValue || ok != test.lookupExists {
+				t.Errorf("parseTag(%q) options Lookup(%q) = %q, %v; expected %q, %v",
+					test.tag, test.lookupName, value, ok, test.lookupValue, test.lookupExists)
+			}
+		}
+	}
+}
+
+func TestFieldKey(t *testing.T) {
+	type tagged struct {
+		PID       int    `osquery:"pid,omitempty,string"`
+		Name      string `osquery:",omitempty"`
+		Path      string
+		Skipped   string `osquery:"-"`
+		SkipOpts  string `osquery:"-,omitempty"`
+		Dash      string `osquery:"-,"`
+		JSONSkip  string `json:"-"`
+		JSONDash  string `json:"-,"`
+		unexposed string //nolint:unused -- meaningful for test coverage
+	}
+
+	tests := []struct {
+		field string
+		flags EncodingFlag
+		key   string
+		ok    bool
+	}{
+		{field: "PID", key: "pid", ok: true},
+		{field: "Name", key: "Name", ok: true},
+		{field: "Path", key: "Path", ok: true},
+		{field: "Skipped", ok: false},
+		{field: "SkipOpts", ok: false},
+		{field: "Dash", key: "-", ok: true},
+		{field: "unexposed", ok: false},
+		// The json tag follows encoding/json, where only "-" skips the field
+		{field: "JSONSkip", key: "JSONSkip", ok: true},
+		{field: "JSONSkip", flags: EncodingFlagFallbackJSONTag, ok: false},
+		{field: "JSONDash", flags: EncodingFlagFallbackJSONTag, key: "-", ok: true},
+	}
+
+	typ := reflect.TypeFor[tagged]()
+	for _, test := range tests {
+		field, _ := typ.FieldByName(test.field)
+		key, ok := fieldKey(field, test.flags, defaultKeyTags)
+		if key != test.key || ok != test.ok {
+			t.Errorf("fieldKey(%s) = %q, %v; expected %q, %v", test.field, key, ok, test.key, test.ok)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Validate checks the fields and tags of a struct, or a pointer to one, for problems that
+// would otherwise only be found when marshaling, or not at all:
+//   - fields resolving to the same column name, except for fields shadowing promoted ones
+//   - unknown tag options, or options used with or without a value when they shouldn't
+//   - invalid "type", "duration", "bool" and time format options
+//   - "inline" options on fields that are not maps, or on several map fields of a struct
+//   - "char" options on fields that are not an int32, like a rune, or a slice of them
+//   - "bytesasnums" options on fields that are not byte slices or arrays
+//   - "remaining" options on fields that are not a map[string]string. These fields count as
+//     inline maps.
+//   - fields of types that cannot be marshaled without a custom marshaler, like complex
+//     numbers or slices of channels. Channel and function fields are skipped by the encoder.
+//
+// All the problems found are reported, joined in the returned error.
+func Validate(in any) error {
+	if in == nil {
+		return fmt.Errorf("input cannot be nil")
+	}
+
+	t := reflect.TypeOf(in)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported type: %s, must be a struct or pointer to struct", t.Kind())
+	}
+
+	v := &validator{
+		errs:    duplicateKeys(t, 0, defaultKeyTags),
+		parents: make(map[reflect.Type]bool),
+	}
+	v.validateStruct(t, "")
+	return errors.Join(v.errs...)
+}
+
+// validator accumulates the problems found by Validate.
+type validator struct {
+	parents map[reflect.Type]bool
+	errs    []error
+}
+
+func (v *validator) addf(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+// validateStruct checks the fields of the struct type t. path is the Go path of the struct,
+// e.g. "Process.".
+func (v *validator) validateStruct(t reflect.Type, path string) {
+	if v.parents[t] {
+		v.addf("field %s: recursive type %s", strings.TrimSuffix(path, "."), t)
+		return
+	}
+	v.parents[t] = true
+	defer delete(v.parents, t)
+
+	var inlineMap string
+	for _, field := range structFields(t, 0, defaultKeyTags) {
+		structField := t.Field(field.index)
+		fieldPath := path + structField.Name
+		fieldType := structField.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		v.validateOptions(fieldPath, &structField.Tag)
+
+		if field.kind != fieldMap && hasTagOption(&structField.Tag, "inline") {
+			v.addf("field %s: tag option \"inline\" requires a map field", fieldPath)
+		}
+		if hasTagOption(&structField.Tag, "char") && !isCharType(fieldType) {
+			v.addf("field %s: tag option \"char\" requires an int32 field", fieldPath)
+		}
+		if hasTagOption(&structField.Tag, "bytesasnums") && !isByteSequence(fieldType) {
+			v.addf("field %s: tag option \"bytesasnums\" requires a byte slice or array field", fieldPath)
+		}
+		if hasTagOption(&structField.Tag, "remaining") && structField.Type != stringMapType {
+			v.addf("field %s: tag option \"remaining\" requires a map[string]string field", fieldPath)
+		}
+
+		switch field.kind {
+		case fieldPromoted, fieldNested:
+			v.validateStruct(fieldType, fieldPath+".")
+			continue
+		case fieldMap:
+			// The keys of maps are only known at runtime, but a single map can be inlined
+			// without making the origin of its keys ambiguous
+			if field.inline {
+				if inlineMap != "" {
+					v.addf("fields %s and %s are both inline maps", inlineMap, fieldPath)
+				}
+				inlineMap = fieldPath
+			}
+			continue
+		}
+
+		v.validateType(fieldPath, fieldType, &structField.Tag)
+	}
+}
+
+// validateOptions checks that the options of the tag are known and have valid values.
+func (v *validator) validateOptions(fieldPath string, tag *reflect.StructTag) {
+	_, options := parseTag(tag.Get("osquery"))
+	for _, opt := range strings.Split(string(options), ",") {
+		if opt == "" {
+			continue
+		}
+		name, _, hasValue := strings.Cut(opt, "=")
+		takesValue, ok := knownTagOptions[name]
+		switch {
+		case !ok:
+			v.addf("field %s: unknown tag option %q", fieldPath, name)
+		case takesValue && !hasValue:
+			v.addf("field %s: tag option %q requires a value", fieldPath, name)
+		case !takesValue && hasValue:
+			v.addf("field %s: tag option %q does not take a value", fieldPath, name)
+		}
+	}
+
+	if name, ok := lookupTagOption(tag, "type"); ok {
+		if _, err := parseColumnType(name); err != nil {
+			v.addf("field %s: %w", fieldPath, err)
+		}
+	}
+
+	if _, err := floatPrecision(tag); err != nil {
+		v.addf("field %s: %w", fieldPath, err)
+	}
+	if _, _, err := integerBase(tag); err != nil {
+		v.addf("field %s: %w", fieldPath, err)
+	}
+	if _, err := maxLength(tag); err != nil {
+		v.addf("field %s: %w", fieldPath, err)
+	}
+	if _, _, _, err := boolStrings(tag); err != nil {
+		v.addf("field %s: %w", fieldPath, err)
+	}
+}
+
+// validateType checks that values of type t can be converted, and that the tag options
+// specific to the type are valid.
+func (v *validator) validateType(fieldPath string, t reflect.Type, tag *reflect.StructTag) {
+	switch t {
+	case timeType:
+		if _, err := timeLocation(tag); err != nil {
+			v.addf("field %s: %w", fieldPath, err)
+		}
+		if _, err := resolveTimeFormat(0, tag, DefaultTimeFormat); err != nil {
+			v.addf("field %s: %w", fieldPath, err)
+		}
+		return
+	case durationType:
+		if _, err := durationUnit(tag); err != nil {
+			v.addf("field %s: %w", fieldPath, err)
+		}
+		return
+	}
+
+	if !isMarshalable(t) {
+		v.addf("field %s: type %s cannot be marshaled without a custom marshaler", fieldPath, t)
+	}
+}
+
+// isMarshalable reports whether values of type t can be converted by
+// convertValueToString into a meaningful value.
+func isMarshalable(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType, durationType, rawMessageType:
+		return true
+	}
+	if isSQLNull(t) {
+		return isMarshalable(t.Field(0).Type)
+	}
+	if _, ok := lookupConverter(t); ok {
+		return true
+	}
+	if implements(t, osqueryMarshalerType) || implements(t, textMarshalerType) || implements(t, valuerType) {
+		return true
+	}
+	if !isScalarKind(t.Kind()) && implements(t, stringerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Struct,
+		reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Slice, reflect.Array:
+		return isMarshalable(t.Elem())
+	default:
+		return true
+	}
+}
+
+// isCharType reports whether the "char" option applies to values of type t, which are int32
+// values or slices and arrays of them.
+func isCharType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Int32
+}
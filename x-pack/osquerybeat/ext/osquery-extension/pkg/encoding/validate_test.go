@@ -0,0 +1,202 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package encoding
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type validateOS struct {
+	OS string `osquery:"os"`
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		problems []string
+	}{
+		{
+			name: "valid struct",
+			input: &struct {
+				testHost
+				Process  testProcess       `osquery:"process"`
+				Hostname string            `osquery:"hostname"`
+				Tags     []string          `osquery:"tags,sep=;,omitempty"`
+				Created  time.Time         `osquery:"created,layout=2006-01-02" tz:"UTC"`
+				Elapsed  time.Duration     `osquery:"elapsed,duration=ms,string"`
+				Raw      string            `osquery:"raw,type=BIGINT,default=0"`
+				Price    float64           `osquery:"price,prec=2"`
+				Cmdline  string            `osquery:"cmdline,max=4096,ellipsis"`
+				Enabled  bool              `osquery:"enabled,bool=on:off"`
+				Version  testVersion       `osquery:"version"`
+				Labels   map[string]string `osquery:"labels,inline"`
+				Skipped  chan int          `osquery:"-"`
+			}{},
+		},
+		{
+			name: "duplicate columns",
+			input: &struct {
+				Name    string `osquery:"name"`
+				Other   string `osquery:"name"`
+				Process struct {
+					PID int `osquery:"pid"`
+				} `osquery:"process"`
+				PID int `osquery:"process.pid"`
+			}{},
+			problems: []string{
+				"fields Name and Other both resolve to column name",
+				"fields Process.PID and PID both resolve to column process.pid",
+			},
+		},
+		{
+			name: "ambiguous promoted columns",
+			input: &struct {
+				testHost
+				validateOS
+			}{},
+			problems: []string{"fields testHost.OS and validateOS.OS both resolve to column os"},
+		},
+		{
+			name: "invalid options",
+			input: &struct {
+				Name    string        `osquery:"name,omitempty=true,unknown"`
+				Tags    []string      `osquery:"tags,sep"`
+				Raw     string        `osquery:"raw,type=BLOB"`
+				Elapsed time.Duration `osquery:"elapsed,duration=h"`
+				Created time.Time     `osquery:"created" format:"iso"`
+				Updated time.Time     `osquery:"updated" tz:"Nowhere/Invalid"`
+				Price   float64       `osquery:"price,prec=-2"`
+				Mode    uint32        `osquery:"mode,base=hex"`
+				Cmdline string        `osquery:"cmdline,max=0"`
+				Enabled bool          `osquery:"enabled,bool=on"`
+				Visible bool          `osquery:"visible,bool=yes:yes"`
+			}{},
+			problems: []string{
+				"field Name: tag option \"omitempty\" does not take a value",
+				"field Name: unknown tag option \"unknown\"",
+				"field Tags: tag option \"sep\" requires a value",
+				"field Raw: unsupported column type: BLOB",
+				"field Elapsed: unsupported duration unit: h",
+				"field Created: unsupported time format: iso",
+				"field Updated:",
+				"field Price: invalid float precision: -2",
+				"field Mode: unsupported integer base: hex",
+				"field Cmdline: invalid maximum length: 0",
+				"field Enabled: invalid bool strings: on",
+				"field Visible: invalid bool strings: yes:yes",
+			},
+		},
+		{
+			name: "unsupported types",
+			input: &struct {
+				Events   chan int        `osquery:"events"`
+				Callback func()          `osquery:"callback"`
+				Handlers []func()        `osquery:"handlers"`
+				Decoder  testBothDecoder `osquery:"decoder"`
+				Level    *testLevel      `osquery:"level"`
+				Phase    complex128      `osquery:"phase"`
+			}{},
+			problems: []string{
+				"field Handlers: type []func() cannot be marshaled",
+				"field Decoder: type encoding.testBothDecoder cannot be marshaled",
+				"field Phase: type complex128 cannot be marshaled",
+			},
+		},
+		{
+			name: "inline options",
+			input: &struct {
+				Labels  map[string]string `osquery:",inline"`
+				Env     map[string]any    `osquery:"env,inline"`
+				Name    string            `osquery:"name,inline"`
+				Process testProcess       `osquery:"process,inline"`
+				Nested  struct {
+					Labels map[string]string `osquery:",inline"`
+				} `osquery:"nested"`
+			}{},
+			problems: []string{
+				"fields Labels and Env are both inline maps",
+				"field Name: tag option \"inline\" requires a map field",
+				"field Process: tag option \"inline\" requires a map field",
+			},
+		},
+		{
+			name: "remaining options",
+			input: &struct {
+				Extra  map[string]string `osquery:",remaining"`
+				Labels map[string]string `osquery:"labels,inline"`
+				Nested struct {
+					Extra map[string]any `osquery:",remaining"`
+				} `osquery:"nested"`
+			}{},
+			problems: []string{
+				"fields Extra and Labels are both inline maps",
+				"field Nested.Extra: tag option \"remaining\" requires a map[string]string field",
+			},
+		},
+		{
+			name: "char option",
+			input: struct {
+				Flag  rune    `osquery:"flag,char"`
+				Flags []int32 `osquery:"flags,char"`
+				Code  int     `osquery:"code,char"`
+				Name  string  `osquery:"name,char"`
+			}{},
+			problems: []string{
+				"field Code: tag option \"char\" requires an int32 field",
+				"field Name: tag option \"char\" requires an int32 field",
+			},
+		},
+		{
+			name: "bytesasnums option",
+			input: struct {
+				Codes  []uint8 `osquery:"codes,bytesasnums"`
+				Octets [4]byte `osquery:"octets,bytesasnums"`
+				Ports  []int   `osquery:"ports,bytesasnums"`
+			}{},
+			problems: []string{"field Ports: tag option \"bytesasnums\" requires a byte slice or array field"},
+		},
+		{
+			name:     "recursive type",
+			input:    testNode{},
+			problems: []string{"field Next: recursive type encoding.testNode"},
+		},
+	}
+
+	for _, test := range tests {
+		err := Validate(test.input)
+		if len(test.problems) == 0 {
+			if err != nil {
+				t.Errorf("%s: Validate() failed: %v", test.name, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: Validate() succeeded; expected problems %q", test.name, test.problems)
+			continue
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(test.problems) {
+			t.Errorf("%s: Validate() reported %d problems; expected %d: %v", test.name, len(lines), len(test.problems), err)
+		}
+		for _, problem := range test.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: Validate() = %v; expected it to report %q", test.name, err, problem)
+			}
+		}
+	}
+}
+
+func TestValidate_invalidInput(t *testing.T) {
+	if err := Validate(nil); err == nil {
+		t.Error("expected error for nil input, got nil")
+	}
+	if err := Validate(map[string]string{}); err == nil {
+		t.Error("expected error for non-struct input, got nil")
+	}
+}